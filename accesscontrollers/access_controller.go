@@ -0,0 +1,101 @@
+package accesscontrollers
+
+import (
+	"errors"
+	"fmt"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/identities/identitytypes"
+	"orbitdb/go-orbitdb/keystore"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+	"strings"
+	"sync"
+)
+
+// AccessController decides which identities may write to a database.
+type AccessController interface {
+	oplog.AccessController
+
+	// Type returns the type the access controller is registered under, e.g. "ipfs".
+	Type() string
+
+	// Address returns the /<type>/<hash> address the access controller can be loaded from.
+	Address() string
+}
+
+// Params are passed to a Factory when a database creates or loads its access controller.
+type Params struct {
+	Identity *identitytypes.Identity // Identity opening the database
+	Address  string                  // Address of an existing access controller to load, empty to create one
+	Storage  storage.Storage         // Storage for the access controller's manifest
+	Name     string                  // Name of the database the access controller guards
+
+	// Used by access controllers that keep their state in a replicated database
+	IdentityProvider oplog.IdentityProvider
+	KeyStore         *keystore.KeyStore
+	Host             host.Host
+	PubSub           *pubsub.PubSub
+	Directory        string             // Directory for the access controller's own log; kept in memory when empty
+	DatabaseOptions  []databases.Option // Options for the access controller's own database, e.g. its transport or sync mode
+}
+
+// Factory creates an access controller, or loads it when Params.Address is set.
+type Factory func(params Params) (AccessController, error)
+
+// accessControllerRegistry stores the factories used to load access controllers by type.
+var (
+	accessControllerRegistry = make(map[string]Factory)
+	registryMu               sync.RWMutex
+)
+
+// RegisterAccessController registers a factory for loading access controllers of the given type.
+func RegisterAccessController(acType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	accessControllerRegistry[acType] = factory
+}
+
+// GetAccessController retrieves the factory registered for the given type.
+func GetAccessController(acType string) (Factory, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, exists := accessControllerRegistry[acType]
+	if !exists {
+		return nil, errors.New("access controller not found")
+	}
+	return factory, nil
+}
+
+// ParseAddress splits an access controller address of the form /<type>/<hash>.
+func ParseAddress(address string) (string, string, error) {
+	parts := strings.Split(strings.Trim(address, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("not a valid access controller address: %s", address)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Load loads the access controller at the given address using the factory registered for its type.
+func Load(address string, params Params) (AccessController, error) {
+	acType, _, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	factory, err := GetAccessController(acType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load access controller %s: %w", address, err)
+	}
+
+	params.Address = address
+	return factory(params)
+}
+
+// init registers the default access controllers.
+func init() {
+	RegisterAccessController(IPFSAccessControllerType, IPFSAccessController())
+	RegisterAccessController(OrbitDBAccessControllerType, OrbitDBAccessController())
+}
@@ -0,0 +1,71 @@
+package accesscontrollers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+)
+
+// denyAll is an access controller that rejects every entry.
+type denyAll struct{}
+
+func (denyAll) Type() string    { return "deny" }
+func (denyAll) Address() string { return "/deny/all" }
+func (denyAll) CanAppend(*oplog.EncodedEntry, oplog.IdentityProvider) (bool, error) {
+	return false, nil
+}
+
+func TestRegisterAndGetAccessController(t *testing.T) {
+	RegisterAccessController("deny", func(params Params) (AccessController, error) {
+		return denyAll{}, nil
+	})
+
+	factory, err := GetAccessController("deny")
+	require.NoError(t, err)
+
+	ac, err := factory(Params{})
+	require.NoError(t, err)
+	assert.Equal(t, "deny", ac.Type())
+}
+
+func TestGetAccessControllerNotFound(t *testing.T) {
+	_, err := GetAccessController("unknown")
+	assert.Error(t, err)
+}
+
+func TestIPFSAccessControllerIsRegistered(t *testing.T) {
+	_, err := GetAccessController(IPFSAccessControllerType)
+	assert.NoError(t, err)
+}
+
+func TestParseAddress(t *testing.T) {
+	acType, hash, err := ParseAddress("/ipfs/zdpuabc")
+	require.NoError(t, err)
+	assert.Equal(t, "ipfs", acType)
+	assert.Equal(t, "zdpuabc", hash)
+
+	for _, invalid := range []string{"", "/", "/ipfs", "ipfs/a/b"} {
+		_, _, err := ParseAddress(invalid)
+		assert.Error(t, err, "Expected %q to be rejected", invalid)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s := storage.NewMemoryStorage()
+
+	created, err := IPFSAccessController("writer1", "writer2")(Params{Storage: s})
+	require.NoError(t, err)
+
+	loaded, err := Load(created.Address(), Params{Storage: s})
+	require.NoError(t, err)
+	assert.Equal(t, created.Address(), loaded.Address())
+	assert.Equal(t, []string{"writer1", "writer2"}, loaded.(*ipfsAccessController).Write())
+}
+
+func TestLoadUnknownType(t *testing.T) {
+	_, err := Load("/unknown/zdpuabc", Params{Storage: storage.NewMemoryStorage()})
+	assert.Error(t, err)
+}
@@ -0,0 +1,212 @@
+package accesscontrollers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"orbitdb/go-orbitdb/oplog"
+)
+
+// IPFSAccessControllerType is the type of the access controller created by IPFSAccessController.
+const IPFSAccessControllerType = "ipfs"
+
+// Wildcard in a write list allows every identity to write.
+const Wildcard = "*"
+
+// ipfsAccessController allows a fixed list of identity IDs to write. The list is stored
+// as a dag-cbor manifest and cannot be changed once the controller has been created.
+type ipfsAccessController struct {
+	address string
+	write   []string
+}
+
+// IPFSAccessController returns a Factory for an access controller that only allows the
+// given identity IDs to write. Use Wildcard to allow everyone. When no IDs are given,
+// only the identity opening the database may write.
+func IPFSAccessController(write ...string) Factory {
+	return func(params Params) (AccessController, error) {
+		if params.Storage == nil {
+			return nil, errors.New("storage is required")
+		}
+
+		if params.Address != "" {
+			return loadIPFSAccessController(params)
+		}
+
+		allowed := append([]string{}, write...)
+		if len(allowed) == 0 {
+			if params.Identity == nil {
+				return nil, errors.New("write list or identity is required")
+			}
+			allowed = []string{params.Identity.ID}
+		}
+
+		data, err := encodeWriteList(allowed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode access controller: %w", err)
+		}
+
+		_, hash, err := oplog.ComputeCID(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := params.Storage.Put(hash, data); err != nil {
+			return nil, fmt.Errorf("failed to store access controller: %w", err)
+		}
+
+		return &ipfsAccessController{
+			address: "/" + IPFSAccessControllerType + "/" + hash,
+			write:   allowed,
+		}, nil
+	}
+}
+
+// loadIPFSAccessController reads the write list stored at params.Address.
+func loadIPFSAccessController(params Params) (AccessController, error) {
+	acType, hash, err := ParseAddress(params.Address)
+	if err != nil {
+		return nil, err
+	}
+	if acType != IPFSAccessControllerType {
+		return nil, fmt.Errorf("access controller %s is of type '%s', not '%s'", params.Address, acType, IPFSAccessControllerType)
+	}
+
+	data, err := params.Storage.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access controller %s: %w", params.Address, err)
+	}
+
+	_, computed, err := oplog.ComputeCID(data)
+	if err != nil {
+		return nil, err
+	}
+	if computed != hash {
+		return nil, fmt.Errorf("access controller hash mismatch: expected %s, got %s", hash, computed)
+	}
+
+	write, err := decodeWriteList(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode access controller %s: %w", params.Address, err)
+	}
+
+	return &ipfsAccessController{address: params.Address, write: write}, nil
+}
+
+// Type returns "ipfs".
+func (ac *ipfsAccessController) Type() string {
+	return IPFSAccessControllerType
+}
+
+// Address returns the /ipfs/<hash> address of the write list.
+func (ac *ipfsAccessController) Address() string {
+	return ac.address
+}
+
+// Write returns the identity IDs allowed to write.
+func (ac *ipfsAccessController) Write() []string {
+	return append([]string{}, ac.write...)
+}
+
+// CanAppend allows the entry if the identity that signed it is in the write list, or the
+// list contains the wildcard, and the identity is valid.
+func (ac *ipfsAccessController) CanAppend(entry *oplog.EncodedEntry, identityProvider oplog.IdentityProvider) (bool, error) {
+	if identityProvider == nil {
+		return false, errors.New("identity provider is required")
+	}
+
+	identity, err := identityProvider.GetIdentity(entry.Identity)
+	if err != nil {
+		return false, err
+	}
+	if identity == nil {
+		return false, nil
+	}
+
+	for _, id := range ac.write {
+		if id == identity.ID || id == Wildcard {
+			return identityProvider.VerifyIdentity(identity), nil
+		}
+	}
+
+	return false, nil
+}
+
+// encodeWriteList encodes the write list as a dag-cbor map {write: [...]}.
+func encodeWriteList(write []string) ([]byte, error) {
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := assembleStringList(ma, "write", write); err != nil {
+		return nil, err
+	}
+	if err := ma.Finish(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(nb.Build(), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// assembleStringList adds a list of strings to a map under the given key.
+func assembleStringList(ma datamodel.MapAssembler, key string, values []string) error {
+	if err := ma.AssembleKey().AssignString(key); err != nil {
+		return err
+	}
+
+	la, err := ma.AssembleValue().BeginList(int64(len(values)))
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := la.AssembleValue().AssignString(value); err != nil {
+			return err
+		}
+	}
+	return la.Finish()
+}
+
+// decodeWriteList decodes a dag-cbor encoded write list.
+func decodeWriteList(data []byte) ([]string, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return getStringList(nb.Build(), "write")
+}
+
+// getStringList reads a list of strings from a field of a decoded map.
+func getStringList(node datamodel.Node, field string) ([]string, error) {
+	listNode, err := node.LookupByString(field)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or missing '%s' field", field)
+	}
+
+	iter := listNode.ListIterator()
+	if iter == nil {
+		return nil, fmt.Errorf("invalid '%s' field: expected a list", field)
+	}
+
+	values := make([]string, 0, listNode.Length())
+	for !iter.Done() {
+		_, item, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := item.AsString()
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' field: %w", field, err)
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
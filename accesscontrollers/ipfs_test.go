@@ -0,0 +1,116 @@
+package accesscontrollers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/identities"
+	"orbitdb/go-orbitdb/identities/identitytypes"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+)
+
+// setupIdentities creates an Identities manager with a writer and another identity.
+func setupIdentities(t *testing.T) (*identities.Identities, *identitytypes.Identity, *identitytypes.Identity) {
+	ids, err := identities.NewIdentities("publickey", storage.NewMemoryStorage())
+	require.NoError(t, err)
+
+	writer, err := ids.CreateIdentity("writer")
+	require.NoError(t, err)
+
+	other, err := ids.CreateIdentity("other")
+	require.NoError(t, err)
+
+	return ids, writer, other
+}
+
+func newTestEntry(ids *identities.Identities, identity *identitytypes.Identity) *oplog.EncodedEntry {
+	entry := oplog.NewEntry(ids.KeyStore(), identity, "test-log", "payload", oplog.NewClock(identity.ID, 1), nil, nil)
+	return &entry
+}
+
+func TestIPFSAccessController_CanAppend(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, err := IPFSAccessController(writer.ID)(Params{Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+	assert.Equal(t, IPFSAccessControllerType, ac.Type())
+	assert.True(t, strings.HasPrefix(ac.Address(), "/ipfs/zdpu"), "Unexpected address %s", ac.Address())
+
+	allowed, err := ac.CanAppend(newTestEntry(ids, writer), ids)
+	require.NoError(t, err)
+	assert.True(t, allowed, "Expected the writer to be allowed")
+
+	allowed, err = ac.CanAppend(newTestEntry(ids, other), ids)
+	require.NoError(t, err)
+	assert.False(t, allowed, "Expected an identity outside the write list to be denied")
+}
+
+func TestIPFSAccessController_Wildcard(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, err := IPFSAccessController(Wildcard)(Params{Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+
+	for _, identity := range []*identitytypes.Identity{writer, other} {
+		allowed, err := ac.CanAppend(newTestEntry(ids, identity), ids)
+		require.NoError(t, err)
+		assert.True(t, allowed, "Expected %s to be allowed by the wildcard", identity.ID)
+	}
+}
+
+func TestIPFSAccessController_DefaultsToOpeningIdentity(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, err := IPFSAccessController()(Params{Identity: writer, Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{writer.ID}, ac.(*ipfsAccessController).Write())
+
+	allowed, err := ac.CanAppend(newTestEntry(ids, other), ids)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// The factory is reusable for other identities
+	ac2, err := IPFSAccessController()(Params{Identity: other, Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{other.ID}, ac2.(*ipfsAccessController).Write())
+}
+
+func TestIPFSAccessController_UnknownIdentity(t *testing.T) {
+	ids, writer, _ := setupIdentities(t)
+
+	// Entries signed by identities the provider doesn't know are denied
+	strangers, err := identities.NewIdentities("publickey", storage.NewMemoryStorage())
+	require.NoError(t, err)
+	stranger, err := strangers.CreateIdentity("stranger")
+	require.NoError(t, err)
+
+	ac, err := IPFSAccessController(writer.ID, stranger.ID)(Params{Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+
+	allowed, err := ac.CanAppend(newTestEntry(strangers, stranger), ids)
+	var notFound *identities.IdentityNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.False(t, allowed)
+}
+
+func TestIPFSAccessController_RequiresStorage(t *testing.T) {
+	_, err := IPFSAccessController("writer")(Params{})
+	assert.Error(t, err)
+}
+
+func TestIPFSAccessController_RequiresWriteListOrIdentity(t *testing.T) {
+	_, err := IPFSAccessController()(Params{Storage: storage.NewMemoryStorage()})
+	assert.Error(t, err)
+}
+
+func TestIPFSAccessController_LoadMissing(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ac, err := IPFSAccessController("writer")(Params{Storage: s})
+	require.NoError(t, err)
+
+	_, err = IPFSAccessController()(Params{Address: ac.Address(), Storage: storage.NewMemoryStorage()})
+	assert.Error(t, err)
+}
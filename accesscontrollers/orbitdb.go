@@ -0,0 +1,629 @@
+package accesscontrollers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"math"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// OrbitDBAccessControllerType is the type of the access controller created by OrbitDBAccessController.
+const OrbitDBAccessControllerType = "orbitdb"
+
+// Capabilities that can be granted with an OrbitDB access controller.
+const (
+	CapabilityWrite = "write" // May append entries to the database
+	CapabilityAdmin = "admin" // May write and grant or revoke capabilities
+)
+
+// Operations stored in the access controller's log.
+const (
+	opGrant  = "grant"
+	opRevoke = "revoke"
+)
+
+// capabilityOp is a grant or revoke of a capability, as stored in the access controller's log.
+type capabilityOp struct {
+	Op         string   `json:"op"`
+	Capability string   `json:"capability"`
+	ID         string   `json:"id"`
+	Time       int      `json:"time"`            // Clock time of the guarded log when the operation was issued
+	Heads      []string `json:"heads,omitempty"` // Heads of the guarded log when a revoke was issued
+
+	hash  string
+	clock oplog.Clock
+}
+
+// OrbitDBController is an access controller whose capabilities can be granted and
+// revoked after the database has been created. The grants and revokes are kept in
+// a database of their own, which is replicated like any other database.
+//
+// Entries of the guarded log are evaluated against the capabilities at their causal
+// position: once a capability is revoked, only the entries in the history of the guarded
+// log's heads when the revoke was issued stay valid on every replica, so a writer cannot
+// backdate new entries behind the revoke. Grants apply to all entries. Operations in the
+// controller's own log are only accepted from identities that were admins at the
+// operation's position, and from revoked admins only if they precede the revoke.
+type OrbitDBController struct {
+	address string
+	name    string
+	admins  []string // Admins the controller was created with, who cannot be revoked
+	params  Params
+	db      *databases.Database
+	guarded *oplog.Log
+	ops     []capabilityOp // Operations sorted by the clocks of their entries
+	applied map[string]bool
+	mu      sync.RWMutex
+}
+
+// OrbitDBAccessController returns a Factory for an access controller with mutable
+// capabilities. The given identity IDs are the controller's admins; when no IDs are
+// given, the identity opening the database is the only admin.
+func OrbitDBAccessController(admins ...string) Factory {
+	return func(params Params) (AccessController, error) {
+		if params.Storage == nil {
+			return nil, errors.New("storage is required")
+		}
+
+		if params.Address != "" {
+			return loadOrbitDBAccessController(params)
+		}
+
+		initial := append([]string{}, admins...)
+		if len(initial) == 0 {
+			if params.Identity == nil {
+				return nil, errors.New("admins or identity is required")
+			}
+			initial = []string{params.Identity.ID}
+		}
+
+		data, err := encodeOrbitDBManifest(params.Name, initial)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode access controller: %w", err)
+		}
+
+		_, hash, err := oplog.ComputeCID(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := params.Storage.Put(hash, data); err != nil {
+			return nil, fmt.Errorf("failed to store access controller: %w", err)
+		}
+
+		return newOrbitDBController("/"+OrbitDBAccessControllerType+"/"+hash, params.Name, initial, params), nil
+	}
+}
+
+// loadOrbitDBAccessController reads the manifest stored at params.Address.
+func loadOrbitDBAccessController(params Params) (AccessController, error) {
+	acType, hash, err := ParseAddress(params.Address)
+	if err != nil {
+		return nil, err
+	}
+	if acType != OrbitDBAccessControllerType {
+		return nil, fmt.Errorf("access controller %s is of type '%s', not '%s'", params.Address, acType, OrbitDBAccessControllerType)
+	}
+
+	data, err := params.Storage.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access controller %s: %w", params.Address, err)
+	}
+
+	_, computed, err := oplog.ComputeCID(data)
+	if err != nil {
+		return nil, err
+	}
+	if computed != hash {
+		return nil, fmt.Errorf("access controller hash mismatch: expected %s, got %s", hash, computed)
+	}
+
+	name, admins, err := decodeOrbitDBManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode access controller %s: %w", params.Address, err)
+	}
+
+	return newOrbitDBController(params.Address, name, admins, params), nil
+}
+
+func newOrbitDBController(address, name string, admins []string, params Params) *OrbitDBController {
+	return &OrbitDBController{
+		address: address,
+		name:    name,
+		admins:  admins,
+		params:  params,
+		applied: make(map[string]bool),
+	}
+}
+
+// Type returns "orbitdb".
+func (ac *OrbitDBController) Type() string {
+	return OrbitDBAccessControllerType
+}
+
+// Address returns the /orbitdb/<hash> address of the controller's manifest, which is
+// also the ID of the controller's own log.
+func (ac *OrbitDBController) Address() string {
+	return ac.address
+}
+
+// AttachLog opens the controller's own database once the log it guards has been
+// created, loading the capabilities stored so far. The database replicates over the
+// host and pubsub, or as set by Params.DatabaseOptions.
+func (ac *OrbitDBController) AttachLog(log *oplog.Log) error {
+	if ac.params.IdentityProvider == nil {
+		return errors.New("identity provider is required")
+	}
+
+	ac.mu.Lock()
+	if ac.guarded != nil {
+		ac.mu.Unlock()
+		return errors.New("access controller is already attached to a log")
+	}
+	ac.guarded = log
+	ac.mu.Unlock()
+
+	var entryStorage storage.Storage
+	if ac.params.Directory != "" {
+		levelStorage, err := storage.NewLevelStorage(filepath.Join(ac.params.Directory, ac.address, "log"))
+		if err != nil {
+			return fmt.Errorf("failed to open access controller storage: %w", err)
+		}
+		entryStorage = levelStorage
+	}
+
+	options := append([]databases.Option{
+		databases.WithAccessController(&adminAccess{ac: ac}),
+		databases.WithIdentityProvider(ac.params.IdentityProvider),
+	}, ac.params.DatabaseOptions...)
+	db, err := databases.NewDatabase(
+		ac.address, ac.name, ac.params.Identity, entryStorage, ac.params.KeyStore, ac.params.Host, ac.params.PubSub, options...,
+	)
+	if err != nil {
+		if entryStorage != nil {
+			entryStorage.Close()
+		}
+		return fmt.Errorf("failed to open access controller database: %w", err)
+	}
+
+	// Follow the operations written locally and replicated from other peers. The subscription
+	// is made before the stored operations are loaded, so that none is missed in between.
+	updates := ac.subscribe(db)
+	if err := ac.load(db); err != nil {
+		db.Close()
+		return err
+	}
+
+	ac.mu.Lock()
+	ac.db = db
+	ac.mu.Unlock()
+
+	go ac.follow(db, updates)
+
+	return nil
+}
+
+// subscribe subscribes to the updates of the controller's database. Updates are not dropped
+// when the buffer is full, the database waits for them to be applied instead.
+func (ac *OrbitDBController) subscribe(db *databases.Database) <-chan databases.Event {
+	return db.Subscribe(context.Background(), func(event databases.Event) bool {
+		_, ok := event.(databases.UpdateEvent)
+		return ok
+	}, databases.WithOverflowPolicy(databases.OverflowBlock))
+}
+
+// load applies the operations stored in the controller's database.
+func (ac *OrbitDBController) load(db *databases.Database) error {
+	entries, err := db.Log.Values()
+	if err != nil {
+		return fmt.Errorf("failed to load capabilities: %w", err)
+	}
+	for i := range entries {
+		ac.apply(&entries[i])
+	}
+	return nil
+}
+
+// follow applies the operations of the updates until the database is closed. If the
+// subscription is ended because an update could not be applied in time, the operations are
+// loaded again and followed with a new subscription.
+func (ac *OrbitDBController) follow(db *databases.Database, updates <-chan databases.Event) {
+	for {
+		ended := false
+		for event := range updates {
+			switch event := event.(type) {
+			case databases.UpdateEvent:
+				if event.Entries == nil {
+					ac.apply(event.Entry)
+					continue
+				}
+				for _, entry := range event.Entries {
+					ac.apply(entry)
+				}
+			case databases.ErrorEvent:
+				ended = true
+			}
+		}
+		if !ended {
+			return
+		}
+
+		updates = ac.subscribe(db)
+		if err := ac.load(db); err != nil {
+			fmt.Printf("Warning: Stopped following access controller %s: %v\n", ac.address, err)
+			return
+		}
+	}
+}
+
+// Grant gives the identity the capability. Only admins may grant capabilities.
+func (ac *OrbitDBController) Grant(capability, id string) error {
+	return ac.write(opGrant, capability, id)
+}
+
+// Revoke takes the capability away from the identity. The entries the identity wrote that
+// are in the guarded log so far remain valid.
+func (ac *OrbitDBController) Revoke(capability, id string) error {
+	return ac.write(opRevoke, capability, id)
+}
+
+func (ac *OrbitDBController) write(op, capability, id string) error {
+	if capability != CapabilityWrite && capability != CapabilityAdmin {
+		return fmt.Errorf("unknown capability '%s'", capability)
+	}
+	if id == "" {
+		return errors.New("identity ID is required")
+	}
+
+	ac.mu.RLock()
+	db, guarded := ac.db, ac.guarded
+	ac.mu.RUnlock()
+	if db == nil {
+		return errors.New("access controller is not attached to a log")
+	}
+
+	operation := capabilityOp{Op: op, Capability: capability, ID: id, Time: guarded.Clock().Time}
+	if op == opRevoke {
+		for _, head := range guarded.Heads() {
+			operation.Heads = append(operation.Heads, head.Hash)
+		}
+	}
+
+	hash, err := db.AddOperation(operation)
+	if err != nil {
+		return fmt.Errorf("failed to %s capability: %w", op, err)
+	}
+
+	entry, err := db.Log.Get(hash)
+	if err != nil {
+		return fmt.Errorf("failed to %s capability: %w", op, err)
+	}
+	ac.apply(entry)
+	return nil
+}
+
+// apply adds the operation stored in the entry, unless it has been applied already.
+func (ac *OrbitDBController) apply(entry *oplog.EncodedEntry) {
+	var op capabilityOp
+	if err := json.Unmarshal([]byte(entry.Payload), &op); err != nil {
+		fmt.Printf("Warning: Skipping invalid capability operation %s: %v\n", entry.Hash, err)
+		return
+	}
+	op.hash = entry.Hash
+	op.clock = entry.Clock
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if ac.applied[op.hash] {
+		return
+	}
+	ac.applied[op.hash] = true
+
+	i := sort.Search(len(ac.ops), func(i int) bool {
+		res := oplog.CompareClocks(ac.ops[i].clock, op.clock)
+		return res > 0 || (res == 0 && ac.ops[i].hash > op.hash)
+	})
+	ac.ops = append(ac.ops, capabilityOp{})
+	copy(ac.ops[i+1:], ac.ops[i:])
+	ac.ops[i] = op
+}
+
+// Capabilities returns the identity IDs that currently hold each capability.
+func (ac *OrbitDBController) Capabilities() map[string][]string {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+
+	capabilities := map[string][]string{
+		CapabilityAdmin: append([]string{}, ac.admins...),
+		CapabilityWrite: {},
+	}
+
+	seen := make(map[string]bool)
+	for _, op := range ac.ops {
+		key := op.Capability + "/" + op.ID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if ac.hasCapability(op.Capability, op.ID, math.MaxInt, nil) && !contains(capabilities[op.Capability], op.ID) {
+			capabilities[op.Capability] = append(capabilities[op.Capability], op.ID)
+		}
+	}
+
+	return capabilities
+}
+
+// CanAppend allows an entry of the guarded log if the identity that signed it held the
+// write or admin capability at the entry's clock time, and the identity is valid. Entries
+// of revoked identities are also checked against their history by CanAppendInHistory.
+func (ac *OrbitDBController) CanAppend(entry *oplog.EncodedEntry, identityProvider oplog.IdentityProvider) (bool, error) {
+	if identityProvider == nil {
+		return false, errors.New("identity provider is required")
+	}
+
+	identity, err := identityProvider.GetIdentity(entry.Identity)
+	if err != nil {
+		return false, err
+	}
+	if identity == nil {
+		return false, nil
+	}
+
+	ac.mu.RLock()
+	allowed := ac.hasCapability(CapabilityWrite, identity.ID, entry.Clock.Time, nil) ||
+		ac.hasCapability(CapabilityWrite, Wildcard, entry.Clock.Time, nil) ||
+		ac.hasCapability(CapabilityAdmin, identity.ID, entry.Clock.Time, nil)
+	ac.mu.RUnlock()
+
+	return allowed && identityProvider.VerifyIdentity(identity), nil
+}
+
+// CanAppendInHistory allows an entry of the guarded log if the identity that signed it holds
+// the write or admin capability, or if the entry is in the history of the guarded log's heads
+// when the capability was revoked. A revoke without heads was issued on an empty log, so no
+// entry is in its history.
+func (ac *OrbitDBController) CanAppendInHistory(entry *oplog.EncodedEntry, identityProvider oplog.IdentityProvider, load func(hash string) (*oplog.EncodedEntry, error)) (bool, error) {
+	if identityProvider == nil {
+		return false, errors.New("identity provider is required")
+	}
+
+	identity, err := identityProvider.GetIdentity(entry.Identity)
+	if err != nil {
+		return false, err
+	}
+	if identity == nil {
+		return false, nil
+	}
+
+	checks := []struct{ capability, id string }{
+		{CapabilityWrite, identity.ID},
+		{CapabilityWrite, Wildcard},
+		{CapabilityAdmin, identity.ID},
+	}
+
+	ac.mu.RLock()
+	granted := false
+	var revokes []*capabilityOp
+	for _, check := range checks {
+		held, revoke := ac.capabilityState(check.capability, check.id, nil)
+		if held {
+			granted = true
+			break
+		}
+		if revoke != nil && entry.Clock.Time <= revoke.Time {
+			revokes = append(revokes, revoke)
+		}
+	}
+	ac.mu.RUnlock()
+
+	// The history is read without the lock, as loading entries may take a while
+	allowed := granted
+	for _, revoke := range revokes {
+		if allowed {
+			break
+		}
+		allowed = inHistory(entry, revoke.Heads, load)
+	}
+
+	return allowed && identityProvider.VerifyIdentity(identity), nil
+}
+
+// hasCapability folds the operations for the identity into whether it holds the capability
+// for an entry with the given clock time. When before is set, only the operations ordered
+// before that clock are taken into account. The caller must hold ac.mu.
+func (ac *OrbitDBController) hasCapability(capability, id string, time int, before *oplog.Clock) bool {
+	granted, revoke := ac.capabilityState(capability, id, before)
+	return granted || (revoke != nil && time <= revoke.Time)
+}
+
+// capabilityState folds the operations for the identity into whether it holds the capability.
+// If it doesn't, the revoke that took the capability away is returned, if any. When before is
+// set, only the operations ordered before that clock are taken into account. The caller must
+// hold ac.mu.
+func (ac *OrbitDBController) capabilityState(capability, id string, before *oplog.Clock) (bool, *capabilityOp) {
+	if capability == CapabilityAdmin && contains(ac.admins, id) {
+		return true, nil
+	}
+
+	granted := false
+	var revoke *capabilityOp
+	for _, op := range ac.ops {
+		if before != nil && oplog.CompareClocks(op.clock, *before) >= 0 {
+			break
+		}
+		if op.Capability != capability || op.ID != id {
+			continue
+		}
+
+		switch op.Op {
+		case opGrant:
+			granted = true
+		case opRevoke:
+			if granted {
+				granted = false
+				revoked := op
+				revoke = &revoked
+			}
+		}
+	}
+
+	if granted {
+		return true, nil
+	}
+	return false, revoke
+}
+
+// inHistory reports whether the entry is one of the heads or one of their ancestors. An entry
+// is always ahead of the entries it points to, so the history is not followed past the
+// entry's clock time. Entries that cannot be loaded are not followed either.
+func inHistory(entry *oplog.EncodedEntry, heads []string, load func(hash string) (*oplog.EncodedEntry, error)) bool {
+	visited := make(map[string]bool)
+	stack := append([]string{}, heads...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if hash == entry.Hash {
+			return true
+		}
+		if visited[hash] {
+			continue
+		}
+		visited[hash] = true
+
+		current, err := load(hash)
+		if err != nil || current.Clock.Time <= entry.Clock.Time {
+			continue
+		}
+		stack = append(stack, current.Next...)
+	}
+	return false
+}
+
+// Close closes the controller's own database.
+func (ac *OrbitDBController) Close() error {
+	ac.mu.Lock()
+	db := ac.db
+	ac.db = nil
+	ac.mu.Unlock()
+
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+// adminAccess guards the controller's own log, which only admins may write to.
+type adminAccess struct {
+	ac *OrbitDBController
+}
+
+// CanAppend allows the operation if the identity that signed it was an admin at the
+// operation's position in the controller's log.
+func (a *adminAccess) CanAppend(entry *oplog.EncodedEntry, identityProvider oplog.IdentityProvider) (bool, error) {
+	identity, err := identityProvider.GetIdentity(entry.Identity)
+	if err != nil {
+		return false, err
+	}
+	if identity == nil {
+		return false, nil
+	}
+
+	a.ac.mu.RLock()
+	allowed := a.ac.hasCapability(CapabilityAdmin, identity.ID, math.MaxInt, &entry.Clock)
+	a.ac.mu.RUnlock()
+
+	return allowed && identityProvider.VerifyIdentity(identity), nil
+}
+
+// CanAppendInHistory allows an operation of an admin whose capability has been revoked only
+// if the operation is in the history of the revoke, so that it cannot be backdated behind it.
+func (a *adminAccess) CanAppendInHistory(entry *oplog.EncodedEntry, identityProvider oplog.IdentityProvider, load func(hash string) (*oplog.EncodedEntry, error)) (bool, error) {
+	identity, err := identityProvider.GetIdentity(entry.Identity)
+	if err != nil {
+		return false, err
+	}
+	if identity == nil {
+		return false, nil
+	}
+
+	a.ac.mu.RLock()
+	granted, revoke := a.ac.capabilityState(CapabilityAdmin, identity.ID, nil)
+	a.ac.mu.RUnlock()
+
+	if granted || revoke == nil {
+		return true, nil
+	}
+	return inHistory(entry, []string{revoke.hash}, load), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeOrbitDBManifest encodes the controller's manifest as a dag-cbor map {name, admin: [...]}.
+func encodeOrbitDBManifest(name string, admins []string) ([]byte, error) {
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(2)
+	if err != nil {
+		return nil, err
+	}
+	if err := ma.AssembleKey().AssignString("name"); err != nil {
+		return nil, err
+	}
+	if err := ma.AssembleValue().AssignString(name); err != nil {
+		return nil, err
+	}
+	if err := assembleStringList(ma, "admin", admins); err != nil {
+		return nil, err
+	}
+	if err := ma.Finish(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(nb.Build(), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeOrbitDBManifest decodes a dag-cbor encoded controller manifest.
+func decodeOrbitDBManifest(data []byte) (string, []string, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+		return "", nil, err
+	}
+	node := nb.Build()
+
+	nameNode, err := node.LookupByString("name")
+	if err != nil {
+		return "", nil, errors.New("invalid or missing 'name' field")
+	}
+	name, err := nameNode.AsString()
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid 'name' field: %w", err)
+	}
+
+	admins, err := getStringList(node, "admin")
+	if err != nil {
+		return "", nil, err
+	}
+	return name, admins, nil
+}
@@ -0,0 +1,246 @@
+package accesscontrollers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/identities"
+	"orbitdb/go-orbitdb/identities/identitytypes"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+	orbitsync "orbitdb/go-orbitdb/syncutils"
+)
+
+// openGuardedDatabase opens a database guarded by the access controller, replicating both
+// the database and the controller's own database with the peers on the in-memory network.
+func openGuardedDatabase(t *testing.T, network *orbitsync.MemoryNetwork, ids *identities.Identities, identity *identitytypes.Identity, factory Factory, params Params) (*OrbitDBController, *databases.Database) {
+	transport, err := network.NewTransport(identity.ID)
+	require.NoError(t, err)
+	t.Cleanup(func() { transport.Close() })
+
+	params.Identity = identity
+	params.IdentityProvider = ids
+	params.KeyStore = ids.KeyStore()
+	params.DatabaseOptions = []databases.Option{databases.WithTransport(transport)}
+
+	access, err := factory(params)
+	require.NoError(t, err)
+	ac := access.(*OrbitDBController)
+
+	db, err := databases.NewDatabase("test-log", "test-db", identity, storage.NewMemoryStorage(), ids.KeyStore(), nil, nil,
+		databases.WithAccessController(ac),
+		databases.WithIdentityProvider(ids),
+		databases.WithTransport(transport))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		ac.Close()
+	})
+
+	return ac, db
+}
+
+func newTestEntryAt(ids *identities.Identities, identity *identitytypes.Identity, time int) *oplog.EncodedEntry {
+	entry := oplog.NewEntry(ids.KeyStore(), identity, "test-log", "payload", oplog.NewClock(identity.ID, time), nil, nil)
+	return &entry
+}
+
+func TestOrbitDBAccessController_GrantAndRevoke(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+	manifests := storage.NewMemoryStorage()
+
+	ac, db := openGuardedDatabase(t, orbitsync.NewMemoryNetwork(), ids, writer, OrbitDBAccessController(), Params{Name: "test-db", Storage: manifests})
+	assert.Equal(t, OrbitDBAccessControllerType, ac.Type())
+	assert.True(t, strings.HasPrefix(ac.Address(), "/orbitdb/zdpu"), "Unexpected address %s", ac.Address())
+
+	allowed, err := ac.CanAppend(newTestEntryAt(ids, other, 1), ids)
+	require.NoError(t, err)
+	assert.False(t, allowed, "Expected an identity without capabilities to be denied")
+
+	require.NoError(t, ac.Grant(CapabilityWrite, other.ID))
+	assert.Equal(t, []string{other.ID}, ac.Capabilities()[CapabilityWrite])
+
+	// The grant applies to entries written before it
+	allowed, err = ac.CanAppend(newTestEntryAt(ids, other, 1), ids)
+	require.NoError(t, err)
+	assert.True(t, allowed, "Expected the granted identity to be allowed")
+
+	// Advance the guarded log to time 3 and revoke
+	for i := 0; i < 3; i++ {
+		_, err := db.AddOperation(map[string]int{"i": i})
+		require.NoError(t, err)
+	}
+	require.NoError(t, ac.Revoke(CapabilityWrite, other.ID))
+	assert.Empty(t, ac.Capabilities()[CapabilityWrite])
+
+	// Entries at or before the revoke's position remain valid, later ones are denied
+	allowed, err = ac.CanAppend(newTestEntryAt(ids, other, 3), ids)
+	require.NoError(t, err)
+	assert.True(t, allowed, "Expected an entry written before the revoke to stay valid")
+
+	allowed, err = ac.CanAppend(newTestEntryAt(ids, other, 4), ids)
+	require.NoError(t, err)
+	assert.False(t, allowed, "Expected an entry written after the revoke to be denied")
+
+	// Admins keep writing
+	_, err = db.AddOperation(map[string]int{"i": 3})
+	require.NoError(t, err)
+}
+
+func TestOrbitDBAccessController_UnknownCapability(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, _ := openGuardedDatabase(t, orbitsync.NewMemoryNetwork(), ids, writer, OrbitDBAccessController(), Params{Name: "test-db", Storage: storage.NewMemoryStorage()})
+	assert.Error(t, ac.Grant("read", other.ID))
+}
+
+func TestOrbitDBAccessController_RequiresAttachedLog(t *testing.T) {
+	_, writer, other := setupIdentities(t)
+
+	access, err := OrbitDBAccessController()(Params{Identity: writer, Name: "test-db", Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+	assert.Error(t, access.(*OrbitDBController).Grant(CapabilityWrite, other.ID))
+}
+
+func TestOrbitDBAccessController_ReplicatedAdmin(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+	manifests := storage.NewMemoryStorage()
+
+	network := orbitsync.NewMemoryNetwork()
+	ac1, _ := openGuardedDatabase(t, network, ids, writer, OrbitDBAccessController(), Params{Name: "test-db", Storage: manifests})
+
+	// A second replica loads the same controller by address
+	ac2, _ := openGuardedDatabase(t, network, ids, other, OrbitDBAccessController(), Params{Address: ac1.Address(), Storage: manifests})
+	assert.Equal(t, ac1.Address(), ac2.Address())
+	assert.Equal(t, []string{writer.ID}, ac2.Capabilities()[CapabilityAdmin])
+
+	// Only admins may change capabilities
+	assert.Error(t, ac2.Grant(CapabilityWrite, other.ID))
+
+	// The grant is replicated to the second replica by the controllers' own databases
+	require.NoError(t, ac1.Grant(CapabilityAdmin, other.ID))
+	require.Eventually(t, func() bool {
+		return contains(ac2.Capabilities()[CapabilityAdmin], other.ID)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, ac2.Grant(CapabilityWrite, writer.ID))
+	allowed, err := ac2.CanAppend(newTestEntryAt(ids, other, 1), ids)
+	require.NoError(t, err)
+	assert.True(t, allowed, "Expected the new admin to be allowed to write")
+}
+
+func TestOrbitDBAccessController_AdminCheckedAtPosition(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, _ := openGuardedDatabase(t, orbitsync.NewMemoryNetwork(), ids, writer, OrbitDBAccessController(), Params{Name: "test-db", Storage: storage.NewMemoryStorage()})
+	require.NoError(t, ac.Grant(CapabilityAdmin, other.ID))
+
+	// An operation by the new admin ordered before the grant is not accepted
+	early := oplog.NewEntry(ids.KeyStore(), other, ac.Address(), `{"op":"grant","capability":"write","id":"x"}`, oplog.NewClock(other.ID, 0), nil, nil)
+	allowed, err := (&adminAccess{ac: ac}).CanAppend(&early, ids)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	late := oplog.NewEntry(ids.KeyStore(), other, ac.Address(), `{"op":"grant","capability":"write","id":"x"}`, oplog.NewClock(other.ID, 2), nil, nil)
+	allowed, err = (&adminAccess{ac: ac}).CanAppend(&late, ids)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestOrbitDBAccessController_RevokeRejectsBackdatedEntries(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, db := openGuardedDatabase(t, orbitsync.NewMemoryNetwork(), ids, writer, OrbitDBAccessController(), Params{Name: "test-db", Storage: storage.NewMemoryStorage()})
+	require.NoError(t, ac.Grant(CapabilityWrite, other.ID))
+
+	// The writer's own replica of the guarded log
+	otherLog, err := oplog.NewLog("test-log", other, storage.NewMemoryStorage(), ids.KeyStore())
+	require.NoError(t, err)
+	written, err := otherLog.Append("written before the revoke")
+	require.NoError(t, err)
+	require.NoError(t, db.Log.JoinEntry(written, make(map[string]bool)))
+
+	// Advance the guarded log to time 4 and revoke
+	for i := 0; i < 3; i++ {
+		_, err := db.AddOperation(map[string]int{"i": i})
+		require.NoError(t, err)
+	}
+	require.NoError(t, ac.Revoke(CapabilityWrite, other.ID))
+
+	// The writer keeps writing on top of what it had, with a clock behind the revoke
+	backdated, err := otherLog.Append("written after the revoke")
+	require.NoError(t, err)
+	allowed, err := ac.CanAppend(backdated, ids)
+	require.NoError(t, err)
+	assert.True(t, allowed, "Expected the backdated entry to pass the check of its clock time")
+
+	assert.Error(t, db.Log.JoinEntry(backdated, make(map[string]bool)), "Expected the backdated entry to be rejected")
+	assert.False(t, db.Log.Has(backdated.Hash))
+
+	// A new replica guarded by the controller still accepts the entries written before the revoke
+	fetcher, err := oplog.NewStorageFetcher(db.Log.Entries)
+	require.NoError(t, err)
+	replica, err := oplog.NewLog("test-log", writer, storage.NewMemoryStorage(), ids.KeyStore(),
+		oplog.WithAccessController(ac), oplog.WithIdentityProvider(ids), oplog.WithFetcher(fetcher))
+	require.NoError(t, err)
+	require.NoError(t, replica.JoinEntry(db.Log.Heads()[0], make(map[string]bool)))
+	assert.True(t, replica.Has(written.Hash))
+}
+
+func TestOrbitDBAccessController_RevokeOnEmptyLog(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, db := openGuardedDatabase(t, orbitsync.NewMemoryNetwork(), ids, writer, OrbitDBAccessController(), Params{Name: "test-db", Storage: storage.NewMemoryStorage()})
+	require.NoError(t, ac.Grant(CapabilityWrite, other.ID))
+	require.NoError(t, ac.Revoke(CapabilityWrite, other.ID))
+
+	// The revoke has no heads, so nothing the writer wrote can be in the log before it
+	backdated := newTestEntryAt(ids, other, 0)
+	allowed, err := ac.CanAppend(backdated, ids)
+	require.NoError(t, err)
+	assert.True(t, allowed, "Expected the backdated entry to pass the check of its clock time")
+
+	assert.Error(t, db.Log.JoinEntry(backdated, nil), "Expected the backdated entry to be rejected")
+	assert.False(t, db.Log.Has(backdated.Hash))
+}
+
+func TestOrbitDBAccessController_RevokedAdminCannotBackdate(t *testing.T) {
+	ids, writer, other := setupIdentities(t)
+
+	ac, _ := openGuardedDatabase(t, orbitsync.NewMemoryNetwork(), ids, writer, OrbitDBAccessController(), Params{Name: "test-db", Storage: storage.NewMemoryStorage()})
+	require.NoError(t, ac.Grant(CapabilityAdmin, other.ID))
+	grant := ac.db.Log.Heads()[0]
+	require.NoError(t, ac.Grant(CapabilityWrite, writer.ID))
+	require.NoError(t, ac.Revoke(CapabilityAdmin, other.ID))
+
+	// An operation of the revoked admin that builds on the grant and is ordered before the revoke
+	backdated := oplog.NewEntry(ids.KeyStore(), other, ac.Address(), `{"op":"grant","capability":"write","id":"x"}`,
+		oplog.NewClock(other.ID, 2), []string{grant.Hash}, nil)
+	allowed, err := (&adminAccess{ac: ac}).CanAppend(&backdated, ids)
+	require.NoError(t, err)
+	assert.True(t, allowed, "Expected the backdated operation to pass the check of its position")
+
+	assert.Error(t, ac.db.Log.JoinEntry(&backdated, make(map[string]bool)), "Expected the backdated operation to be rejected")
+	assert.NotContains(t, ac.Capabilities()[CapabilityWrite], "x")
+}
+
+func TestOrbitDBAccessController_LoadWrongType(t *testing.T) {
+	_, writer, _ := setupIdentities(t)
+	manifests := storage.NewMemoryStorage()
+
+	ipfs, err := IPFSAccessController()(Params{Identity: writer, Storage: manifests})
+	require.NoError(t, err)
+
+	_, err = OrbitDBAccessController()(Params{Address: ipfs.Address(), Storage: manifests})
+	assert.Error(t, err)
+}
+
+func TestOrbitDBAccessController_Registered(t *testing.T) {
+	factory, err := GetAccessController(OrbitDBAccessControllerType)
+	require.NoError(t, err)
+	assert.NotNil(t, factory)
+}
@@ -0,0 +1,48 @@
+package orbitdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	"strings"
+)
+
+// AddressProtocol is the protocol prefix of OrbitDB database addresses.
+const AddressProtocol = "orbitdb"
+
+// Address identifies a database by the hash of its manifest, e.g. /orbitdb/zdpu...
+type Address struct {
+	Protocol string
+	Hash     string
+}
+
+// String returns the address in its /orbitdb/<hash> form.
+func (a Address) String() string {
+	return "/" + a.Protocol + "/" + a.Hash
+}
+
+// IsValidAddress checks whether the given string is a valid OrbitDB address.
+func IsValidAddress(address string) bool {
+	_, err := ParseAddress(address)
+	return err == nil
+}
+
+// ParseAddress parses an /orbitdb/<hash> string into an Address.
+func ParseAddress(address string) (Address, error) {
+	if address == "" {
+		return Address{}, errors.New("address is required")
+	}
+
+	// Accept Windows style separators and a missing leading slash
+	normalized := strings.Trim(strings.ReplaceAll(address, "\\", "/"), "/")
+	parts := strings.Split(normalized, "/")
+	if len(parts) != 2 || parts[0] != AddressProtocol {
+		return Address{}, fmt.Errorf("not a valid OrbitDB address: %s", address)
+	}
+
+	if _, err := cid.Decode(parts[1]); err != nil {
+		return Address{}, fmt.Errorf("not a valid OrbitDB address: %s: %w", address, err)
+	}
+
+	return Address{Protocol: AddressProtocol, Hash: parts[1]}, nil
+}
@@ -0,0 +1,47 @@
+package orbitdb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	orbitdb "orbitdb/go-orbitdb"
+)
+
+const testManifestHash = "zdpuAuK3BHpS7NvMBivynypqciYCuy2UW77XYBPUYRnLjnw13"
+
+func TestParseAddress(t *testing.T) {
+	address, err := orbitdb.ParseAddress("/orbitdb/" + testManifestHash)
+	require.NoError(t, err)
+	assert.Equal(t, "orbitdb", address.Protocol)
+	assert.Equal(t, testManifestHash, address.Hash)
+	assert.Equal(t, "/orbitdb/"+testManifestHash, address.String())
+}
+
+func TestParseAddressWithoutLeadingSlash(t *testing.T) {
+	address, err := orbitdb.ParseAddress("orbitdb/" + testManifestHash)
+	require.NoError(t, err)
+	assert.Equal(t, "/orbitdb/"+testManifestHash, address.String())
+}
+
+func TestParseAddressWindowsSeparators(t *testing.T) {
+	address, err := orbitdb.ParseAddress("\\orbitdb\\" + testManifestHash)
+	require.NoError(t, err)
+	assert.Equal(t, testManifestHash, address.Hash)
+}
+
+func TestIsValidAddress(t *testing.T) {
+	assert.True(t, orbitdb.IsValidAddress("/orbitdb/"+testManifestHash))
+
+	invalid := []string{
+		"",
+		"my-database",
+		"/orbitdb",
+		"/orbitdb/not-a-cid",
+		"/ipfs/" + testManifestHash,
+		"/orbitdb/" + testManifestHash + "/extra",
+	}
+	for _, address := range invalid {
+		assert.False(t, orbitdb.IsValidAddress(address), "Expected %q to be invalid", address)
+	}
+}
@@ -0,0 +1,59 @@
+package databases
+
+import "fmt"
+
+// Tx collects the operations of a batch, see Database.Batch.
+type Tx struct {
+	payloads []string
+}
+
+// AddOperation adds an operation to the batch.
+func (tx *Tx) AddOperation(op interface{}) error {
+	payload, err := serializeOperation(op)
+	if err != nil {
+		return fmt.Errorf("failed to serialize operation: %w", err)
+	}
+
+	tx.payloads = append(tx.payloads, payload)
+	return nil
+}
+
+// Batch appends the operations added by fn to the log as a group. Nothing is appended if fn
+// returns an error, which Batch returns. Otherwise the entries are stored all at once, or
+// none of them if storing fails. Only the last entry, which links to all others, is
+// announced to peers, and subscribers receive a single UpdateEvent for the batch.
+func (db *Database) Batch(fn func(tx *Tx) error) error {
+	tx := &Tx{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.payloads) == 0 {
+		return nil
+	}
+
+	resultChan := make(chan error, 1)
+
+	// Append the whole batch in a single task
+	task := func() {
+		entries, err := db.Log.AppendBatch(tx.payloads)
+		if err != nil {
+			resultChan <- fmt.Errorf("failed to append batch to log: %w", err)
+			return
+		}
+		last := entries[len(entries)-1]
+
+		// Announce the new head to peers
+		if db.Sync != nil {
+			if err := db.Sync.Add(last); err != nil {
+				resultChan <- fmt.Errorf("failed to sync entry: %w", err)
+				return
+			}
+		}
+
+		db.emit(UpdateEvent{Entry: last, Entries: entries})
+		resultChan <- nil
+	}
+
+	db.taskQueue <- task
+	return <-resultChan
+}
@@ -0,0 +1,106 @@
+package databases_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/storage"
+	orbitsync "orbitdb/go-orbitdb/syncutils"
+)
+
+// setupBatchKeyValue creates a key-value database storing its entries in LevelDB, without sync.
+func setupBatchKeyValue(t *testing.T) *databases.KeyValue {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	entryStorage, err := storage.NewLevelStorage(t.TempDir())
+	require.NoError(t, err)
+
+	kv, err := databases.NewKeyValue("test-address", "test-db", identity, entryStorage, ks, nil, nil,
+		databases.WithSyncMode(databases.SyncDisabled))
+	require.NoError(t, err)
+	t.Cleanup(func() { kv.Close() })
+	return kv
+}
+
+func TestBatch(t *testing.T) {
+	kv := setupBatchKeyValue(t)
+	events := kv.Subscribe(context.Background(), onlyUpdates)
+
+	require.NoError(t, kv.Batch(func(tx *databases.KeyValueTx) error {
+		for i := 0; i < 100; i++ {
+			if err := tx.Put(fmt.Sprintf("key%d", i), i); err != nil {
+				return err
+			}
+		}
+		return tx.Del("key0")
+	}))
+
+	// A single update is emitted for the whole batch
+	update := (<-events).(databases.UpdateEvent)
+	require.Len(t, update.Entries, 101)
+	assert.Equal(t, update.Entries[100], update.Entry)
+	assert.Empty(t, receiveUpdates(events))
+
+	heads := kv.Log.Heads()
+	require.Len(t, heads, 1)
+	assert.Equal(t, update.Entry.Hash, heads[0].Hash)
+
+	all, err := kv.All()
+	require.NoError(t, err)
+	assert.Len(t, all, 99)
+	assert.Equal(t, float64(42), all["key42"])
+	assert.NotContains(t, all, "key0")
+}
+
+func TestBatchError(t *testing.T) {
+	kv := setupBatchKeyValue(t)
+
+	// Nothing is appended when the batch fails
+	errAbort := errors.New("abort")
+	err := kv.Batch(func(tx *databases.KeyValueTx) error {
+		require.NoError(t, tx.Put("key1", "value1"))
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+
+	err = kv.Batch(func(tx *databases.KeyValueTx) error {
+		require.NoError(t, tx.Put("key1", "value1"))
+		return tx.Put("", "value2")
+	})
+	assert.Error(t, err, "Expected an empty key to fail the batch")
+
+	values, err := kv.Log.Values()
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	// An empty batch does nothing
+	require.NoError(t, kv.Batch(func(tx *databases.KeyValueTx) error { return nil }))
+	assert.Empty(t, kv.Log.Heads())
+}
+
+func TestBatchReplicates(t *testing.T) {
+	network := orbitsync.NewMemoryNetwork()
+	blocks := &blockExchange{}
+	writer := setupMemoryReplica(t, network, "writer", blocks)
+	reader := setupMemoryReplica(t, network, "reader", blocks)
+
+	require.NoError(t, writer.Batch(func(tx *databases.KeyValueTx) error {
+		for i := 0; i < 10; i++ {
+			if err := tx.Put(fmt.Sprintf("key%d", i), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// The reader fetches the whole batch from its last entry
+	require.Eventually(t, func() bool {
+		all, err := reader.All()
+		return err == nil && len(all) == 10
+	}, 5*time.Second, 10*time.Millisecond, "Expected the batch to be replicated")
+}
@@ -1,6 +1,7 @@
 package databases
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,19 +17,112 @@ import (
 
 // Database represents the base class for all database types.
 type Database struct {
-	Address     string
-	Name        string
-	Identity    *identitytypes.Identity
-	Meta        map[string]interface{}
-	Log         *oplog.Log
-	Sync        *orbitsync.Sync
-	Events      chan interface{}
-	taskQueue   chan func()
-	stopChannel chan struct{}
-	mu          sync.Mutex
-}
-
-// NewDatabase creates a new Database instance.
+	Address          string
+	Name             string
+	Identity         *identitytypes.Identity
+	Meta             map[string]interface{}
+	AccessController oplog.AccessController // Decides who may write, nil if everyone may
+	Log              *oplog.Log
+	Sync             *orbitsync.Sync
+	syncEvents       chan orbitsync.Event
+	subscribers      map[*subscriber]struct{}
+	subscribersMu    sync.RWMutex
+	closed           bool          // Set once the subscriptions have been closed
+	joined           chan struct{} // Closed when entries have been joined into the log
+	joinedMu         sync.Mutex
+	taskQueue        chan func()
+	stopChannel      chan struct{}
+	ctx              context.Context // Canceled on Close to abandon the fetches of joined entries
+	cancel           context.CancelFunc
+	closeHooks       []func()
+	mu               sync.Mutex
+}
+
+// Option configures optional settings of a Database.
+type Option func(*databaseOptions)
+
+// databaseOptions holds the optional settings of a Database.
+type databaseOptions struct {
+	accessController oplog.AccessController
+	identityProvider oplog.IdentityProvider
+	logOptions       []oplog.Option
+	syncOptions      []orbitsync.Option
+	transport        orbitsync.Transport
+	syncMode         SyncMode
+}
+
+// SyncMode decides whether and when a database replicates with its peers.
+type SyncMode int
+
+const (
+	// SyncAutomatic starts replicating as soon as the database is opened.
+	SyncAutomatic SyncMode = iota
+
+	// SyncManual sets up replication, but only starts it when StartSync is called.
+	SyncManual
+
+	// SyncDisabled never replicates, so no host, pubsub or transport is required.
+	SyncDisabled
+)
+
+// ErrSyncDisabled is returned when replication is requested from a database opened with SyncDisabled.
+var ErrSyncDisabled = errors.New("sync is disabled")
+
+// LogAttacher is implemented by access controllers that need to follow the log they guard,
+// for example to read its clock. AttachLog is called once the database's log has been created.
+type LogAttacher interface {
+	AttachLog(log *oplog.Log) error
+}
+
+// WithAccessController sets the access controller local and replicated entries are checked against.
+func WithAccessController(accessController oplog.AccessController) Option {
+	return func(o *databaseOptions) {
+		o.accessController = accessController
+	}
+}
+
+// WithIdentityProvider sets the provider writer identities are resolved and verified with.
+func WithIdentityProvider(identityProvider oplog.IdentityProvider) Option {
+	return func(o *databaseOptions) {
+		o.identityProvider = identityProvider
+	}
+}
+
+// WithLogOptions passes additional options to the database's oplog.
+func WithLogOptions(logOptions ...oplog.Option) Option {
+	return func(o *databaseOptions) {
+		o.logOptions = append(o.logOptions, logOptions...)
+	}
+}
+
+// WithSyncOptions passes additional options to the database's Sync, e.g. a handler for
+// rejected heads.
+func WithSyncOptions(syncOptions ...orbitsync.Option) Option {
+	return func(o *databaseOptions) {
+		o.syncOptions = append(o.syncOptions, syncOptions...)
+	}
+}
+
+// WithTransport sets the transport heads are exchanged with peers over, instead of the libp2p
+// host and pubsub, e.g. a MemoryTransport to replicate databases within a single process.
+func WithTransport(transport orbitsync.Transport) Option {
+	return func(o *databaseOptions) {
+		o.transport = transport
+	}
+}
+
+// WithSyncMode sets whether and when the database replicates with its peers. The default
+// is SyncAutomatic.
+func WithSyncMode(mode SyncMode) Option {
+	return func(o *databaseOptions) {
+		o.syncMode = mode
+	}
+}
+
+// NewDatabase creates a new Database instance. The host and pubsub are only required when
+// no transport is set with WithTransport and sync is not disabled with WithSyncMode. The
+// database takes over the entry storage: it is closed when the database is closed, or when
+// the database cannot be created.
 func NewDatabase(
 	address, name string,
 	identity *identitytypes.Identity,
@@ -36,15 +130,24 @@ func NewDatabase(
 	keyStore *keystore.KeyStore,
 	host host.Host,
 	pubsub *pubsub.PubSub,
+	options ...Option,
 ) (*Database, error) {
+	// Release the entry storage when failing before the log takes it over
+	fail := func(err error) (*Database, error) {
+		if entryStorage != nil {
+			entryStorage.Close()
+		}
+		return nil, err
+	}
+
 	// Validate inputs
 	if address == "" {
-		return nil, fmt.Errorf("address is required")
+		return fail(fmt.Errorf("address is required"))
 	}
 
 	// Validate identity
 	if identity == nil || !identitytypes.IsIdentity(identity) {
-		return nil, fmt.Errorf("valid identity is required")
+		return fail(fmt.Errorf("valid identity is required"))
 	}
 
 	// Use default in-memory storage if no entryStorage is provided
@@ -57,36 +160,85 @@ func NewDatabase(
 		keyStore = keystore.NewKeyStore(storage.NewMemoryStorage())
 	}
 
-	// Initialize the log
-	log, err := oplog.NewLog(address, identity, entryStorage, keyStore)
+	opts := &databaseOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
+	// Exchange heads over libp2p unless another transport is provided
+	transport := opts.transport
+	if transport == nil && opts.syncMode != SyncDisabled {
+		if host == nil || pubsub == nil {
+			return fail(errors.New("host and pubsub instances are required unless sync is disabled"))
+		}
+		transport = orbitsync.NewLibp2pTransport(host, pubsub)
+	}
+
+	// Initialize the log, enforcing the access controller on every entry
+	logOptions := opts.logOptions
+	if opts.accessController != nil {
+		logOptions = append(logOptions, oplog.WithAccessController(opts.accessController))
+	}
+	if opts.identityProvider != nil {
+		logOptions = append(logOptions, oplog.WithIdentityProvider(opts.identityProvider))
+	}
+
+	log, err := oplog.NewLog(address, identity, entryStorage, keyStore, logOptions...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize oplog: %w", err)
+		return fail(fmt.Errorf("failed to initialize oplog: %w", err))
+	}
+
+	if attacher, ok := opts.accessController.(LogAttacher); ok {
+		if err := attacher.AttachLog(log); err != nil {
+			log.Close()
+			return nil, fmt.Errorf("failed to attach access controller: %w", err)
+		}
 	}
 
 	// Initialize the database instance
+	ctx, cancel := context.WithCancel(context.Background())
 	db := &Database{
-		Address:     address,
-		Name:        name,
-		Identity:    identity,
-		Meta:        make(map[string]interface{}),
-		Log:         log,
-		Events:      make(chan interface{}, 100),
-		taskQueue:   make(chan func(), 100),
-		stopChannel: make(chan struct{}),
+		Address:          address,
+		Name:             name,
+		Identity:         identity,
+		Meta:             make(map[string]interface{}),
+		AccessController: opts.accessController,
+		Log:              log,
+		syncEvents:       make(chan orbitsync.Event, 100),
+		subscribers:      make(map[*subscriber]struct{}),
+		joined:           make(chan struct{}),
+		taskQueue:        make(chan func(), 100),
+		stopChannel:      make(chan struct{}),
+		ctx:              ctx,
+		cancel:           cancel,
 	}
 
 	// Start processing the task queue
 	go db.processTaskQueue()
 
-	// Initialize Sync with the provided host and pubsub
-	db.Sync = orbitsync.NewSync(host, pubsub, log)
-	err = db.Sync.Start()
-	if err != nil {
-		return nil, fmt.Errorf("failed to start sync: %w", err)
+	if opts.syncMode == SyncDisabled {
+		return db, nil
+	}
+
+	// Initialize Sync with the transport
+	// Send the identities that signed our heads along with them, and store the ones peers send
+	syncOptions := opts.syncOptions
+	if opts.identityProvider != nil {
+		syncOptions = append([]orbitsync.Option{orbitsync.WithIdentityProvider(opts.identityProvider)}, syncOptions...)
 	}
+	db.Sync = orbitsync.NewSync(transport, log, syncOptions...)
 
-	// Listen for synchronized entries
+	// Listen for synchronized entries and changes in the replication state
 	go db.listenForSyncUpdates()
+	go db.listenForSyncEvents()
+
+	if opts.syncMode == SyncAutomatic {
+		if err := db.StartSync(); err != nil {
+			// Stop the goroutines started above and release the log
+			db.Close()
+			return nil, err
+		}
+	}
 
 	return db, nil
 }
@@ -105,9 +257,75 @@ func (db *Database) processTaskQueue() {
 
 // listenForSyncUpdates listens to updates from the Sync component.
 func (db *Database) listenForSyncUpdates() {
-	for synced := range db.Sync.SyncedCh {
-		db.ApplyOperation(synced.Entry.Bytes)
+	for {
+		select {
+		case synced := <-db.Sync.SyncedCh:
+			db.ApplyOperation(synced.Entry.Bytes)
+		case <-db.stopChannel:
+			return
+		}
+	}
+}
+
+// listenForSyncEvents passes the events of the Sync component on to SyncEvents and emits
+// a JoinEvent for every peer that joins.
+func (db *Database) listenForSyncEvents() {
+	for {
+		select {
+		case event := <-db.Sync.Events:
+			if joined, ok := event.(orbitsync.PeerJoined); ok {
+				db.emit(JoinEvent{PeerID: joined.PeerID})
+			}
+
+			// Like the Sync, drop status updates rather than stall when nobody reads them
+			select {
+			case db.syncEvents <- event:
+			default:
+			}
+		case <-db.stopChannel:
+			return
+		}
+	}
+}
+
+// StartSync starts replicating with peers, exchanging heads with every peer that joins. It
+// does nothing if the database is already replicating, and returns ErrSyncDisabled if the
+// database was opened with SyncDisabled.
+func (db *Database) StartSync() error {
+	if db.Sync == nil {
+		return ErrSyncDisabled
 	}
+
+	select {
+	case <-db.stopChannel:
+		return errors.New("database is closed")
+	default:
+	}
+	if err := db.Sync.Start(); err != nil {
+		return fmt.Errorf("failed to start sync: %w", err)
+	}
+	return nil
+}
+
+// StopSync stops replicating with peers. The database stays open, entries added in the
+// meantime are exchanged with the peers once replication is started again.
+func (db *Database) StopSync() error {
+	if db.Sync == nil {
+		return ErrSyncDisabled
+	}
+	db.Sync.Stop()
+	return nil
+}
+
+// Syncing reports whether the database is replicating with peers.
+func (db *Database) Syncing() bool {
+	return db.Sync != nil && db.Sync.Running()
+}
+
+// SyncEvents returns the changes in the replication state of the database, e.g. peers
+// joining and leaving or heads being received and rejected.
+func (db *Database) SyncEvents() <-chan orbitsync.Event {
+	return db.syncEvents
 }
 
 // AddOperation appends a new operation to the log.
@@ -139,20 +357,16 @@ func (db *Database) AddOperation(op interface{}) (string, error) {
 			return
 		}
 
-		// Add the entry to sync
-		if syncErr := db.Sync.Add(entry.Payload); syncErr != nil {
-			result.err = fmt.Errorf("failed to sync entry: %w", syncErr)
-			resultChan <- result
-			return
+		// Announce the new head to peers
+		if db.Sync != nil {
+			if syncErr := db.Sync.Add(entry); syncErr != nil {
+				result.err = fmt.Errorf("failed to sync entry: %w", syncErr)
+				resultChan <- result
+				return
+			}
 		}
 
-		// Emit the update event safely
-		select {
-		case db.Events <- entry:
-		default:
-			// Log or handle the case where Events channel is full
-			fmt.Println("warning: Events channel full, event dropped")
-		}
+		db.emit(UpdateEvent{Entry: entry})
 
 		// Return the hash
 		result.hash = entry.Hash
@@ -181,16 +395,32 @@ func serializeOperation(op interface{}) (string, error) {
 	return string(bytes), nil
 }
 
+// OnClose registers a function to be called after the database has been closed.
+func (db *Database) OnClose(fn func()) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closeHooks = append(db.closeHooks, fn)
+}
+
 // Close stops the database's operations and cleans up resources.
 func (db *Database) Close() error {
 	close(db.stopChannel)
-	db.Sync.Stop()
+	db.cancel()
+	if db.Sync != nil {
+		db.Sync.Stop()
+	}
+	// The hooks run even if the log fails to close, so that the database is still released
 	err := db.Log.Close()
-	if err != nil {
-		return err
+	db.closeSubscriptions()
+
+	db.mu.Lock()
+	hooks := db.closeHooks
+	db.closeHooks = nil
+	db.mu.Unlock()
+	for _, hook := range hooks {
+		hook()
 	}
-	close(db.Events)
-	return nil
+	return err
 }
 
 // Drop clears the database, removing all entries.
@@ -200,43 +430,42 @@ func (db *Database) Drop() error {
 		return fmt.Errorf("failed to clear oplog: %w", err)
 	}
 
-	// Emit a drop event
-	db.Events <- "drop"
+	db.emit(DropEvent{})
 	return nil
 }
 
-// ApplyOperation applies an operation received via synchronization.
+// ApplyOperation applies an operation received via synchronization. The missing history of
+// the entry is fetched before the join is queued, so that fetching from peers does not hold up
+// the operations queued in the meantime.
 func (db *Database) ApplyOperation(data []byte) {
-	task := func() {
-		// Decode the received data into an entry
-		entry, err := oplog.Decode(data)
-		if err != nil {
-			fmt.Printf("applyOperation: failed to decode data: %v\n", err)
-			return
-		}
-
-		// Ensure entry belongs to the same log
-		if entry.Entry.ID != db.Log.ID {
-			fmt.Printf("applyOperation: log ID mismatch. Entry ID: %s, Log ID: %s\n", entry.Entry.ID, db.Log.ID)
-			return
-		}
+	// Decode the received data into an entry
+	entry, err := oplog.Decode(data)
+	if err != nil {
+		fmt.Printf("applyOperation: failed to decode data: %v\n", err)
+		return
+	}
 
-		// Join the entry into the log
-		processed := make(map[string]bool)
+	// Ensure entry belongs to the same log
+	if entry.Entry.ID != db.Log.ID {
+		fmt.Printf("applyOperation: log ID mismatch. Entry ID: %s, Log ID: %s\n", entry.Entry.ID, db.Log.ID)
+		return
+	}
 
+	// Fetch the entries missing from the log outside of the queue
+	missing, err := db.Log.FetchHistory(db.ctx, &entry, nil)
+	if err != nil {
+		fmt.Printf("applyOperation: failed to join entry: %v\n", err)
+		return
+	}
+	task := func() {
 		// Join the entry into the log
-		if joinErr := db.Log.JoinEntry(&entry, processed); joinErr != nil {
+		if joinErr := db.Log.JoinHistory(db.ctx, missing, nil); joinErr != nil {
 			fmt.Printf("applyOperation: failed to join entry: %v\n", joinErr)
 			return
 		}
+		db.notifyJoined()
 
-		// Emit the update event safely
-		select {
-		case db.Events <- &entry:
-		default:
-			// Log or handle the case where Events channel is full
-			fmt.Println("applyOperation: Events channel full, event dropped")
-		}
+		db.emit(UpdateEvent{Entry: &entry})
 	}
 
 	// Add the task to the queue
@@ -2,20 +2,25 @@ package databases_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/accesscontrollers"
 	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/identities"
 	"orbitdb/go-orbitdb/identities/identitytypes"
 	"orbitdb/go-orbitdb/identities/providers"
 	"orbitdb/go-orbitdb/keystore"
 	"orbitdb/go-orbitdb/oplog"
 	"orbitdb/go-orbitdb/storage"
+	orbitsync "orbitdb/go-orbitdb/syncutils"
 )
 
 func setupTestKeyStoreAndIdentity(t *testing.T) (*keystore.KeyStore, *identitytypes.Identity) {
@@ -64,7 +69,71 @@ func TestNewDatabase(t *testing.T) {
 	assert.Equal(t, identity, db.Identity)
 	assert.NotNil(t, db.Log)
 	assert.NotNil(t, db.Sync)
-	assert.NotNil(t, db.Events)
+}
+
+// closeRecordingStorage is a storage that records whether it has been closed, and fails to
+// close with err if it is set.
+type closeRecordingStorage struct {
+	storage.Storage
+	closed bool
+	err    error
+}
+
+func (s *closeRecordingStorage) Close() error {
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+	return s.Storage.Close()
+}
+
+// failingAttacher is an access controller that cannot be attached to a log.
+type failingAttacher struct{}
+
+func (failingAttacher) CanAppend(*oplog.EncodedEntry, oplog.IdentityProvider) (bool, error) {
+	return true, nil
+}
+
+func (failingAttacher) AttachLog(*oplog.Log) error {
+	return errors.New("attach failed")
+}
+
+// failingTransport is a transport whose topics cannot be joined, so sync cannot start.
+type failingTransport struct {
+	orbitsync.Transport
+}
+
+func (failingTransport) ID() string { return "failing" }
+
+func (failingTransport) Join(string) (orbitsync.Topic, error) {
+	return nil, errors.New("join failed")
+}
+
+func TestNewDatabaseReleasesLogOnFailure(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	ids, err := identities.NewIdentities("publickey", storage.NewMemoryStorage())
+	require.NoError(t, err)
+
+	// A failed attach closes the log it was given
+	entryStorage := &closeRecordingStorage{Storage: storage.NewMemoryStorage()}
+	_, err = databases.NewDatabase("test-address", "test-db", identity, entryStorage, ks, nil, nil,
+		databases.WithSyncMode(databases.SyncDisabled), databases.WithAccessController(failingAttacher{}),
+		databases.WithIdentityProvider(ids))
+	require.Error(t, err)
+	assert.True(t, entryStorage.closed, "Expected the log to be closed")
+
+	// So does a failure to start replicating
+	entryStorage = &closeRecordingStorage{Storage: storage.NewMemoryStorage()}
+	_, err = databases.NewDatabase("test-address", "test-db", identity, entryStorage, ks, nil, nil,
+		databases.WithTransport(failingTransport{}))
+	require.Error(t, err)
+	assert.True(t, entryStorage.closed, "Expected the log to be closed")
+
+	// The entry storage is released even before the log is created
+	entryStorage = &closeRecordingStorage{Storage: storage.NewMemoryStorage()}
+	_, err = databases.NewDatabase("", "test-db", identity, entryStorage, ks, nil, nil)
+	require.Error(t, err)
+	assert.True(t, entryStorage.closed, "Expected the entry storage to be closed")
 }
 
 // TestAddOperation tests adding an operation to the database.
@@ -76,6 +145,7 @@ func TestAddOperation(t *testing.T) {
 
 	db, err := databases.NewDatabase("test-address", "test-db", identity, entryStorage, ks, host1, ps)
 	require.NoError(t, err)
+	events := db.Subscribe(context.Background(), nil)
 
 	op := map[string]string{"key": "test", "value": "123"}
 	hash, err := db.AddOperation(op)
@@ -84,10 +154,10 @@ func TestAddOperation(t *testing.T) {
 
 	// Verify the event is emitted
 	select {
-	case event := <-db.Events:
-		entry, ok := event.(*oplog.EncodedEntry)
+	case event := <-events:
+		update, ok := event.(databases.UpdateEvent)
 		require.True(t, ok)
-		assert.Equal(t, hash, entry.Hash)
+		assert.Equal(t, hash, update.Entry.Hash)
 	default:
 		t.Error("Expected an event to be emitted")
 	}
@@ -120,6 +190,7 @@ func TestApplyOperation(t *testing.T) {
 	logID := "test-log"
 	db, err := databases.NewDatabase(logID, "test-db", identity, entryStorage, ks, host1, ps)
 	require.NoError(t, err)
+	events := db.Subscribe(context.Background(), nil)
 
 	// Create a payload for the test entry
 	payload := "test-payload"
@@ -136,16 +207,89 @@ func TestApplyOperation(t *testing.T) {
 
 	// Verify the event is emitted
 	select {
-	case event := <-db.Events:
-		emittedEntry, ok := event.(*oplog.EncodedEntry)
-		require.True(t, ok, "Expected emitted event to be of type *oplog.EncodedEntry")
-		assert.Equal(t, entry.Hash, emittedEntry.Hash, "Emitted entry hash does not match")
-		assert.Equal(t, entry.Payload, emittedEntry.Payload, "Emitted entry payload does not match")
+	case event := <-events:
+		update, ok := event.(databases.UpdateEvent)
+		require.True(t, ok, "Expected emitted event to be of type databases.UpdateEvent")
+		assert.Equal(t, entry.Hash, update.Entry.Hash, "Emitted entry hash does not match")
+		assert.Equal(t, entry.Payload, update.Entry.Payload, "Emitted entry payload does not match")
 	case <-time.After(1 * time.Second): // Add a timeout
 		t.Error("Expected an event to be emitted, but timed out")
 	}
 }
 
+// blockingFetcher fetches entries from a storage once it is released.
+type blockingFetcher struct {
+	storage  storage.Storage
+	fetching chan struct{}
+	release  chan struct{}
+}
+
+func (f *blockingFetcher) Fetch(ctx context.Context, hash string) ([]byte, error) {
+	select {
+	case f.fetching <- struct{}{}:
+	default:
+	}
+	select {
+	case <-f.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return f.storage.Get(hash)
+}
+
+// TestApplyOperationFetchOutsideQueue tests that local operations are not held up while the
+// missing history of a replicated entry is fetched.
+func TestApplyOperationFetchOutsideQueue(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	logID := "test-log"
+
+	// The parent of the replicated entry is only available through the fetcher
+	parent := oplog.NewEntry(ks, identity, logID, "parent", oplog.NewClock(identity.ID, 1), nil, nil)
+	child := oplog.NewEntry(ks, identity, logID, "child", oplog.NewClock(identity.ID, 2), []string{parent.Hash}, nil)
+	remote := storage.NewMemoryStorage()
+	require.NoError(t, remote.Put(parent.Hash, parent.Bytes))
+	fetcher := &blockingFetcher{storage: remote, fetching: make(chan struct{}, 1), release: make(chan struct{})}
+
+	host1, ps := setupLibp2pHostAndPubSub(t)
+	db, err := databases.NewDatabase(logID, "test-db", identity, storage.NewMemoryStorage(), ks, host1, ps,
+		databases.WithLogOptions(oplog.WithFetcher(fetcher)))
+	require.NoError(t, err)
+	defer db.Close()
+	events := db.Subscribe(context.Background(), nil)
+
+	go db.ApplyOperation(child.Bytes)
+	select {
+	case <-fetcher.fetching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the fetch to start")
+	}
+
+	added := make(chan error, 1)
+	go func() {
+		_, err := db.AddOperation(map[string]string{"key": "local"})
+		added <- err
+	}()
+	select {
+	case err := <-added:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the local operation not to wait for the fetch")
+	}
+
+	close(fetcher.release)
+	for {
+		select {
+		case event := <-events:
+			if update, ok := event.(databases.UpdateEvent); ok && update.Entry.Hash == child.Hash {
+				assert.True(t, db.Log.Has(parent.Hash), "Expected the fetched parent to be joined")
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected an event for the replicated entry")
+		}
+	}
+}
+
 // TestClose tests closing the database.
 func TestClose(t *testing.T) {
 	ks, identity := setupTestKeyStoreAndIdentity(t)
@@ -155,11 +299,206 @@ func TestClose(t *testing.T) {
 
 	db, err := databases.NewDatabase("test-address", "test-db", identity, entryStorage, ks, host1, ps)
 	require.NoError(t, err)
+	events := db.Subscribe(context.Background(), nil)
 
 	err = db.Close()
 	assert.NoError(t, err)
 
-	// Verify channels are closed
-	_, ok := <-db.Events
-	assert.False(t, ok, "Events channel should be closed")
+	// Verify subscriptions receive the close event and are closed
+	assert.Equal(t, databases.CloseEvent{}, <-events)
+	_, ok := <-events
+	assert.False(t, ok, "Subscription should be closed")
+
+	// Subscribing to a closed database returns a closed channel
+	_, ok = <-db.Subscribe(context.Background(), nil)
+	assert.False(t, ok, "Subscription to a closed database should be closed")
+}
+
+// TestCloseRunsHooksWhenLogFails tests that a database is released even if its log fails to close.
+func TestCloseRunsHooksWhenLogFails(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	entryStorage := &closeRecordingStorage{Storage: storage.NewMemoryStorage(), err: errors.New("close failed")}
+	db, err := databases.NewDatabase("test-address", "test-db", identity, entryStorage, ks, nil, nil,
+		databases.WithSyncMode(databases.SyncDisabled))
+	require.NoError(t, err)
+
+	hookCalled := false
+	db.OnClose(func() { hookCalled = true })
+
+	err = db.Close()
+	assert.ErrorIs(t, err, entryStorage.err)
+	assert.True(t, hookCalled, "Expected the close hooks to run")
+}
+
+// TestAccessControllerEnforced tests that local and replicated entries are checked against the access controller.
+func TestAccessControllerEnforced(t *testing.T) {
+	ids, err := identities.NewIdentities("publickey", storage.NewMemoryStorage())
+	require.NoError(t, err)
+	writer, err := ids.CreateIdentity("writer")
+	require.NoError(t, err)
+	reader, err := ids.CreateIdentity("reader")
+	require.NoError(t, err)
+
+	ac, err := accesscontrollers.IPFSAccessController(writer.ID)(accesscontrollers.Params{Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+
+	host1, ps := setupLibp2pHostAndPubSub(t)
+	logID := "test-log"
+	db, err := databases.NewDatabase(logID, "test-db", reader, storage.NewMemoryStorage(), ids.KeyStore(), host1, ps,
+		databases.WithAccessController(ac), databases.WithIdentityProvider(ids))
+	require.NoError(t, err)
+	assert.Equal(t, ac, db.AccessController)
+	events := db.Subscribe(context.Background(), nil)
+
+	// The reader is not allowed to write locally
+	_, err = db.AddOperation(map[string]string{"key": "test"})
+	assert.Error(t, err)
+
+	// Replicated entries from the reader are rejected, those from the writer accepted
+	denied := oplog.NewEntry(ids.KeyStore(), reader, logID, "denied", oplog.NewClock(reader.ID, 1), nil, nil)
+	db.ApplyOperation(denied.Bytes)
+	allowed := oplog.NewEntry(ids.KeyStore(), writer, logID, "allowed", oplog.NewClock(writer.ID, 1), nil, nil)
+	db.ApplyOperation(allowed.Bytes)
+
+	select {
+	case event := <-events:
+		update, ok := event.(databases.UpdateEvent)
+		require.True(t, ok)
+		assert.Equal(t, allowed.Hash, update.Entry.Hash)
+	case <-time.After(1 * time.Second):
+		t.Fatal("Expected an event for the allowed entry")
+	}
+
+	entries, err := db.Log.Values()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, allowed.Hash, entries[0].Hash)
+}
+
+// TestSyncEvents tests that replication is reported on the database's sync events.
+func TestSyncEvents(t *testing.T) {
+	newReplica := func() (host.Host, *databases.Database) {
+		ks, identity := setupTestKeyStoreAndIdentity(t)
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		ps, err := pubsub.NewGossipSub(context.Background(), h)
+		require.NoError(t, err)
+
+		db, err := databases.NewDatabase("test-address", "test-db", identity, storage.NewMemoryStorage(), ks, h, ps)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			db.Close()
+			h.Close()
+		})
+		return h, db
+	}
+
+	host1, db1 := newReplica()
+	host2, db2 := newReplica()
+
+	hash, err := db2.AddOperation(map[string]string{"key": "value"})
+	require.NoError(t, err)
+	joins := db1.Subscribe(context.Background(), func(event databases.Event) bool {
+		_, ok := event.(databases.JoinEvent)
+		return ok
+	})
+
+	require.NoError(t, host1.Connect(context.Background(), peer.AddrInfo{ID: host2.ID(), Addrs: host2.Addrs()}))
+
+	var joined, received bool
+	timeout := time.After(5 * time.Second)
+	for !joined || !received {
+		select {
+		case event := <-db1.SyncEvents():
+			switch e := event.(type) {
+			case orbitsync.PeerJoined:
+				assert.Equal(t, host2.ID().String(), e.PeerID)
+				joined = true
+			case orbitsync.HeadsReceived:
+				assert.Equal(t, []string{hash}, e.Heads)
+				received = true
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for sync events, joined: %v, received: %v", joined, received)
+		}
+	}
+
+	// The peer joining is also emitted to the database's subscribers
+	select {
+	case event := <-joins:
+		assert.Equal(t, databases.JoinEvent{PeerID: host2.ID().String()}, event)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the join event")
+	}
+
+	// The received head is joined into the log
+	require.Eventually(t, func() bool {
+		_, err := db1.Log.Get(hash)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSyncModeDisabled(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	// No networking is needed when sync is disabled
+	kv, err := databases.NewKeyValue("test-address", "test-db", identity, storage.NewMemoryStorage(), ks, nil, nil,
+		databases.WithSyncMode(databases.SyncDisabled))
+	require.NoError(t, err)
+	defer kv.Close()
+
+	_, err = kv.Put("key1", "value1")
+	require.NoError(t, err)
+	value, err := kv.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+
+	assert.False(t, kv.Syncing())
+	assert.ErrorIs(t, kv.StartSync(), databases.ErrSyncDisabled)
+	assert.ErrorIs(t, kv.StopSync(), databases.ErrSyncDisabled)
+	assert.ErrorIs(t, kv.WaitForSync(context.Background(), "peer"), databases.ErrSyncDisabled)
+	assert.Empty(t, kv.ReplicationStatus())
+}
+
+func TestStartAndStopSync(t *testing.T) {
+	network := orbitsync.NewMemoryNetwork()
+	blocks := &blockExchange{}
+
+	manual := setupMemoryReplica(t, network, "manual", blocks, databases.WithSyncMode(databases.SyncManual))
+	automatic := setupMemoryReplica(t, network, "automatic", blocks)
+	assert.False(t, manual.Syncing())
+	assert.True(t, automatic.Syncing())
+
+	// Nothing is replicated until sync is started
+	first, err := manual.Put("key1", "value1")
+	require.NoError(t, err)
+	assert.Empty(t, automatic.ReplicationStatus())
+
+	require.NoError(t, manual.StartSync())
+	require.NoError(t, manual.StartSync(), "Expected starting a running sync to do nothing")
+	assert.True(t, manual.Syncing())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, automatic.WaitForSync(ctx, "manual"))
+	assert.True(t, automatic.Log.Has(first))
+
+	// Entries added while sync is stopped are exchanged once it is restarted
+	require.NoError(t, manual.StopSync())
+	assert.False(t, manual.Syncing())
+	require.Eventually(t, func() bool {
+		_, ok := automatic.ReplicationStatus()["manual"]
+		return !ok
+	}, time.Second, 10*time.Millisecond, "Expected the stopped replica to leave")
+
+	second, err := manual.Put("key2", "value2")
+	require.NoError(t, err)
+	assert.False(t, automatic.Log.Has(second))
+
+	require.NoError(t, manual.StartSync())
+	require.NoError(t, automatic.WaitForSync(ctx, "manual"))
+	value, err := automatic.Get("key2")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", value)
 }
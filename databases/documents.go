@@ -1,6 +1,7 @@
 package databases
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -61,8 +62,13 @@ func (d *Documents) Put(doc map[string]interface{}) (string, error) {
 
 // Get retrieves a document by its index field value (key).
 func (d *Documents) Get(id string) (map[string]interface{}, error) {
+	return d.GetContext(context.Background(), id)
+}
+
+// GetContext retrieves a document by its index field value (key), giving up when ctx is done.
+func (d *Documents) GetContext(ctx context.Context, id string) (map[string]interface{}, error) {
 	// Retrieve the stored document
-	value, err := d.KeyValue.Get(id)
+	value, err := d.KeyValue.GetContext(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get document: %w", err)
 	}
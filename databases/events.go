@@ -1,6 +1,7 @@
 package databases
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"orbitdb/go-orbitdb/oplog"
@@ -39,7 +40,12 @@ func (e *Events) Add(value interface{}) (string, error) {
 
 // Get retrieves an event from the event log by its hash.
 func (e *Events) Get(hash string) (interface{}, error) {
-	entry, err := e.Log.Get(hash)
+	return e.GetContext(context.Background(), hash)
+}
+
+// GetContext retrieves an event from the event log by its hash, giving up when ctx is done.
+func (e *Events) GetContext(ctx context.Context, hash string) (interface{}, error) {
+	entry, err := e.Log.GetContext(ctx, hash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get log entry: %w", err)
 	}
@@ -1,6 +1,7 @@
 package databases
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,15 +17,11 @@ type KeyValue struct {
 	*Database
 }
 
-// NewKeyValue creates a new KeyValue database instance.
-func NewKeyValue(address, name string, identity *identitytypes.Identity, entryStorage storage.Storage, keyStore *keystore.KeyStore, host host.Host, ps *pubsub.PubSub) (*KeyValue, error) {
-	// Ensure required parameters are provided
-	if host == nil || ps == nil {
-		return nil, errors.New("host and pubsub instances are required")
-	}
-
+// NewKeyValue creates a new KeyValue database instance. The host and pubsub may be nil when
+// a transport is set with WithTransport.
+func NewKeyValue(address, name string, identity *identitytypes.Identity, entryStorage storage.Storage, keyStore *keystore.KeyStore, host host.Host, ps *pubsub.PubSub, options ...Option) (*KeyValue, error) {
 	// Initialize the base database
-	baseDB, err := NewDatabase(address, name, identity, entryStorage, keyStore, host, ps)
+	baseDB, err := NewDatabase(address, name, identity, entryStorage, keyStore, host, ps, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create base database: %w", err)
 	}
@@ -33,6 +30,15 @@ func NewKeyValue(address, name string, identity *identitytypes.Identity, entrySt
 
 // Put adds or updates a key-value pair.
 func (kv *KeyValue) Put(key string, value interface{}) (string, error) {
+	payload, err := putOperation(key, value)
+	if err != nil {
+		return "", err
+	}
+	return kv.AddOperation(payload)
+}
+
+// putOperation serializes the operation putting the value under the key.
+func putOperation(key string, value interface{}) (string, error) {
 	if key == "" {
 		return "", errors.New("key cannot be empty")
 	}
@@ -48,12 +54,17 @@ func (kv *KeyValue) Put(key string, value interface{}) (string, error) {
 		return "", fmt.Errorf("failed to serialize operation: %w", err)
 	}
 
-	return kv.AddOperation(string(payload))
+	return string(payload), nil
 }
 
 // Get retrieves the value for a given key.
 func (kv *KeyValue) Get(key string) (interface{}, error) {
-	entries, err := kv.Log.Values()
+	return kv.GetContext(context.Background(), key)
+}
+
+// GetContext retrieves the value for a given key, giving up when ctx is done.
+func (kv *KeyValue) GetContext(ctx context.Context, key string) (interface{}, error) {
+	entries, err := kv.Log.ValuesContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve log entries: %w", err)
 	}
@@ -98,6 +109,15 @@ func (kv *KeyValue) Get(key string) (interface{}, error) {
 
 // Del removes a key-value pair.
 func (kv *KeyValue) Del(key string) (string, error) {
+	payload, err := delOperation(key)
+	if err != nil {
+		return "", err
+	}
+	return kv.AddOperation(payload)
+}
+
+// delOperation serializes the operation removing the key.
+func delOperation(key string) (string, error) {
 	if key == "" {
 		return "", errors.New("key cannot be empty")
 	}
@@ -112,12 +132,47 @@ func (kv *KeyValue) Del(key string) (string, error) {
 		return "", fmt.Errorf("failed to serialize operation: %w", err)
 	}
 
-	return kv.AddOperation(string(payload))
+	return string(payload), nil
+}
+
+// KeyValueTx collects the puts and deletes of a KeyValue batch.
+type KeyValueTx struct {
+	*Tx
+}
+
+// Put adds or updates a key-value pair in the batch.
+func (tx *KeyValueTx) Put(key string, value interface{}) error {
+	payload, err := putOperation(key, value)
+	if err != nil {
+		return err
+	}
+	return tx.AddOperation(payload)
+}
+
+// Del removes a key-value pair in the batch.
+func (tx *KeyValueTx) Del(key string) error {
+	payload, err := delOperation(key)
+	if err != nil {
+		return err
+	}
+	return tx.AddOperation(payload)
+}
+
+// Batch applies the puts and deletes made by fn together, see Database.Batch.
+func (kv *KeyValue) Batch(fn func(tx *KeyValueTx) error) error {
+	return kv.Database.Batch(func(tx *Tx) error {
+		return fn(&KeyValueTx{Tx: tx})
+	})
 }
 
 // All retrieves all key-value pairs in the database.
 func (kv *KeyValue) All() (map[string]interface{}, error) {
-	entries, err := kv.Log.Values()
+	return kv.AllContext(context.Background())
+}
+
+// AllContext retrieves all key-value pairs in the database, giving up when ctx is done.
+func (kv *KeyValue) AllContext(ctx context.Context) (map[string]interface{}, error) {
+	entries, err := kv.Log.ValuesContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve log entries: %w", err)
 	}
@@ -1,9 +1,9 @@
 package databases
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"sort"
 	"sync"
 
 	"orbitdb/go-orbitdb/storage"
@@ -30,7 +30,11 @@ func NewKeyValueIndexed(baseDB *KeyValue, indexStorage storage.Storage) (*KeyVal
 	}, nil
 }
 
-// UpdateIndex updates the index by traversing the log and processing entries.
+// UpdateIndex updates the index by traversing the log and processing entries. The changes
+// to the index are written with a single batch, so the index is never left with only some
+// of them. The index is derived from the log, which is written first: the processed entries
+// are only remembered in memory, so after a restart every entry is indexed again and an
+// update lost in a crash is redone.
 func (kvi *KeyValueIndexed) UpdateIndex() error {
 	kvi.mu.Lock()
 	defer kvi.mu.Unlock()
@@ -41,6 +45,9 @@ func (kvi *KeyValueIndexed) UpdateIndex() error {
 		return fmt.Errorf("failed to retrieve log entries: %w", err)
 	}
 
+	batch := kvi.indexStorage.NewBatch()
+	var indexed []string
+
 	for _, entry := range entries {
 		if kvi.processed[entry.Hash] {
 			continue
@@ -85,17 +92,19 @@ func (kvi *KeyValueIndexed) UpdateIndex() error {
 				return fmt.Errorf("failed to serialize index entry: %w", err)
 			}
 
-			if err := kvi.indexStorage.Put(key, serializedEntry); err != nil {
-				fmt.Printf("Warning: Failed to index key %s: %v\n", key, err)
-				continue
-			}
+			batch.Put(key, serializedEntry)
 		case "DEL":
-			if err := kvi.indexStorage.Delete(key); err != nil {
-				fmt.Printf("Warning: Failed to delete key %s from index: %v\n", key, err)
-			}
+			batch.Delete(key)
 		}
 
-		kvi.processed[entry.Hash] = true
+		indexed = append(indexed, entry.Hash)
+	}
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	for _, hash := range indexed {
+		kvi.processed[hash] = true
 	}
 
 	fmt.Println("Debug: UpdateIndex completed")
@@ -132,37 +141,40 @@ func (kvi *KeyValueIndexed) Get(key string) (interface{}, error) {
 	return indexEntry["value"], nil
 }
 
-// Iterator iterates over key-value pairs in the database.
+// Iterator iterates over key-value pairs in the database in key order, returning at
+// most amount of them if amount is positive.
 func (kvi *KeyValueIndexed) Iterator(amount int) ([]map[string]interface{}, error) {
-	iter, err := kvi.indexStorage.Iterator()
+	return kvi.IteratorContext(context.Background(), amount)
+}
+
+// IteratorContext iterates over key-value pairs like Iterator, giving up when ctx is done.
+// Only the pairs returned are read from the index.
+func (kvi *KeyValueIndexed) IteratorContext(ctx context.Context, amount int) ([]map[string]interface{}, error) {
+	iter, err := kvi.indexStorage.IterateRange(ctx, storage.IteratorOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create iterator: %w", err)
 	}
+	defer iter.Close()
 
 	results := []map[string]interface{}{}
 
-	for kv := range iter {
+	// Entries that can't be decoded are skipped, so the amount is counted here rather
+	// than with the iterator's limit
+	for (amount <= 0 || len(results) < amount) && iter.Next() {
 		var indexEntry map[string]interface{}
-		if err := json.Unmarshal([]byte(kv[1]), &indexEntry); err != nil {
-			fmt.Printf("Warning: Failed to decode index entry for key %s: %v\n", kv[0], err)
+		if err := json.Unmarshal(iter.Value(), &indexEntry); err != nil {
+			fmt.Printf("Warning: Failed to decode index entry for key %s: %v\n", iter.Key(), err)
 			continue
 		}
 
 		results = append(results, map[string]interface{}{
-			"key":   kv[0],
+			"key":   iter.Key(),
 			"value": indexEntry["value"],
 			"hash":  indexEntry["hash"],
 		})
 	}
-
-	// Sort results by key for deterministic order
-	sort.Slice(results, func(i, j int) bool {
-		return results[i]["key"].(string) < results[j]["key"].(string)
-	})
-
-	// Apply amount limit
-	if amount > 0 && len(results) > amount {
-		results = results[:amount]
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over index: %w", err)
 	}
 
 	return results, nil
@@ -158,4 +158,50 @@ func TestKeyValueIndexed_Iterator(t *testing.T) {
 	// Validate that limiting works correctly
 	assert.Equal(t, "key1", limitedEntries[0]["key"])
 	assert.Equal(t, "key2", limitedEntries[1]["key"])
+
+	// Iterating gives up once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = kvi.IteratorContext(ctx, -1)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// failingBatchStorage is a storage whose batches fail to commit.
+type failingBatchStorage struct {
+	storage.Storage
+}
+
+func (s failingBatchStorage) NewBatch() storage.Batch {
+	return failingBatch{Batch: s.Storage.NewBatch()}
+}
+
+type failingBatch struct {
+	storage.Batch
+}
+
+func (failingBatch) Commit() error {
+	return fmt.Errorf("commit failed")
+}
+
+// TestKeyValueIndexed_UpdateIndexBatch tests that the index is written all at once
+func TestKeyValueIndexed_UpdateIndexBatch(t *testing.T) {
+	kv := setupBatchKeyValue(t)
+	_, err := kv.Put("key1", "value1")
+	require.NoError(t, err)
+	_, err = kv.Put("key2", "value2")
+	require.NoError(t, err)
+
+	// Nothing is indexed when the index can't be written
+	kvi, err := databases.NewKeyValueIndexed(kv, failingBatchStorage{Storage: storage.NewMemoryStorage()})
+	require.NoError(t, err)
+	assert.Error(t, kvi.UpdateIndex())
+	_, err = kvi.Get("key1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	kvi, err = databases.NewKeyValueIndexed(kv, storage.NewMemoryStorage())
+	require.NoError(t, err)
+	require.NoError(t, kvi.UpdateIndex())
+	value, err := kvi.Get("key2")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", value)
 }
@@ -1,11 +1,15 @@
 package databases_test
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/storage"
+	orbitsync "orbitdb/go-orbitdb/syncutils"
 )
 
 // TestPut tests the Put method of KeyValue
@@ -54,6 +58,26 @@ func TestGet(t *testing.T) {
 	assert.Nil(t, value)
 }
 
+// TestGetContext tests that reads of KeyValue give up once the context is done
+func TestGetContext(t *testing.T) {
+	kv := setupKeyValueTest(t)
+
+	_, err := kv.Put("key1", "value1")
+	require.NoError(t, err)
+
+	value, err := kv.GetContext(context.Background(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = kv.GetContext(ctx, "key1")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = kv.AllContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
 // TestDel tests the Del method of KeyValue
 func TestDel(t *testing.T) {
 	kv := setupKeyValueTest(t)
@@ -141,3 +165,23 @@ func TestInvalidKey(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "key cannot be empty")
 }
+
+// TestNewKeyValueTransport tests that a transport replaces the libp2p host and pubsub
+func TestNewKeyValueTransport(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	// Without a transport, the host and pubsub are required
+	_, err := databases.NewKeyValue("test-address", "test-db", identity, storage.NewMemoryStorage(), ks, nil, nil)
+	assert.Error(t, err)
+
+	transport, err := orbitsync.NewMemoryNetwork().NewTransport("peer")
+	require.NoError(t, err)
+	kv, err := databases.NewKeyValue("test-address", "test-db", identity, storage.NewMemoryStorage(), ks, nil, nil,
+		databases.WithTransport(transport))
+	require.NoError(t, err)
+	defer kv.Close()
+
+	_, err = kv.Put("key1", "value1")
+	require.NoError(t, err)
+	assert.Equal(t, "peer", kv.Sync.ID)
+}
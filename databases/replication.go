@@ -0,0 +1,95 @@
+package databases
+
+import (
+	"context"
+
+	orbitsync "orbitdb/go-orbitdb/syncutils"
+)
+
+// PeerStatus is the replication progress of a database with a single peer.
+type PeerStatus struct {
+	PeerID    string
+	Heads     []string // Heads the peer advertised
+	Missing   []string // Advertised heads that have not been joined into the local log yet
+	Rejected  []string // Advertised heads that failed verification and will not be joined
+	Exchanged bool     // Whether the peer's full heads set has been received
+	Synced    bool     // Whether the heads were exchanged and none are missing or rejected
+}
+
+// ReplicationStatus returns the replication progress with every peer replicating the database.
+// An advertised head is only joined together with all of its ancestors, so a peer is synced
+// once every head it advertised is in the local log. A peer that advertised heads which were
+// rejected is never synced.
+func (db *Database) ReplicationStatus() map[string]PeerStatus {
+	if db.Sync == nil {
+		return make(map[string]PeerStatus)
+	}
+
+	peers := db.Sync.PeerHeads()
+	status := make(map[string]PeerStatus, len(peers))
+	for peerID, advertised := range peers {
+		status[peerID] = db.peerStatus(peerID, advertised)
+	}
+	return status
+}
+
+// WaitForSync waits until the database has received the heads of the peer and joined them,
+// with all of their ancestors, into the local log. It returns the context's error if that
+// does not happen before the context is done, or ErrSyncDisabled if the database does not
+// replicate.
+func (db *Database) WaitForSync(ctx context.Context, peerID string) error {
+	if db.Sync == nil {
+		return ErrSyncDisabled
+	}
+
+	for {
+		// Take the notification channels before checking so that no change is missed
+		syncChanged := db.Sync.Changed()
+		joined := db.joinedChanged()
+
+		if advertised, ok := db.Sync.PeerHeads()[peerID]; ok {
+			if db.peerStatus(peerID, advertised).Synced {
+				return nil
+			}
+		}
+
+		select {
+		case <-syncChanged:
+		case <-joined:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// peerStatus checks which of the heads advertised by the peer are missing from the log.
+func (db *Database) peerStatus(peerID string, advertised orbitsync.PeerHeads) PeerStatus {
+	status := PeerStatus{
+		PeerID:    peerID,
+		Heads:     advertised.Heads,
+		Rejected:  advertised.Rejected,
+		Exchanged: advertised.Exchanged,
+	}
+	for _, hash := range advertised.Heads {
+		if !db.Log.Has(hash) {
+			status.Missing = append(status.Missing, hash)
+		}
+	}
+	status.Synced = status.Exchanged && len(status.Missing) == 0 && len(status.Rejected) == 0
+	return status
+}
+
+// joinedChanged returns a channel that is closed the next time entries are joined into the log.
+func (db *Database) joinedChanged() <-chan struct{} {
+	db.joinedMu.Lock()
+	defer db.joinedMu.Unlock()
+	return db.joined
+}
+
+// notifyJoined wakes up everyone waiting for entries to be joined.
+func (db *Database) notifyJoined() {
+	db.joinedMu.Lock()
+	defer db.joinedMu.Unlock()
+	close(db.joined)
+	db.joined = make(chan struct{})
+}
@@ -0,0 +1,289 @@
+package databases_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/identities"
+	"orbitdb/go-orbitdb/identities/providers"
+	"orbitdb/go-orbitdb/keystore"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+	orbitsync "orbitdb/go-orbitdb/syncutils"
+)
+
+// setupReplica creates a database on a loopback host that is closed when the test ends.
+func setupReplica(t *testing.T, entryStorage storage.Storage, options ...databases.Option) (host.Host, *databases.Database) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	ps, err := pubsub.NewGossipSub(context.Background(), h)
+	require.NoError(t, err)
+
+	db, err := databases.NewDatabase("test-address", "test-db", identity, entryStorage, ks, h, ps, options...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		h.Close()
+	})
+	return h, db
+}
+
+func TestWaitForSync(t *testing.T) {
+	// The second replica's entries can be fetched by the first
+	entries2 := storage.NewMemoryStorage()
+	fetcher, err := oplog.NewStorageFetcher(entries2)
+	require.NoError(t, err)
+
+	host1, db1 := setupReplica(t, storage.NewMemoryStorage(), databases.WithLogOptions(oplog.WithFetcher(fetcher)))
+	host2, db2 := setupReplica(t, entries2)
+
+	var hashes []string
+	for i := 0; i < 3; i++ {
+		hash, err := db2.AddOperation(map[string]int{"i": i})
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+	assert.Empty(t, db1.ReplicationStatus())
+
+	require.NoError(t, host1.Connect(context.Background(), peer.AddrInfo{ID: host2.ID(), Addrs: host2.Addrs()}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, db1.WaitForSync(ctx, host2.ID().String()))
+
+	// The whole history of the peer's head is present
+	for _, hash := range hashes {
+		assert.True(t, db1.Log.Has(hash), "Expected entry %s to be replicated", hash)
+	}
+
+	peerID := host2.ID().String()
+	assert.Equal(t, databases.PeerStatus{PeerID: peerID, Heads: []string{hashes[2]}, Exchanged: true, Synced: true},
+		db1.ReplicationStatus()[peerID])
+
+	// The other side is synced as soon as it has received the empty heads set
+	require.NoError(t, db2.WaitForSync(ctx, host1.ID().String()))
+}
+
+func TestWaitForSyncMissingHistory(t *testing.T) {
+	// Without a fetcher for the peer's entries, the history of its head cannot be joined
+	host1, db1 := setupReplica(t, storage.NewMemoryStorage())
+	host2, db2 := setupReplica(t, storage.NewMemoryStorage())
+
+	_, err := db2.AddOperation(map[string]int{"i": 0})
+	require.NoError(t, err)
+	head, err := db2.AddOperation(map[string]int{"i": 1})
+	require.NoError(t, err)
+
+	require.NoError(t, host1.Connect(context.Background(), peer.AddrInfo{ID: host2.ID(), Addrs: host2.Addrs()}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, db1.WaitForSync(ctx, host2.ID().String()), context.DeadlineExceeded)
+
+	status := db1.ReplicationStatus()[host2.ID().String()]
+	assert.True(t, status.Exchanged)
+	assert.False(t, status.Synced)
+	assert.Equal(t, []string{head}, status.Missing)
+}
+
+// denyAccessController does not allow anyone to write.
+type denyAccessController struct{}
+
+func (denyAccessController) CanAppend(*oplog.EncodedEntry, oplog.IdentityProvider) (bool, error) {
+	return false, nil
+}
+
+func TestWaitForSyncRejectedHeads(t *testing.T) {
+	// The first replica does not accept the entries of the second
+	ids, err := identities.NewIdentities("publickey", storage.NewMemoryStorage())
+	require.NoError(t, err)
+	host1, db1 := setupReplica(t, storage.NewMemoryStorage(),
+		databases.WithAccessController(denyAccessController{}), databases.WithIdentityProvider(ids))
+	host2, db2 := setupReplica(t, storage.NewMemoryStorage())
+
+	head, err := db2.AddOperation(map[string]int{"i": 0})
+	require.NoError(t, err)
+
+	require.NoError(t, host1.Connect(context.Background(), peer.AddrInfo{ID: host2.ID(), Addrs: host2.Addrs()}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, db1.WaitForSync(ctx, host2.ID().String()), context.DeadlineExceeded)
+
+	status := db1.ReplicationStatus()[host2.ID().String()]
+	assert.True(t, status.Exchanged)
+	assert.False(t, status.Synced)
+	assert.Equal(t, []string{head}, status.Heads)
+	assert.Equal(t, []string{head}, status.Rejected)
+}
+
+// blockExchange fetches missing entries from the storage of any replica, like a block
+// exchange would.
+type blockExchange struct {
+	storages []storage.Storage
+}
+
+func (b *blockExchange) Fetch(ctx context.Context, hash string) ([]byte, error) {
+	for _, s := range b.storages {
+		if data, err := s.Get(hash); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("entry %s not found", hash)
+}
+
+// setupMemoryReplica creates a key-value replica on the in-memory network, fetching missing
+// history through the block exchange.
+func setupMemoryReplica(t *testing.T, network *orbitsync.MemoryNetwork, id string, blocks *blockExchange, options ...databases.Option) *databases.KeyValue {
+	// Every replica writes with its own identity, so that concurrent writes are ordered the same everywhere
+	ks := keystore.NewKeyStore(storage.NewMemoryStorage())
+	identity, err := providers.NewPublicKeyProvider(ks).CreateIdentity("replica-" + id)
+	require.NoError(t, err)
+
+	transport, err := network.NewTransport(id)
+	require.NoError(t, err)
+
+	entryStorage := storage.NewMemoryStorage()
+	blocks.storages = append(blocks.storages, entryStorage)
+
+	options = append([]databases.Option{databases.WithTransport(transport), databases.WithLogOptions(oplog.WithFetcher(blocks))}, options...)
+	kv, err := databases.NewKeyValue("test-address", "test-db", identity, entryStorage, ks, nil, nil, options...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		kv.Close()
+		transport.Close()
+	})
+	return kv
+}
+
+// waitForConvergence waits until every replica is synced with every other replica.
+func waitForConvergence(t *testing.T, replicas map[string]*databases.KeyValue) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for id, replica := range replicas {
+		for peerID := range replicas {
+			if peerID != id {
+				require.NoError(t, replica.WaitForSync(ctx, peerID), "Replica %s did not sync with %s", id, peerID)
+			}
+		}
+	}
+}
+
+// setupIdentityReplica creates a key-value replica on the in-memory network whose writer
+// identities are resolved and verified with an Identities of its own, which only knows the
+// replica's identity until identities are received from peers.
+func setupIdentityReplica(t *testing.T, network *orbitsync.MemoryNetwork, id string, blocks *blockExchange) (*databases.KeyValue, *identities.Identities) {
+	ids, err := identities.NewIdentities("publickey", storage.NewMemoryStorage())
+	require.NoError(t, err)
+	identity, err := ids.CreateIdentity("replica-" + id)
+	require.NoError(t, err)
+
+	transport, err := network.NewTransport(id)
+	require.NoError(t, err)
+
+	entryStorage := storage.NewMemoryStorage()
+	blocks.storages = append(blocks.storages, entryStorage)
+
+	kv, err := databases.NewKeyValue("test-address", "test-db", identity, entryStorage, ids.KeyStore(), nil, nil,
+		databases.WithTransport(transport),
+		databases.WithLogOptions(oplog.WithFetcher(blocks)),
+		databases.WithIdentityProvider(ids))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		kv.Close()
+		transport.Close()
+		ids.Close()
+	})
+	return kv, ids
+}
+
+func TestReplicasResolveIdentitiesOfOtherWriters(t *testing.T) {
+	network := orbitsync.NewMemoryNetwork()
+	blocks := &blockExchange{}
+
+	// The first replica writes before the second one joins, so its head is sent over a heads stream
+	a, aIDs := setupIdentityReplica(t, network, "a", blocks)
+	_, err := a.Put("key-a", "a")
+	require.NoError(t, err)
+
+	b, bIDs := setupIdentityReplica(t, network, "b", blocks)
+	_, err = bIDs.GetIdentity(a.Identity.Hash)
+	require.Error(t, err, "Expected the second replica not to know the first replica's identity yet")
+
+	require.Eventually(t, func() bool {
+		value, err := b.Get("key-a")
+		return err == nil && value == "a"
+	}, 5*time.Second, 10*time.Millisecond, "Expected the entry of the other writer to be replicated")
+
+	// Entries written once the replicas are connected are announced over pubsub
+	_, err = b.Put("key-b", "b")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		value, err := a.Get("key-b")
+		return err == nil && value == "b"
+	}, 5*time.Second, 10*time.Millisecond, "Expected the entry of the other writer to be replicated")
+
+	resolved, err := aIDs.GetIdentity(b.Identity.Hash)
+	require.NoError(t, err)
+	assert.True(t, aIDs.VerifyIdentity(resolved))
+}
+
+func TestReplicasConvergeOverMemoryNetwork(t *testing.T) {
+	network := orbitsync.NewMemoryNetwork(orbitsync.WithLatency(5 * time.Millisecond))
+	blocks := &blockExchange{}
+
+	replicas := make(map[string]*databases.KeyValue)
+	for _, id := range []string{"a", "b", "c"} {
+		replicas[id] = setupMemoryReplica(t, network, id, blocks)
+		_, err := replicas[id].Put("key-"+id, id)
+		require.NoError(t, err)
+	}
+	waitForConvergence(t, replicas)
+
+	expected := map[string]interface{}{"key-a": "a", "key-b": "b", "key-c": "c"}
+	for id, replica := range replicas {
+		all, err := replica.All()
+		require.NoError(t, err)
+		assert.Equal(t, expected, all, "Replica %s did not converge", id)
+	}
+
+	// Both sides of a partition write the same key
+	network.Partition([]string{"a"}, []string{"b", "c"})
+	require.Eventually(t, func() bool {
+		_, bSeesA := replicas["b"].ReplicationStatus()["a"]
+		_, cSeesA := replicas["c"].ReplicationStatus()["a"]
+		return len(replicas["a"].ReplicationStatus()) == 0 && !bSeesA && !cSeesA
+	}, time.Second, 10*time.Millisecond, "Expected the replicas to see the other side leave")
+	_, err := replicas["a"].Put("shared", "from-a")
+	require.NoError(t, err)
+	_, err = replicas["b"].Put("shared", "from-b")
+	require.NoError(t, err)
+
+	value, err := replicas["a"].Get("shared")
+	require.NoError(t, err)
+	assert.Equal(t, "from-a", value, "Expected the write from the other side not to be replicated yet")
+
+	// The replicas exchange their heads again once the partition is healed
+	network.Heal()
+	waitForConvergence(t, replicas)
+
+	values := make(map[string]interface{})
+	for id, replica := range replicas {
+		values[id], err = replica.Get("shared")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, values["a"], values["b"], "Replicas disagree on the concurrently written key")
+	assert.Equal(t, values["a"], values["c"], "Replicas disagree on the concurrently written key")
+}
@@ -0,0 +1,281 @@
+package databases
+
+import (
+	"context"
+	"errors"
+	"orbitdb/go-orbitdb/oplog"
+	"sync"
+	"time"
+)
+
+// DefaultSubscriptionBufferSize is the number of events buffered for a subscriber by default.
+const DefaultSubscriptionBufferSize = 100
+
+// DefaultBlockTimeout is how long an event waits for a subscriber with the OverflowBlock
+// policy by default.
+const DefaultBlockTimeout = 5 * time.Second
+
+// ErrSubscriptionOverflow is reported to a subscriber with the OverflowError policy whose
+// buffer is full, and to a subscriber with the OverflowBlock policy that did not make room in time.
+var ErrSubscriptionOverflow = errors.New("subscription buffer overflow")
+
+// Event is an event emitted by a Database to its subscribers.
+type Event interface {
+	databaseEvent()
+}
+
+// UpdateEvent is emitted when an entry is added to the database, locally or by a peer. A
+// batch emits a single UpdateEvent for the last of its entries.
+type UpdateEvent struct {
+	Entry   *oplog.EncodedEntry
+	Entries []*oplog.EncodedEntry // All entries added by a batch, oldest first; nil for a single entry
+}
+
+// JoinEvent is emitted when a peer replicating the database joins.
+type JoinEvent struct {
+	PeerID string
+}
+
+// DropEvent is emitted when the database has been cleared.
+type DropEvent struct{}
+
+// CloseEvent is the last event emitted before the database is closed. The subscription's
+// channel is closed right after it.
+type CloseEvent struct{}
+
+// ErrorEvent is the last event a subscription receives when it is ended because of an error,
+// e.g. ErrSubscriptionOverflow.
+type ErrorEvent struct {
+	Err error
+}
+
+func (UpdateEvent) databaseEvent() {}
+func (JoinEvent) databaseEvent()   {}
+func (DropEvent) databaseEvent()   {}
+func (CloseEvent) databaseEvent()  {}
+func (ErrorEvent) databaseEvent()  {}
+
+// Filter selects the events a subscriber receives. A nil Filter selects all events.
+type Filter func(event Event) bool
+
+// OverflowPolicy decides what happens to an event when a subscriber's buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest buffered event to make room for the new one.
+	// This is the default.
+	OverflowDropOldest OverflowPolicy = iota
+
+	// OverflowBlock waits until the subscriber has room for the event. The database does
+	// not make progress while it waits, so the subscription is ended with an ErrorEvent
+	// carrying ErrSubscriptionOverflow if there is no room within the block timeout, see
+	// WithBlockTimeout. Closing the database gives up on the event.
+	OverflowBlock
+
+	// OverflowError ends the subscription with an ErrorEvent carrying ErrSubscriptionOverflow.
+	OverflowError
+)
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*subscriber)
+
+// WithBufferSize sets the number of events buffered for the subscriber.
+func WithBufferSize(size int) SubscribeOption {
+	return func(s *subscriber) {
+		s.size = size
+	}
+}
+
+// WithOverflowPolicy sets what happens to events when the subscriber's buffer is full.
+// The default is OverflowDropOldest.
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(s *subscriber) {
+		s.policy = policy
+	}
+}
+
+// WithBlockTimeout sets how long an event waits for room in the buffer of a subscriber with
+// the OverflowBlock policy before the subscription is ended. The default is DefaultBlockTimeout.
+func WithBlockTimeout(timeout time.Duration) SubscribeOption {
+	return func(s *subscriber) {
+		s.timeout = timeout
+	}
+}
+
+// subscriber is a single subscription to the events of a database.
+type subscriber struct {
+	ctx     context.Context
+	filter  Filter
+	size    int
+	policy  OverflowPolicy
+	timeout time.Duration // How long a blocked send waits with OverflowBlock
+	ch      chan Event
+	abort   <-chan struct{} // Closed when the database is closing, ends blocked sends
+	done    chan struct{}   // Closed once the subscription has ended
+	mu      sync.Mutex      // Serializes sends and closing the channel
+	closed  bool
+}
+
+// send passes the event to the subscriber according to its filter and overflow policy.
+func (s *subscriber) send(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || (s.filter != nil && !s.filter(event)) {
+		return
+	}
+
+	switch s.policy {
+	default: // OverflowDropOldest
+		for len(s.ch) >= s.size {
+			select {
+			case <-s.ch:
+			default:
+			}
+		}
+		s.ch <- event
+
+	case OverflowError:
+		if len(s.ch) >= s.size {
+			s.closeLocked(ErrorEvent{Err: ErrSubscriptionOverflow})
+			return
+		}
+		s.ch <- event
+
+	case OverflowBlock:
+		select {
+		case s.ch <- event:
+			return
+		default:
+		}
+
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		select {
+		case s.ch <- event:
+		case <-timer.C:
+			s.closeLocked(ErrorEvent{Err: ErrSubscriptionOverflow})
+		case <-s.ctx.Done():
+		case <-s.abort:
+		}
+	}
+}
+
+// close ends the subscription. Unless it is nil, the final event is passed to the subscriber
+// before the channel is closed.
+func (s *subscriber) close(final Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked(final)
+}
+
+// closeLocked ends the subscription like close. The final event never blocks: the buffer keeps
+// room for it, except with OverflowBlock, where it is left out if the buffer is full. The
+// closed channel tells the subscriber that the subscription has ended either way. The caller
+// must hold s.mu.
+func (s *subscriber) closeLocked(final Event) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	if final != nil {
+		select {
+		case s.ch <- final:
+		default:
+		}
+	}
+	close(s.ch)
+	close(s.done)
+}
+
+// Subscribe returns a channel receiving the events of the database selected by the filter.
+// Every subscriber has its own buffer; see WithBufferSize and WithOverflowPolicy. The
+// channel is closed when the context is canceled, when the subscription ends because of an
+// ErrorEvent, or after the CloseEvent once the database is closed.
+func (db *Database) Subscribe(ctx context.Context, filter Filter, options ...SubscribeOption) <-chan Event {
+	sub := &subscriber{
+		ctx:     ctx,
+		filter:  filter,
+		size:    DefaultSubscriptionBufferSize,
+		policy:  OverflowDropOldest,
+		timeout: DefaultBlockTimeout,
+		abort:   db.stopChannel,
+		done:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(sub)
+	}
+	if sub.size < 1 && sub.policy != OverflowBlock {
+		sub.size = 1
+	}
+	if sub.size < 0 {
+		sub.size = 0
+	}
+
+	// Keep room for the final event, except when blocking, where the buffer size is exact
+	capacity := sub.size
+	if sub.policy != OverflowBlock {
+		capacity++
+	}
+	sub.ch = make(chan Event, capacity)
+
+	db.subscribersMu.Lock()
+	if db.closed {
+		db.subscribersMu.Unlock()
+		sub.close(nil)
+		return sub.ch
+	}
+	db.subscribers[sub] = struct{}{}
+	db.subscribersMu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			db.unsubscribe(sub)
+		case <-sub.done:
+			db.unsubscribe(sub)
+		}
+	}()
+
+	return sub.ch
+}
+
+// unsubscribe removes the subscriber and ends its subscription.
+func (db *Database) unsubscribe(sub *subscriber) {
+	db.subscribersMu.Lock()
+	delete(db.subscribers, sub)
+	db.subscribersMu.Unlock()
+	sub.close(nil)
+}
+
+// emit sends the event to every subscriber.
+func (db *Database) emit(event Event) {
+	db.subscribersMu.RLock()
+	subscribers := make([]*subscriber, 0, len(db.subscribers))
+	for sub := range db.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	db.subscribersMu.RUnlock()
+
+	for _, sub := range subscribers {
+		sub.send(event)
+	}
+}
+
+// closeSubscriptions ends every subscription with the CloseEvent.
+func (db *Database) closeSubscriptions() {
+	db.subscribersMu.Lock()
+	db.closed = true
+	subscribers := db.subscribers
+	db.subscribers = make(map[*subscriber]struct{})
+	db.subscribersMu.Unlock()
+
+	for sub := range subscribers {
+		if sub.filter == nil || sub.filter(CloseEvent{}) {
+			sub.close(CloseEvent{})
+		} else {
+			sub.close(nil)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package databases_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/storage"
+)
+
+// setupSubscriptionDatabase creates a database that is closed when the test ends.
+func setupSubscriptionDatabase(t *testing.T) *databases.Database {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	host1, ps := setupLibp2pHostAndPubSub(t)
+
+	db, err := databases.NewDatabase("test-address", "test-db", identity, storage.NewMemoryStorage(), ks, host1, ps)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// onlyUpdates selects the update events.
+func onlyUpdates(event databases.Event) bool {
+	_, ok := event.(databases.UpdateEvent)
+	return ok
+}
+
+// addOperations adds the operations and returns the hashes of their entries.
+func addOperations(t *testing.T, db *databases.Database, count int) []string {
+	hashes := make([]string, count)
+	for i := range hashes {
+		hash, err := db.AddOperation(map[string]int{"i": i})
+		require.NoError(t, err)
+		hashes[i] = hash
+	}
+	return hashes
+}
+
+// receiveUpdates reads the buffered events and returns the hashes of the updates.
+func receiveUpdates(events <-chan databases.Event) []string {
+	var hashes []string
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return hashes
+			}
+			if update, ok := event.(databases.UpdateEvent); ok {
+				hashes = append(hashes, update.Entry.Hash)
+			}
+		default:
+			return hashes
+		}
+	}
+}
+
+func TestSubscribeMultipleSubscribers(t *testing.T) {
+	db := setupSubscriptionDatabase(t)
+
+	all := db.Subscribe(context.Background(), nil)
+	updates := db.Subscribe(context.Background(), onlyUpdates)
+
+	hashes := addOperations(t, db, 2)
+	require.NoError(t, db.Drop())
+
+	assert.Equal(t, hashes[0], (<-all).(databases.UpdateEvent).Entry.Hash)
+	assert.Equal(t, hashes[1], (<-all).(databases.UpdateEvent).Entry.Hash)
+	assert.Equal(t, databases.DropEvent{}, <-all)
+
+	// The filtered subscriber only receives the updates
+	assert.Equal(t, hashes, receiveUpdates(updates))
+	select {
+	case event := <-updates:
+		t.Fatalf("Unexpected event %#v", event)
+	default:
+	}
+}
+
+func TestSubscribeContextCanceled(t *testing.T) {
+	db := setupSubscriptionDatabase(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := db.Subscribe(ctx, nil)
+	cancel()
+
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-events:
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	// The database keeps working without the subscriber
+	addOperations(t, db, 1)
+}
+
+func TestSubscribeOverflowBlock(t *testing.T) {
+	db := setupSubscriptionDatabase(t)
+
+	events := db.Subscribe(context.Background(), nil,
+		databases.WithBufferSize(1), databases.WithOverflowPolicy(databases.OverflowBlock))
+
+	// The second operation waits until the first update has been read
+	added := make(chan []string)
+	go func() {
+		added <- addOperations(t, db, 2)
+	}()
+
+	select {
+	case <-added:
+		t.Fatal("Expected the database to wait for the subscriber")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	first := (<-events).(databases.UpdateEvent)
+	hashes := <-added
+	assert.Equal(t, hashes[0], first.Entry.Hash)
+	assert.Equal(t, hashes[1], (<-events).(databases.UpdateEvent).Entry.Hash)
+}
+
+func TestSubscribeOverflowBlockTimeout(t *testing.T) {
+	db := setupSubscriptionDatabase(t)
+
+	events := db.Subscribe(context.Background(), nil, databases.WithBufferSize(1),
+		databases.WithOverflowPolicy(databases.OverflowBlock), databases.WithBlockTimeout(50*time.Millisecond))
+
+	// A subscriber that doesn't read only holds up the database until the timeout
+	added := make(chan []string)
+	go func() {
+		added <- addOperations(t, db, 3)
+	}()
+
+	select {
+	case hashes := <-added:
+		assert.Equal(t, hashes[:1], receiveUpdates(events))
+	case <-time.After(time.Second):
+		t.Fatal("Expected the blocked subscriber to time out")
+	}
+
+	_, ok := <-events
+	assert.False(t, ok, "Expected the subscription to be ended")
+}
+
+func TestSubscribeDefaultDoesNotBlock(t *testing.T) {
+	db := setupSubscriptionDatabase(t)
+
+	events := db.Subscribe(context.Background(), nil, databases.WithBufferSize(2))
+
+	// Operations don't wait for a subscriber that doesn't read
+	hashes := addOperations(t, db, 5)
+	assert.Equal(t, hashes[3:], receiveUpdates(events))
+}
+
+func TestSubscribeCloseEventWithFullBuffer(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	host1, ps := setupLibp2pHostAndPubSub(t)
+	db, err := databases.NewDatabase("test-address", "test-db", identity, storage.NewMemoryStorage(), ks, host1, ps)
+	require.NoError(t, err)
+
+	events := db.Subscribe(context.Background(), nil, databases.WithBufferSize(2))
+	blocking := db.Subscribe(context.Background(), nil,
+		databases.WithBufferSize(2), databases.WithOverflowPolicy(databases.OverflowBlock))
+	addOperations(t, db, 2)
+	require.NoError(t, db.Close())
+
+	// The CloseEvent is not dropped from a full buffer
+	for i := 0; i < 2; i++ {
+		assert.IsType(t, databases.UpdateEvent{}, <-events)
+	}
+	event, ok := <-events
+	require.True(t, ok, "Expected the CloseEvent")
+	assert.Equal(t, databases.CloseEvent{}, event)
+	_, ok = <-events
+	assert.False(t, ok, "Expected the subscription to be ended")
+
+	// A blocking subscriber without room learns that the database closed from its closed channel
+	assert.Len(t, receiveUpdates(blocking), 2)
+	_, ok = <-blocking
+	assert.False(t, ok, "Expected the subscription to be ended")
+}
+
+func TestSubscribeOverflowDropOldest(t *testing.T) {
+	db := setupSubscriptionDatabase(t)
+
+	events := db.Subscribe(context.Background(), nil,
+		databases.WithBufferSize(2), databases.WithOverflowPolicy(databases.OverflowDropOldest))
+
+	hashes := addOperations(t, db, 5)
+	assert.Equal(t, hashes[3:], receiveUpdates(events))
+}
+
+func TestSubscribeOverflowError(t *testing.T) {
+	db := setupSubscriptionDatabase(t)
+
+	events := db.Subscribe(context.Background(), nil,
+		databases.WithBufferSize(2), databases.WithOverflowPolicy(databases.OverflowError))
+
+	hashes := addOperations(t, db, 3)
+	assert.Equal(t, hashes[0], (<-events).(databases.UpdateEvent).Entry.Hash)
+	assert.Equal(t, hashes[1], (<-events).(databases.UpdateEvent).Entry.Hash)
+
+	event := <-events
+	errEvent, ok := event.(databases.ErrorEvent)
+	require.True(t, ok, "Expected an error event, got %#v", event)
+	assert.ErrorIs(t, errEvent.Err, databases.ErrSubscriptionOverflow)
+
+	_, ok = <-events
+	assert.False(t, ok, "Expected the subscription to be ended")
+}
@@ -2,25 +2,66 @@ package identities
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"encoding/hex"
 	"errors"
-	"math/big"
+	"fmt"
+	lru "github.com/hashicorp/golang-lru"
 	"orbitdb/go-orbitdb/identities/identitytypes"
 	"orbitdb/go-orbitdb/identities/providers"
 	"orbitdb/go-orbitdb/keystore"
 	"orbitdb/go-orbitdb/storage"
+	"path/filepath"
 )
 
+// identityCacheSize is the number of identities kept in memory in front of the identity
+// storage, and the number of verified identities remembered.
+const identityCacheSize = 1000
+
+// IdentityNotFoundError is returned when no identity is stored under a hash.
+type IdentityNotFoundError struct {
+	Hash string
+	Err  error // Error returned by the identity storage
+}
+
+func (e *IdentityNotFoundError) Error() string {
+	return fmt.Sprintf("identity %s not found", e.Hash)
+}
+
+func (e *IdentityNotFoundError) Unwrap() error {
+	return e.Err
+}
+
 // Identities manages a collection of identities
 type Identities struct {
-	storage  map[string]*identitytypes.Identity
-	provider Provider
-	keystore *keystore.KeyStore
+	storage     storage.Storage // Encoded identities by hash
+	ownsStorage bool            // Whether the identity storage was created by NewIdentities
+	verified    *lru.Cache      // Identities that passed verification, by hash
+	provider    Provider
+	keystore    *keystore.KeyStore
+	directory   string
+}
+
+// Option configures optional settings of Identities.
+type Option func(*Identities)
+
+// WithIdentityStorage sets the storage encoded identities are persisted in and resolved
+// from, e.g. an IPFSBlockStorage to resolve the identities of other peers.
+func WithIdentityStorage(identityStorage storage.Storage) Option {
+	return func(ids *Identities) {
+		ids.storage = identityStorage
+	}
+}
+
+// WithDirectory persists identities in an LRU cached LevelDB storage in the given
+// directory, unless an identity storage is set.
+func WithDirectory(directory string) Option {
+	return func(ids *Identities) {
+		ids.directory = directory
+	}
 }
 
 // NewIdentities initializes the identities manager with a specific provider and a KeyStore.
-func NewIdentities(providerType string, storageBackend storage.Storage) (*Identities, error) {
+// Identities are kept in memory unless an identity storage or a directory is given.
+func NewIdentities(providerType string, storageBackend storage.Storage, options ...Option) (*Identities, error) {
 	// Initialize a KeyStore instance
 	ks := keystore.NewKeyStore(storageBackend)
 
@@ -33,11 +74,56 @@ func NewIdentities(providerType string, storageBackend storage.Storage) (*Identi
 		return nil, errors.New("unsupported provider type")
 	}
 
-	return &Identities{
-		storage:  make(map[string]*identitytypes.Identity),
+	verified, err := lru.New(identityCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create verified identity cache: %w", err)
+	}
+
+	ids := &Identities{
+		verified: verified,
 		provider: provider,
 		keystore: ks,
-	}, nil
+	}
+
+	for _, option := range options {
+		option(ids)
+	}
+
+	if ids.storage == nil {
+		identityStorage, err := newDefaultIdentityStorage(ids.directory)
+		if err != nil {
+			return nil, err
+		}
+		ids.storage = identityStorage
+		ids.ownsStorage = true
+	}
+
+	return ids, nil
+}
+
+// newDefaultIdentityStorage creates an LRU cached LevelDB storage in the directory, or an
+// LRU cached memory storage when there is no directory.
+func newDefaultIdentityStorage(directory string) (storage.Storage, error) {
+	lruStorage, err := storage.NewLRUStorage(identityCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity cache: %w", err)
+	}
+
+	if directory == "" {
+		return storage.NewComposedStorage(lruStorage, storage.NewMemoryStorage())
+	}
+
+	levelStorage, err := storage.NewLevelStorage(filepath.Join(directory, "identities"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity storage: %w", err)
+	}
+
+	return storage.NewComposedStorage(lruStorage, levelStorage)
+}
+
+// KeyStore returns the KeyStore holding the keys of these identities.
+func (ids *Identities) KeyStore() *keystore.KeyStore {
+	return ids.keystore
 }
 
 // ClearAll clears all keys from the KeyStore
@@ -61,21 +147,100 @@ func (ids *Identities) CreateIdentity(id string) (*identitytypes.Identity, error
 		return nil, errors.New("invalid identity created")
 	}
 
-	// Store the identity in the storage map
-	ids.storage[identity.Hash] = identity
+	// Persist the encoded identity so that it can be resolved by its hash
+	if err := ids.storage.Put(identity.Hash, identity.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to store identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+// GetIdentity loads the identity with the given hash from the identity storage. It
+// returns an *IdentityNotFoundError if no identity is stored under the hash, and other
+// errors of the storage as they are.
+func (ids *Identities) GetIdentity(hash string) (*identitytypes.Identity, error) {
+	data, err := ids.storage.Get(hash)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, &IdentityNotFoundError{Hash: hash, Err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity %s: %w", hash, err)
+	}
+
+	identity, err := identitytypes.DecodeIdentity(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode identity %s: %w", hash, err)
+	}
+	if identity.Hash != hash {
+		return nil, fmt.Errorf("identity hash mismatch: expected %s, got %s", hash, identity.Hash)
+	}
+
 	return identity, nil
 }
 
-func (ids *Identities) GetIdentity(identityID string) (*identitytypes.Identity, error) {
-	return ids.storage[identityID], nil
+// AddIdentity stores an encoded identity received from another peer, so that it can be
+// resolved by its hash to verify the entries it signed. Only valid identities are stored.
+func (ids *Identities) AddIdentity(data []byte) (*identitytypes.Identity, error) {
+	identity, err := identitytypes.DecodeIdentity(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode identity: %w", err)
+	}
+	if !ids.VerifyIdentity(identity) {
+		return nil, fmt.Errorf("identity %s is not valid", identity.Hash)
+	}
+
+	if err := ids.storage.Put(identity.Hash, identity.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to store identity: %w", err)
+	}
+
+	return identity, nil
 }
 
-// VerifyIdentity verifies the provided identity.
+// VerifyIdentity verifies the provided identity. Identities that have been verified before
+// are not verified again.
 func (ids *Identities) VerifyIdentity(identity *identitytypes.Identity) bool {
+	if identity == nil {
+		return false
+	}
+
+	if cached, ok := ids.verified.Get(identity.Hash); ok && sameIdentity(cached.(*identitytypes.Identity), identity) {
+		return true
+	}
+
 	verified, _ := ids.provider.VerifyIdentity(identity)
+	if verified {
+		// Keep a copy so that later changes to the identity are not mistaken for verified
+		verifiedCopy := *identity
+		verifiedCopy.Signatures = make(map[string]string, len(identity.Signatures))
+		for k, v := range identity.Signatures {
+			verifiedCopy.Signatures[k] = v
+		}
+		ids.verified.Add(identity.Hash, &verifiedCopy)
+	}
 	return verified
 }
 
+// sameIdentity reports whether two identities have the same signed fields.
+func sameIdentity(a, b *identitytypes.Identity) bool {
+	if a.ID != b.ID || a.PublicKey != b.PublicKey || a.Type != b.Type || len(a.Signatures) != len(b.Signatures) {
+		return false
+	}
+	for k, v := range a.Signatures {
+		if b.Signatures[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes the identity storage if it was created by NewIdentities.
+func (ids *Identities) Close() error {
+	if !ids.ownsStorage {
+		return nil
+	}
+	return ids.storage.Close()
+}
+
 // Sign signs the provided data using the identity's private key from the KeyStore.
 func (ids *Identities) Sign(id string, data []byte) (string, error) {
 	// Use KeyStore to sign the data
@@ -85,20 +250,13 @@ func (ids *Identities) Sign(id string, data []byte) (string, error) {
 // Verify verifies the provided signature against the data and public key.
 func (ids *Identities) Verify(signature string, identity *identitytypes.Identity, data []byte) bool {
 	// Decode the public key from the identity's hex-encoded string
-	publicKeyBytes, err := hex.DecodeString(identity.PublicKey)
-	if err != nil || len(publicKeyBytes) < 64 {
+	pubKey, err := keystore.ReconstructPublicKeyFromHex(identity.PublicKey)
+	if err != nil {
 		return false
 	}
 
-	// Reconstruct the ecdsa.PublicKey from the byte slice
-	pubKey := ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     new(big.Int).SetBytes(publicKeyBytes[:len(publicKeyBytes)/2]),
-		Y:     new(big.Int).SetBytes(publicKeyBytes[len(publicKeyBytes)/2:]),
-	}
-
 	// Use VerifyMessage from KeyStore to verify the signature
-	verified, err := ids.keystore.VerifyMessage(pubKey, data, signature)
+	verified, err := ids.keystore.VerifyMessage(*pubKey, data, signature)
 	return err == nil && verified
 }
 
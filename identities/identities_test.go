@@ -1,6 +1,8 @@
 package identities
 
 import (
+	"errors"
+	"orbitdb/go-orbitdb/identities/identitytypes"
 	"orbitdb/go-orbitdb/storage"
 	"testing"
 )
@@ -51,9 +53,197 @@ func TestCreateIdentity(t *testing.T) {
 		t.Fatal("Expected identity hash to be populated")
 	}
 
-	// Verify the identity is stored in the map
-	if _, exists := identities.storage[identity.Hash]; !exists {
-		t.Fatal("Expected identity to be stored in Identities map")
+	// Verify the identity is persisted by its hash
+	if _, err := identities.storage.Get(identity.Hash); err != nil {
+		t.Fatalf("Expected identity to be persisted in the identity storage: %v", err)
+	}
+}
+
+func TestGetIdentityFromStorage(t *testing.T) {
+	identityStorage := storage.NewMemoryStorage()
+
+	creator, err := NewIdentities("publickey", storage.NewMemoryStorage(), WithIdentityStorage(identityStorage))
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+
+	identity, err := creator.CreateIdentity("test-id")
+	if err != nil {
+		t.Fatalf("Error creating identity: %v", err)
+	}
+
+	// Another instance without the keys resolves the identity from the shared storage
+	resolver, err := NewIdentities("publickey", storage.NewMemoryStorage(), WithIdentityStorage(identityStorage))
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+
+	resolved, err := resolver.GetIdentity(identity.Hash)
+	if err != nil {
+		t.Fatalf("Expected no error resolving identity, got %v", err)
+	}
+	if resolved == nil || resolved.ID != identity.ID || resolved.PublicKey != identity.PublicKey {
+		t.Fatalf("Expected the stored identity, got %+v", resolved)
+	}
+	if !resolver.VerifyIdentity(resolved) {
+		t.Fatal("Expected the resolved identity to be valid")
+	}
+
+	var notFound *IdentityNotFoundError
+	if _, err := resolver.GetIdentity("zdpuUnknown"); !errors.As(err, &notFound) {
+		t.Fatalf("Expected an IdentityNotFoundError for an unknown identity, got %v", err)
+	}
+}
+
+// failingStorage is a storage whose reads fail.
+type failingStorage struct {
+	storage.Storage
+}
+
+var errStorageFailed = errors.New("storage failed")
+
+func (failingStorage) Get(string) ([]byte, error) {
+	return nil, errStorageFailed
+}
+
+func TestGetIdentityStorageError(t *testing.T) {
+	ids, err := NewIdentities("publickey", storage.NewMemoryStorage(),
+		WithIdentityStorage(failingStorage{Storage: storage.NewMemoryStorage()}))
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+
+	// A failing storage is not mistaken for a missing identity
+	_, err = ids.GetIdentity("zdpuUnknown")
+	var notFound *IdentityNotFoundError
+	if errors.As(err, &notFound) || !errors.Is(err, errStorageFailed) {
+		t.Fatalf("Expected the storage error, got %v", err)
+	}
+}
+
+func TestAddIdentity(t *testing.T) {
+	creator, err := setupIdentities(storage.NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+	identity, err := creator.CreateIdentity("test-id")
+	if err != nil {
+		t.Fatalf("Error creating identity: %v", err)
+	}
+
+	// An independent instance stores the identity received from the creator
+	receiver, err := setupIdentities(storage.NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+	if _, err := receiver.AddIdentity(identity.Bytes); err != nil {
+		t.Fatalf("Expected no error adding identity, got %v", err)
+	}
+	resolved, err := receiver.GetIdentity(identity.Hash)
+	if err != nil {
+		t.Fatalf("Expected the added identity to be resolved, got %v", err)
+	}
+	if resolved.PublicKey != identity.PublicKey {
+		t.Fatalf("Expected the added identity, got %+v", resolved)
+	}
+
+	// Identities with invalid signatures are not stored
+	forged := *identity
+	forged.ID = "forged-id"
+	_, forged.Bytes, _ = identitytypes.EncodeIdentity(forged)
+	if _, err := receiver.AddIdentity(forged.Bytes); err == nil {
+		t.Fatal("Expected an identity with an invalid signature to be rejected")
+	}
+	if _, err := receiver.AddIdentity([]byte("not an identity")); err == nil {
+		t.Fatal("Expected invalid data to be rejected")
+	}
+}
+
+func TestIdentitiesPersistInDirectory(t *testing.T) {
+	directory := t.TempDir()
+
+	identities, err := NewIdentities("publickey", storage.NewMemoryStorage(), WithDirectory(directory))
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+
+	identity, err := identities.CreateIdentity("test-id")
+	if err != nil {
+		t.Fatalf("Error creating identity: %v", err)
+	}
+	if err := identities.Close(); err != nil {
+		t.Fatalf("Error closing identities: %v", err)
+	}
+
+	reopened, err := NewIdentities("publickey", storage.NewMemoryStorage(), WithDirectory(directory))
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+	defer reopened.Close()
+
+	resolved, err := reopened.GetIdentity(identity.Hash)
+	if err != nil {
+		t.Fatalf("Expected the identity to be loaded after a restart, got %v", err)
+	}
+	if resolved.ID != identity.ID || resolved.PublicKey != identity.PublicKey {
+		t.Fatalf("Expected the stored identity, got %+v", resolved)
+	}
+}
+
+func TestVerifyIdentityCache(t *testing.T) {
+	identities, err := setupIdentities(storage.NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+
+	identity, err := identities.CreateIdentity("test-id")
+	if err != nil {
+		t.Fatalf("Error creating identity: %v", err)
+	}
+
+	if !identities.VerifyIdentity(identity) {
+		t.Fatal("Expected VerifyIdentity to return true for a valid identity")
+	}
+	if _, cached := identities.verified.Get(identity.Hash); !cached {
+		t.Fatal("Expected the verified identity to be cached")
+	}
+
+	// A resolved copy of the same identity is verified from the cache
+	resolved, err := identities.GetIdentity(identity.Hash)
+	if err != nil {
+		t.Fatalf("Error resolving identity: %v", err)
+	}
+	if !identities.VerifyIdentity(resolved) {
+		t.Fatal("Expected the resolved identity to be verified")
+	}
+
+	// Changing a signature under the same hash is not covered by the cache
+	resolved.Signatures["id"] = identity.Signatures["publicKey"]
+	if identities.VerifyIdentity(resolved) {
+		t.Fatal("Expected an identity with a changed signature to be rejected")
+	}
+}
+
+func TestGetIdentityHashMismatch(t *testing.T) {
+	identityStorage := storage.NewMemoryStorage()
+
+	identities, err := NewIdentities("publickey", storage.NewMemoryStorage(), WithIdentityStorage(identityStorage))
+	if err != nil {
+		t.Fatalf("Error initializing identities: %v", err)
+	}
+
+	identity, err := identities.CreateIdentity("test-id")
+	if err != nil {
+		t.Fatalf("Error creating identity: %v", err)
+	}
+
+	// Store the identity under a hash it does not have
+	if err := identityStorage.Put("zdpuWrongHash", identity.Bytes); err != nil {
+		t.Fatalf("Error storing identity: %v", err)
+	}
+
+	if _, err := identities.GetIdentity("zdpuWrongHash"); err == nil {
+		t.Fatal("Expected an error for an identity stored under another hash")
 	}
 }
 
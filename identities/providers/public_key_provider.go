@@ -1,11 +1,8 @@
 package providers
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"encoding/hex"
 	"errors"
-	"math/big"
 	"orbitdb/go-orbitdb/identities/identitytypes"
 	"orbitdb/go-orbitdb/keystore"
 )
@@ -40,8 +37,11 @@ func (p *PublicKeyProvider) CreateIdentity(id string) (*identitytypes.Identity,
 		return nil, err
 	}
 
-	// Generate the public key as a hex-encoded string
-	publicKey := hex.EncodeToString(append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...))
+	// Generate the public key as a hex-encoded string of the fixed-width X and Y coordinates
+	publicKeyBytes := make([]byte, 64)
+	privateKey.PublicKey.X.FillBytes(publicKeyBytes[:32])
+	privateKey.PublicKey.Y.FillBytes(publicKeyBytes[32:])
+	publicKey := hex.EncodeToString(publicKeyBytes)
 
 	// Sign the ID and public key
 	idSignature, err := p.keystore.SignMessage(id, []byte(id))
@@ -85,26 +85,19 @@ func (p *PublicKeyProvider) VerifyIdentity(identity *identitytypes.Identity) (bo
 	}
 
 	// Decode the public key from the hex-encoded string
-	publicKeyBytes, err := hex.DecodeString(identity.PublicKey)
-	if err != nil || len(publicKeyBytes) < 64 {
+	pubKey, err := keystore.ReconstructPublicKeyFromHex(identity.PublicKey)
+	if err != nil {
 		return false, errors.New("invalid public key encoding")
 	}
 
-	// Reconstruct the ecdsa.PublicKey
-	pubKey := ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     new(big.Int).SetBytes(publicKeyBytes[:len(publicKeyBytes)/2]),
-		Y:     new(big.Int).SetBytes(publicKeyBytes[len(publicKeyBytes)/2:]),
-	}
-
 	// Verify the ID signature using the KeyStore's VerifyMessage method
-	idVerified, err := p.keystore.VerifyMessage(pubKey, []byte(identity.ID), identity.Signatures["id"])
+	idVerified, err := p.keystore.VerifyMessage(*pubKey, []byte(identity.ID), identity.Signatures["id"])
 	if err != nil || !idVerified {
 		return false, errors.New("invalid ID signature")
 	}
 
 	// Verify the public key signature using the KeyStore's VerifyMessage method
-	publicKeyVerified, err := p.keystore.VerifyMessage(pubKey, []byte(identity.PublicKey), identity.Signatures["publicKey"])
+	publicKeyVerified, err := p.keystore.VerifyMessage(*pubKey, []byte(identity.PublicKey), identity.Signatures["publicKey"])
 	if err != nil || !publicKeyVerified {
 		return false, errors.New("invalid public key signature")
 	}
@@ -1,6 +1,7 @@
 package keystore
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -14,7 +15,8 @@ import (
 	"sync"
 )
 
-// KeyStore provides a key management system backed by a Storage interface.
+// KeyStore provides a key management system backed by a Storage interface. The methods
+// ending in Context pass the context on to the storage, see storage.ContextStorage.
 type KeyStore struct {
 	storage storage.Storage
 	mu      sync.Mutex
@@ -37,10 +39,19 @@ func NewKeyStore(storage storage.Storage) *KeyStore {
 
 // CreateKey generates a new ECDSA key pair and stores it under the given ID.
 func (ks *KeyStore) CreateKey(id string) (*ecdsa.PrivateKey, error) {
+	return ks.CreateKeyContext(context.Background(), id)
+}
+
+// CreateKeyContext generates a new ECDSA key pair and stores it under the given ID.
+func (ks *KeyStore) CreateKeyContext(ctx context.Context, id string) (*ecdsa.PrivateKey, error) {
 	ks.mu.Lock()
 	defer ks.mu.Unlock()
 
-	if ks.HasKey(id) {
+	exists, err := ks.HasKeyContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
 		return nil, errors.New("key already exists for this ID")
 	}
 
@@ -56,7 +67,7 @@ func (ks *KeyStore) CreateKey(id string) (*ecdsa.PrivateKey, error) {
 	}
 
 	// Store the serialized private key
-	err = ks.storage.Put("private_"+id, privateKeyBytes)
+	err = storage.WithContext(ks.storage).PutContext(ctx, "private_"+id, privateKeyBytes)
 	if err != nil {
 		return nil, err
 	}
@@ -66,16 +77,38 @@ func (ks *KeyStore) CreateKey(id string) (*ecdsa.PrivateKey, error) {
 
 // HasKey checks if a key exists for a given ID.
 func (ks *KeyStore) HasKey(id string) bool {
-	_, err := ks.storage.Get("private_" + id)
-	return err == nil
+	exists, _ := ks.HasKeyContext(context.Background(), id)
+	return exists
+}
+
+// HasKeyContext checks if a key exists for a given ID. Unlike HasKey, it tells a missing
+// key apart from a storage that failed, e.g. because ctx is done.
+func (ks *KeyStore) HasKeyContext(ctx context.Context, id string) (bool, error) {
+	_, err := storage.WithContext(ks.storage).GetContext(ctx, "private_"+id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up key %s: %w", id, err)
+	}
+	return true, nil
 }
 
 // AddKey adds a private key to the keystore (e.g., for imported keys).
 func (ks *KeyStore) AddKey(id string, privateKey *ecdsa.PrivateKey) error {
+	return ks.AddKeyContext(context.Background(), id, privateKey)
+}
+
+// AddKeyContext adds a private key to the keystore (e.g., for imported keys).
+func (ks *KeyStore) AddKeyContext(ctx context.Context, id string, privateKey *ecdsa.PrivateKey) error {
 	ks.mu.Lock()
 	defer ks.mu.Unlock()
 
-	if ks.HasKey(id) {
+	exists, err := ks.HasKeyContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
 		return errors.New("key already exists for this ID")
 	}
 
@@ -86,25 +119,36 @@ func (ks *KeyStore) AddKey(id string, privateKey *ecdsa.PrivateKey) error {
 	}
 
 	// Store the serialized private key
-	return ks.storage.Put("private_"+id, privateKeyBytes)
+	return storage.WithContext(ks.storage).PutContext(ctx, "private_"+id, privateKeyBytes)
 }
 
 // Clear removes all keys from the KeyStore.
 func (ks *KeyStore) Clear() error {
+	return ks.ClearContext(context.Background())
+}
+
+// ClearContext removes all keys from the KeyStore.
+func (ks *KeyStore) ClearContext(ctx context.Context) error {
 	ks.mu.Lock()
 	defer ks.mu.Unlock()
 
-	return ks.storage.Clear()
+	return storage.WithContext(ks.storage).ClearContext(ctx)
 }
 
 // GetKey retrieves a private key by ID from storage.
 func (ks *KeyStore) GetKey(id string) (*ecdsa.PrivateKey, error) {
+	return ks.GetKeyContext(context.Background(), id)
+}
+
+// GetKeyContext retrieves a private key by ID from storage. Returns an error wrapping
+// storage.ErrNotFound if there is no key for the ID.
+func (ks *KeyStore) GetKeyContext(ctx context.Context, id string) (*ecdsa.PrivateKey, error) {
 	ks.mu.Lock()
 	defer ks.mu.Unlock()
 
-	privateKeyBytes, err := ks.storage.Get("private_" + id)
+	privateKeyBytes, err := storage.WithContext(ks.storage).GetContext(ctx, "private_"+id)
 	if err != nil {
-		return nil, errors.New("key not found")
+		return nil, fmt.Errorf("failed to get key %s: %w", id, err)
 	}
 
 	// Deserialize the private key
@@ -124,19 +168,29 @@ func (ks *KeyStore) SignMessage(id string, data []byte) (string, error) {
 		return "", err
 	}
 
-	signature := append(r.Bytes(), s.Bytes()...)
+	// Encode r and s at a fixed width so the signature can be split in half when verifying
+	size := (privateKey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
 	return hex.EncodeToString(signature), nil
 }
 
 // VerifyMessage verifies the signature against the data using the public key.
 func (ks *KeyStore) VerifyMessage(publicKey ecdsa.PublicKey, data []byte, signatureHex string) (bool, error) {
 	sigBytes, err := hex.DecodeString(signatureHex)
-	if err != nil || len(sigBytes) < 64 {
+	if err != nil {
 		return false, err
 	}
 
-	r := new(big.Int).SetBytes(sigBytes[:len(sigBytes)/2])
-	s := new(big.Int).SetBytes(sigBytes[len(sigBytes)/2:])
+	// r and s are encoded at a fixed width, see SignMessage
+	size := (publicKey.Curve.Params().BitSize + 7) / 8
+	if len(sigBytes) != 2*size {
+		return false, nil
+	}
+
+	r := new(big.Int).SetBytes(sigBytes[:size])
+	s := new(big.Int).SetBytes(sigBytes[size:])
 
 	hash := sha256.Sum256(data)
 	return ecdsa.Verify(&publicKey, hash[:], r, s), nil
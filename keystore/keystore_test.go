@@ -1,10 +1,14 @@
 package keystore
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"orbitdb/go-orbitdb/storage"
 	"testing"
 )
@@ -127,8 +131,39 @@ func TestGetKey(t *testing.T) {
 
 	// Attempt to retrieve a non-existent key
 	_, err = ks.GetKey("nonexistent-id")
-	if err == nil {
-		t.Fatal("Expected error for non-existent key, got nil")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for non-existent key, got %v", err)
+	}
+}
+
+func TestKeyStoreContext(t *testing.T) {
+	ks := newTestKeyStore(t)
+	id := "test-id"
+
+	if _, err := ks.CreateKeyContext(context.Background(), id); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	exists, err := ks.HasKeyContext(context.Background(), "nonexistent-id")
+	if err != nil || exists {
+		t.Fatalf("Expected a missing key without error, got %v, %v", exists, err)
+	}
+
+	// Nothing is read or written once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ks.GetKeyContext(ctx, id); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := ks.HasKeyContext(ctx, id); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := ks.CreateKeyContext(ctx, "other-id"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if ks.HasKey("other-id") {
+		t.Fatal("Expected no key to be created with a canceled context")
 	}
 }
 
@@ -302,3 +337,75 @@ func TestSerializeAndDeserializePrivateKey(t *testing.T) {
 		t.Error("Private scalar D mismatch after deserialization")
 	}
 }
+
+// shortSignature signs the data without padding r and s, retrying until r or s has a leading
+// zero byte so that the signature is shorter than the fixed width.
+func shortSignature(t *testing.T, privateKey *ecdsa.PrivateKey, data []byte) string {
+	hash := sha256.Sum256(data)
+	for {
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		if len(r.Bytes()) < 32 || len(s.Bytes()) < 32 {
+			return hex.EncodeToString(append(r.Bytes(), s.Bytes()...))
+		}
+	}
+}
+
+func TestVerifyMessageFixedWidth(t *testing.T) {
+	ks := newTestKeyStore(t)
+	privateKey, err := ks.CreateKey("fixed")
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	data := []byte("fixed data")
+
+	// Signatures are always twice the coordinate size
+	for i := 0; i < 50; i++ {
+		signature, err := ks.SignMessage("fixed", data)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		if len(signature) != 128 {
+			t.Fatalf("Expected a fixed-width signature, got %d hex characters", len(signature))
+		}
+	}
+
+	// A signature that is not at the fixed width is rejected rather than split
+	signature := shortSignature(t, privateKey, data)
+	valid, err := ks.VerifyMessage(privateKey.PublicKey, data, signature)
+	if err != nil || valid {
+		t.Fatalf("Expected a short signature not to verify, got %v, %v", valid, err)
+	}
+}
+
+func TestReconstructPublicKeyFromHex(t *testing.T) {
+	// Generate a key with a coordinate that has a leading zero byte
+	var privateKey *ecdsa.PrivateKey
+	for privateKey == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		if len(key.X.Bytes()) < 32 || len(key.Y.Bytes()) < 32 {
+			privateKey = key
+		}
+	}
+
+	padded := make([]byte, 64)
+	privateKey.X.FillBytes(padded[:32])
+	privateKey.Y.FillBytes(padded[32:])
+	publicKey, err := ReconstructPublicKeyFromHex(hex.EncodeToString(padded))
+	if err != nil {
+		t.Fatalf("Failed to reconstruct public key: %v", err)
+	}
+	if !publicKey.Equal(&privateKey.PublicKey) {
+		t.Error("Reconstructed public key does not match")
+	}
+
+	short := append(privateKey.X.Bytes(), privateKey.Y.Bytes()...)
+	if _, err := ReconstructPublicKeyFromHex(hex.EncodeToString(short)); err == nil {
+		t.Error("Expected an error for a public key that is not at the fixed width")
+	}
+}
@@ -0,0 +1,357 @@
+package orbitdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"math"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+	"reflect"
+	"sort"
+)
+
+// Manifest describes a database: its name, type and access controller.
+// The hash of the encoded manifest is the database's address.
+type Manifest struct {
+	Name             string
+	Type             string
+	AccessController string
+	Meta             map[string]interface{}
+}
+
+// EncodedManifest represents a Manifest that has been encoded.
+type EncodedManifest struct {
+	Manifest
+	Bytes []byte
+	CID   cid.Cid
+	Hash  string
+}
+
+// ManifestStore stores and retrieves database manifests in block storage.
+type ManifestStore struct {
+	storage storage.Storage
+}
+
+// NewManifestStore creates a ManifestStore on top of the given storage.
+func NewManifestStore(manifestStorage storage.Storage) (*ManifestStore, error) {
+	if manifestStorage == nil {
+		return nil, errors.New("manifest storage is required")
+	}
+	return &ManifestStore{storage: manifestStorage}, nil
+}
+
+// Create encodes and stores a new manifest, returning the encoded manifest.
+func (ms *ManifestStore) Create(name, dbType, accessController string, meta map[string]interface{}) (*EncodedManifest, error) {
+	if name == "" {
+		return nil, errors.New("database name is required")
+	}
+	if dbType == "" {
+		return nil, errors.New("database type is required")
+	}
+
+	encoded, err := EncodeManifest(Manifest{
+		Name:             name,
+		Type:             dbType,
+		AccessController: accessController,
+		Meta:             meta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ms.storage.Put(encoded.Hash, encoded.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to store manifest: %w", err)
+	}
+
+	return &encoded, nil
+}
+
+// Get retrieves and decodes the manifest with the given hash.
+func (ms *ManifestStore) Get(hash string) (*EncodedManifest, error) {
+	data, err := ms.storage.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest %s: %w", hash, err)
+	}
+
+	encoded, err := DecodeManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %w", hash, err)
+	}
+
+	if encoded.Hash != hash {
+		return nil, fmt.Errorf("manifest hash mismatch: expected %s, got %s", hash, encoded.Hash)
+	}
+
+	return encoded, nil
+}
+
+// Close closes the underlying storage.
+func (ms *ManifestStore) Close() error {
+	return ms.storage.Close()
+}
+
+// EncodeManifest encodes the manifest into CBOR and calculates its CID.
+func EncodeManifest(manifest Manifest) (EncodedManifest, error) {
+	size := int64(3)
+	if len(manifest.Meta) > 0 {
+		size++
+	}
+
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(size)
+	if err != nil {
+		return EncodedManifest{}, err
+	}
+
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"name", manifest.Name},
+		{"type", manifest.Type},
+		{"accessController", manifest.AccessController},
+	}
+	for _, field := range fields {
+		if err := ma.AssembleKey().AssignString(field.key); err != nil {
+			return EncodedManifest{}, err
+		}
+		if err := ma.AssembleValue().AssignString(field.value); err != nil {
+			return EncodedManifest{}, err
+		}
+	}
+
+	// Meta is optional and omitted when empty
+	if len(manifest.Meta) > 0 {
+		if err := ma.AssembleKey().AssignString("meta"); err != nil {
+			return EncodedManifest{}, err
+		}
+		if err := assembleValue(ma.AssembleValue(), manifest.Meta); err != nil {
+			return EncodedManifest{}, fmt.Errorf("failed to encode manifest meta: %w", err)
+		}
+	}
+
+	if err := ma.Finish(); err != nil {
+		return EncodedManifest{}, err
+	}
+
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(nb.Build(), &buf); err != nil {
+		return EncodedManifest{}, err
+	}
+
+	c, hash, err := oplog.ComputeCID(buf.Bytes())
+	if err != nil {
+		return EncodedManifest{}, err
+	}
+
+	return EncodedManifest{Manifest: manifest, Bytes: buf.Bytes(), CID: c, Hash: hash}, nil
+}
+
+// DecodeManifest decodes CBOR-encoded data into an EncodedManifest.
+func DecodeManifest(data []byte) (*EncodedManifest, error) {
+	if len(data) == 0 {
+		return nil, errors.New("invalid or empty manifest data")
+	}
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	node := nb.Build()
+
+	var manifest Manifest
+	var err error
+	if manifest.Name, err = lookupString(node, "name"); err != nil {
+		return nil, err
+	}
+	if manifest.Type, err = lookupString(node, "type"); err != nil {
+		return nil, err
+	}
+	if manifest.AccessController, err = lookupString(node, "accessController"); err != nil {
+		return nil, err
+	}
+
+	if metaNode, err := node.LookupByString("meta"); err == nil {
+		meta, err := nodeToValue(metaNode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'meta' field: %w", err)
+		}
+		metaMap, ok := meta.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid 'meta' field: expected a map")
+		}
+		manifest.Meta = metaMap
+	}
+
+	c, hash, err := oplog.ComputeCID(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncodedManifest{Manifest: manifest, Bytes: data, CID: c, Hash: hash}, nil
+}
+
+func lookupString(node datamodel.Node, key string) (string, error) {
+	child, err := node.LookupByString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid or missing '%s' field", key)
+	}
+	value, err := child.AsString()
+	if err != nil {
+		return "", fmt.Errorf("invalid or missing '%s' field", key)
+	}
+	return value, nil
+}
+
+// assembleValue assigns a JSON-like Go value to an IPLD node assembler. Other numeric types,
+// slices and maps with string keys are converted with assembleConverted.
+func assembleValue(na datamodel.NodeAssembler, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return na.AssignNull()
+	case string:
+		return na.AssignString(v)
+	case bool:
+		return na.AssignBool(v)
+	case int:
+		return na.AssignInt(int64(v))
+	case int64:
+		return na.AssignInt(v)
+	case float64:
+		return na.AssignFloat(v)
+	case []byte:
+		return na.AssignBytes(v)
+	case []interface{}:
+		la, err := na.BeginList(int64(len(v)))
+		if err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := assembleValue(la.AssembleValue(), item); err != nil {
+				return err
+			}
+		}
+		return la.Finish()
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		ma, err := na.BeginMap(int64(len(v)))
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := ma.AssembleKey().AssignString(k); err != nil {
+				return err
+			}
+			if err := assembleValue(ma.AssembleValue(), v[k]); err != nil {
+				return err
+			}
+		}
+		return ma.Finish()
+	default:
+		return assembleConverted(na, reflect.ValueOf(value))
+	}
+}
+
+// assembleConverted assigns a value that is not one of the types assembleValue handles
+// directly, such as an int32, a uint, a float32, a []string or a map[string]string, by its
+// kind. Unsigned integers that don't fit in an int64 are rejected.
+func assembleConverted(na datamodel.NodeAssembler, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		return na.AssignString(v.String())
+	case reflect.Bool:
+		return na.AssignBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return na.AssignInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return fmt.Errorf("integer %d is out of range", v.Uint())
+		}
+		return na.AssignInt(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return na.AssignFloat(v.Float())
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = v.Index(i).Interface()
+		}
+		return assembleValue(na, items)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		entries := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[iter.Key().String()] = iter.Value().Interface()
+		}
+		return assembleValue(na, entries)
+	default:
+		return fmt.Errorf("unsupported value type %s", v.Type())
+	}
+}
+
+// nodeToValue converts an IPLD node back into a JSON-like Go value.
+func nodeToValue(node datamodel.Node) (interface{}, error) {
+	switch node.Kind() {
+	case datamodel.Kind_Null:
+		return nil, nil
+	case datamodel.Kind_String:
+		return node.AsString()
+	case datamodel.Kind_Bool:
+		return node.AsBool()
+	case datamodel.Kind_Int:
+		v, err := node.AsInt()
+		return int(v), err
+	case datamodel.Kind_Float:
+		return node.AsFloat()
+	case datamodel.Kind_Bytes:
+		return node.AsBytes()
+	case datamodel.Kind_List:
+		list := make([]interface{}, 0, node.Length())
+		iter := node.ListIterator()
+		for !iter.Done() {
+			_, item, err := iter.Next()
+			if err != nil {
+				return nil, err
+			}
+			value, err := nodeToValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case datamodel.Kind_Map:
+		m := make(map[string]interface{}, node.Length())
+		iter := node.MapIterator()
+		for !iter.Done() {
+			keyNode, valueNode, err := iter.Next()
+			if err != nil {
+				return nil, err
+			}
+			key, err := keyNode.AsString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := nodeToValue(valueNode)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported node kind %s", node.Kind())
+	}
+}
@@ -0,0 +1,97 @@
+package orbitdb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	orbitdb "orbitdb/go-orbitdb"
+	"orbitdb/go-orbitdb/storage"
+)
+
+func TestEncodeDecodeManifest(t *testing.T) {
+	manifest := orbitdb.Manifest{
+		Name:             "my-db",
+		Type:             "keyvalue",
+		AccessController: "/ipfs/" + testManifestHash,
+		Meta:             map[string]interface{}{"owner": "alice", "version": 2},
+	}
+
+	encoded, err := orbitdb.EncodeManifest(manifest)
+	require.NoError(t, err)
+	assert.NotEmpty(t, encoded.Bytes)
+	assert.Equal(t, "zdpu", encoded.Hash[:4], "Expected a base58btc dag-cbor CID")
+
+	decoded, err := orbitdb.DecodeManifest(encoded.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, encoded.Hash, decoded.Hash)
+	assert.Equal(t, manifest, decoded.Manifest)
+}
+
+func TestEncodeManifestIsDeterministic(t *testing.T) {
+	manifest := orbitdb.Manifest{Name: "my-db", Type: "events", Meta: map[string]interface{}{"a": "1", "b": "2"}}
+
+	first, err := orbitdb.EncodeManifest(manifest)
+	require.NoError(t, err)
+	second, err := orbitdb.EncodeManifest(manifest)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.Equal(t, first.Bytes, second.Bytes)
+}
+
+func TestEncodeManifestConvertsMeta(t *testing.T) {
+	manifest := orbitdb.Manifest{Name: "my-db", Type: "events", Meta: map[string]interface{}{
+		"int32":   int32(-3),
+		"uint":    uint(4),
+		"float32": float32(1.5),
+		"labels":  map[string]string{"env": "test"},
+		"tags":    []string{"a", "b"},
+	}}
+
+	encoded, err := orbitdb.EncodeManifest(manifest)
+	require.NoError(t, err)
+	decoded, err := orbitdb.DecodeManifest(encoded.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"int32":   -3,
+		"uint":    4,
+		"float32": 1.5,
+		"labels":  map[string]interface{}{"env": "test"},
+		"tags":    []interface{}{"a", "b"},
+	}, decoded.Meta)
+
+	// Values that have no counterpart in the manifest are rejected
+	for _, value := range []interface{}{struct{}{}, uint64(1) << 63, map[int]string{1: "a"}} {
+		_, err := orbitdb.EncodeManifest(orbitdb.Manifest{Name: "my-db", Type: "events", Meta: map[string]interface{}{"value": value}})
+		assert.Error(t, err, "Expected %T to be rejected", value)
+	}
+}
+
+func TestDecodeManifestInvalidData(t *testing.T) {
+	_, err := orbitdb.DecodeManifest(nil)
+	assert.Error(t, err)
+
+	_, err = orbitdb.DecodeManifest([]byte("not cbor"))
+	assert.Error(t, err)
+}
+
+func TestManifestStore(t *testing.T) {
+	store, err := orbitdb.NewManifestStore(storage.NewMemoryStorage())
+	require.NoError(t, err)
+
+	created, err := store.Create("my-db", "documents", "", nil)
+	require.NoError(t, err)
+
+	retrieved, err := store.Get(created.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, "my-db", retrieved.Name)
+	assert.Equal(t, "documents", retrieved.Type)
+	assert.Nil(t, retrieved.Meta)
+
+	_, err = store.Get(testManifestHash)
+	assert.Error(t, err)
+
+	_, err = store.Create("", "documents", "", nil)
+	assert.Error(t, err)
+}
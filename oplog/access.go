@@ -0,0 +1,29 @@
+package oplog
+
+import (
+	"orbitdb/go-orbitdb/identities/identitytypes"
+)
+
+// AccessController decides whether an entry may be added to a log. It is consulted
+// for entries appended locally and for every entry joined from another replica.
+type AccessController interface {
+	CanAppend(entry *EncodedEntry, identityProvider IdentityProvider) (bool, error)
+}
+
+// HistoryAccessController is an AccessController that also judges an entry by its causal
+// history, e.g. whether it was known when a capability was revoked. CanAppendInHistory is
+// called in addition to CanAppend once the entry's history is available, with load reading
+// the entries of the log along with the entries being added to it.
+type HistoryAccessController interface {
+	AccessController
+	CanAppendInHistory(entry *EncodedEntry, identityProvider IdentityProvider, load func(hash string) (*EncodedEntry, error)) (bool, error)
+}
+
+// IdentityProvider resolves and verifies the identities that signed entries.
+type IdentityProvider interface {
+	// GetIdentity returns the identity with the given hash, or an error if it cannot be resolved.
+	GetIdentity(hash string) (*identitytypes.Identity, error)
+
+	// VerifyIdentity checks that the identity is valid.
+	VerifyIdentity(identity *identitytypes.Identity) bool
+}
@@ -2,6 +2,7 @@ package oplog
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/datamodel"
@@ -120,6 +121,28 @@ func VerifyEntrySignature(ks *keystore.KeyStore, encodedEntry EncodedEntry) bool
 	return err == nil && verified
 }
 
+// VerifyEntryIdentity resolves the identity that signed the entry and checks that the
+// identity is valid and that the entry's key is the identity's public key.
+func VerifyEntryIdentity(encodedEntry EncodedEntry, identityProvider IdentityProvider) error {
+	identity, err := identityProvider.GetIdentity(encodedEntry.Identity)
+	if err != nil {
+		return fmt.Errorf("failed to resolve identity of entry %s: %w", encodedEntry.Hash, err)
+	}
+	if identity == nil {
+		return fmt.Errorf("identity %s of entry %s not found", encodedEntry.Identity, encodedEntry.Hash)
+	}
+
+	if identity.PublicKey != encodedEntry.Key {
+		return fmt.Errorf("key of entry %s does not belong to identity %s", encodedEntry.Hash, encodedEntry.Identity)
+	}
+
+	if !identityProvider.VerifyIdentity(identity) {
+		return fmt.Errorf("identity %s of entry %s is not valid", encodedEntry.Identity, encodedEntry.Hash)
+	}
+
+	return nil
+}
+
 // IsEntry checks if an object is a valid entry
 func IsEntry(entry Entry) bool {
 	return entry.ID != "" && entry.Payload != "" && entry.Clock.ID != "" && entry.Clock.Time > 0
@@ -213,19 +236,29 @@ func Encode(entry Entry) EncodedEntry {
 	}
 
 	// Calculate CID for CBOR-encoded bytes
-	hash, err := mh.Sum(buf.Bytes(), mh.SHA2_256, -1)
+	c, hashStr, err := ComputeCID(buf.Bytes())
 	if err != nil {
 		panic(err)
 	}
+
+	return EncodedEntry{Entry: entry, Bytes: buf.Bytes(), CID: c, Hash: hashStr}
+}
+
+// ComputeCID calculates the CIDv1 of dag-cbor encoded data, hashed with sha2-256, along
+// with its base58btc string. Entries, manifests and access controllers are all addressed
+// by it.
+func ComputeCID(data []byte) (cid.Cid, string, error) {
+	hash, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, "", err
+	}
 	c := cid.NewCidV1(cid.DagCBOR, hash)
 
-	// Encode CID to base58btc for the hash
 	hashStr, err := c.StringOfBase(multibase.Base58BTC)
 	if err != nil {
-		panic(err)
+		return cid.Undef, "", err
 	}
-
-	return EncodedEntry{Entry: entry, Bytes: buf.Bytes(), CID: c, Hash: hashStr}
+	return c, hashStr, nil
 }
 
 // Decode decodes CBOR-encoded data into an EncodedEntry struct
@@ -288,12 +321,7 @@ func Decode(encodedData []byte) (EncodedEntry, error) {
 	}
 
 	// Calculate the CID for CBOR-encoded bytes
-	hash, err := mh.Sum(encodedData, mh.SHA2_256, -1)
-	if err != nil {
-		return EncodedEntry{}, err
-	}
-	c := cid.NewCidV1(cid.DagCBOR, hash)
-	hashStr, err := c.StringOfBase(multibase.Base58BTC)
+	c, hashStr, err := ComputeCID(encodedData)
 	if err != nil {
 		return EncodedEntry{}, err
 	}
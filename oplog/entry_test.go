@@ -70,6 +70,22 @@ func TestVerifyEntrySignature(t *testing.T) {
 	}
 }
 
+func TestVerifyEntryIdentity(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	entry := NewEntry(ks, identity, "entry-ID", "payload-data", Clock{ID: "test-clock", Time: 1}, nil, nil)
+
+	if err := VerifyEntryIdentity(entry, staticIdentityProvider{identity.Hash: identity}); err != nil {
+		t.Errorf("Expected the entry's identity to be verified, got %v", err)
+	}
+
+	// The identity's signatures no longer match its ID
+	tampered := *identity
+	tampered.ID = "tampered-ID"
+	if err := VerifyEntryIdentity(entry, invalidIdentityProvider{identity.Hash: &tampered}); err == nil {
+		t.Error("Expected an invalid identity to be rejected")
+	}
+}
+
 func TestIsEntry(t *testing.T) {
 	validEntry := Entry{
 		ID:      "entry-ID",
@@ -0,0 +1,63 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"orbitdb/go-orbitdb/storage"
+)
+
+// Fetcher retrieves the encoded bytes of entries that are missing from a log.
+type Fetcher interface {
+	// Fetch returns the encoded entry with the given hash. It must return when ctx is done.
+	Fetch(ctx context.Context, hash string) ([]byte, error)
+}
+
+// StorageFetcher fetches entries from a storage.Storage, such as an IPFSBlockStorage
+// that resolves blocks from the network.
+type StorageFetcher struct {
+	storage storage.Storage
+}
+
+// NewStorageFetcher creates a Fetcher backed by the given storage.
+func NewStorageFetcher(s storage.Storage) (*StorageFetcher, error) {
+	if s == nil {
+		return nil, errors.New("storage is required")
+	}
+	return &StorageFetcher{storage: s}, nil
+}
+
+// Fetch retrieves the entry from the storage, giving up when ctx is done.
+func (f *StorageFetcher) Fetch(ctx context.Context, hash string) ([]byte, error) {
+	// Storages that take a context give up by themselves
+	if cs, ok := f.storage.(storage.ContextStorage); ok {
+		data, err := cs.GetContext(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch entry %s: %w", hash, err)
+		}
+		return data, nil
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	// Other storage lookups are not context-aware, so wait for them in the background
+	resultChan := make(chan result, 1)
+	go func() {
+		data, err := f.storage.Get(hash)
+		resultChan <- result{data: data, err: err}
+	}()
+
+	select {
+	case res := <-resultChan:
+		if res.err != nil {
+			return nil, fmt.Errorf("failed to fetch entry %s: %w", hash, res.err)
+		}
+		return res.data, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to fetch entry %s: %w", hash, ctx.Err())
+	}
+}
@@ -0,0 +1,56 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/storage"
+)
+
+func TestNewStorageFetcherRequiresStorage(t *testing.T) {
+	_, err := NewStorageFetcher(nil)
+	assert.Error(t, err)
+}
+
+func TestStorageFetcher_Fetch(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	require.NoError(t, s.Put("hash1", []byte("data1")))
+
+	fetcher, err := NewStorageFetcher(s)
+	require.NoError(t, err)
+
+	data, err := fetcher.Fetch(context.Background(), "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data1"), data)
+
+	_, err = fetcher.Fetch(context.Background(), "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// slowStorage blocks lookups until released.
+type slowStorage struct {
+	storage.Storage
+	release chan struct{}
+}
+
+func (s *slowStorage) Get(key string) ([]byte, error) {
+	<-s.release
+	return s.Storage.Get(key)
+}
+
+func TestStorageFetcher_FetchCanceled(t *testing.T) {
+	s := &slowStorage{Storage: storage.NewMemoryStorage(), release: make(chan struct{})}
+	defer close(s.release)
+
+	fetcher, err := NewStorageFetcher(s)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = fetcher.Fetch(ctx, "hash1")
+	assert.True(t, errors.Is(err, context.Canceled), "Expected the fetch to be canceled, got %v", err)
+}
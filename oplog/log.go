@@ -1,29 +1,107 @@
 package oplog
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"orbitdb/go-orbitdb/identities/identitytypes"
 	"orbitdb/go-orbitdb/keystore"
 	"sort"
 	"sync"
+	"time"
 
 	"orbitdb/go-orbitdb/storage"
 )
 
+// DefaultJoinTimeout is the time JoinEntry waits for missing entries to be fetched.
+const DefaultJoinTimeout = 30 * time.Second
+
+// DefaultReferencesCount is how many entries back the skip-list references of a new entry reach.
+const DefaultReferencesCount = 32
+
+// pendingKey marks in the heads storage that entries are being written ahead of the heads.
+// The batch that updates the heads removes it, so finding it when the log is opened means
+// that the entries of the last write may have been stored without becoming heads.
+const pendingKey = "pending"
+
 // Log represents an append-only log
 type Log struct {
-	ID       string
-	Identity *identitytypes.Identity
-	Clock    Clock
-	Head     *EncodedEntry
-	Entries  storage.Storage
-	keystore *keystore.KeyStore
-	Mu       sync.RWMutex
+	ID           string
+	Identity     *identitytypes.Identity
+	clock        Clock
+	Entries      storage.Storage
+	headsStorage storage.Storage          // Persisted heads, keyed by entry hash
+	heads        map[string]*EncodedEntry // Current heads of the log
+	keystore     *keystore.KeyStore
+	fetcher      Fetcher       // Fetches entries missing from the log when joining
+	joinTimeout  time.Duration // Maximum time a join waits for missing entries
+	joinDepth    int           // Maximum number of links followed by a join, 0 for no limit
+	refsCount    int           // How many entries back the references of a new entry reach
+	access       AccessController
+	identities   IdentityProvider
+	Mu           sync.RWMutex
+}
+
+// Option configures optional settings of a Log.
+type Option func(*Log)
+
+// WithHeadsStorage sets the storage used to persist the heads of the log.
+func WithHeadsStorage(headsStorage storage.Storage) Option {
+	return func(l *Log) {
+		l.headsStorage = headsStorage
+	}
+}
+
+// WithFetcher sets the Fetcher used to retrieve missing entries when joining.
+// By default missing entries are looked up in the entry storage.
+func WithFetcher(fetcher Fetcher) Option {
+	return func(l *Log) {
+		l.fetcher = fetcher
+	}
+}
+
+// WithJoinTimeout sets how long a join waits for missing entries. A timeout of 0 disables it.
+func WithJoinTimeout(timeout time.Duration) Option {
+	return func(l *Log) {
+		l.joinTimeout = timeout
+	}
+}
+
+// WithJoinDepth limits how many links a join follows back from the joined entry. A depth of 0 means no limit.
+func WithJoinDepth(depth int) Option {
+	return func(l *Log) {
+		l.joinDepth = depth
+	}
+}
+
+// WithReferencesCount sets how many entries back the skip-list references of a new
+// entry reach. The entries at power-of-two distances within that range are referenced,
+// as in the JS implementation. A count of 0 disables references.
+func WithReferencesCount(referencesCount int) Option {
+	return func(l *Log) {
+		l.refsCount = referencesCount
+	}
+}
+
+// WithAccessController sets the AccessController that every appended and joined entry
+// must be allowed by. Without one, all correctly signed entries are accepted.
+func WithAccessController(access AccessController) Option {
+	return func(l *Log) {
+		l.access = access
+	}
+}
+
+// WithIdentityProvider sets the IdentityProvider the access controller resolves writers with.
+// When set, joined entries must also be signed by the key of a valid identity.
+func WithIdentityProvider(identityProvider IdentityProvider) Option {
+	return func(l *Log) {
+		l.identities = identityProvider
+	}
 }
 
 // NewLog creates a new log instance
-func NewLog(id string, identity *identitytypes.Identity, entryStorage storage.Storage, keyStore *keystore.KeyStore) (*Log, error) {
+func NewLog(id string, identity *identitytypes.Identity, entryStorage storage.Storage, keyStore *keystore.KeyStore, options ...Option) (*Log, error) {
 	if id == "" {
 		return nil, errors.New("log ID is required")
 	}
@@ -49,13 +127,253 @@ func NewLog(id string, identity *identitytypes.Identity, entryStorage storage.St
 		}
 	}
 
-	return &Log{
-		ID:       id,
-		Identity: identity,
-		Clock:    NewClock(identity.ID, 0),
-		Entries:  entryStorage,
-		keystore: keyStore,
-	}, nil
+	l := &Log{
+		ID:          id,
+		Identity:    identity,
+		clock:       NewClock(identity.ID, 0),
+		Entries:     entryStorage,
+		heads:       make(map[string]*EncodedEntry),
+		keystore:    keyStore,
+		joinTimeout: DefaultJoinTimeout,
+		refsCount:   DefaultReferencesCount,
+	}
+
+	for _, option := range options {
+		option(l)
+	}
+
+	// Default to memory storage for the heads if none is provided
+	if l.headsStorage == nil {
+		l.headsStorage = storage.NewMemoryStorage()
+	}
+
+	if l.access != nil && l.identities == nil {
+		return nil, errors.New("identity provider is required with an access controller")
+	}
+
+	// Default to fetching missing entries from the entry storage
+	if l.fetcher == nil {
+		fetcher, err := NewStorageFetcher(l.Entries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fetcher: %w", err)
+		}
+		l.fetcher = fetcher
+	}
+
+	if err := l.loadHeads(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// loadHeads restores the heads from the heads storage and advances the clock past them.
+func (l *Log) loadHeads() error {
+	ch, err := l.headsStorage.Iterator()
+	if err != nil {
+		return fmt.Errorf("failed to iterate over heads: %w", err)
+	}
+
+	pending := false
+	for kv := range ch {
+		if kv[0] == pendingKey {
+			pending = true
+			continue
+		}
+
+		entry, err := Decode([]byte(kv[1]))
+		if err != nil {
+			return fmt.Errorf("failed to decode head %s: %w", kv[0], err)
+		}
+
+		l.heads[entry.Hash] = &entry
+		l.mergeClock(entry.Clock)
+	}
+
+	if pending {
+		if err := l.recoverHeads(); err != nil {
+			return err
+		}
+	}
+
+	// Pin the restored heads in case the log was written before they were pinned
+	hashes := make([]string, 0, len(l.heads))
+	for hash := range l.heads {
+		hashes = append(hashes, hash)
+	}
+	return l.pinHeads(hashes)
+}
+
+// recoverHeads completes a write that stopped after its entries were stored but before the
+// heads were updated. Entries are stored together with their whole history, so the stored
+// entries of the log that no other entry points to are its heads.
+func (l *Log) recoverHeads() error {
+	ch, err := l.Entries.Iterator()
+	if err != nil {
+		return fmt.Errorf("failed to iterate over Entries: %w", err)
+	}
+
+	stored := make(map[string]*EncodedEntry)
+	referenced := make(map[string]bool)
+	for kv := range ch {
+		entry, err := Decode([]byte(kv[1]))
+		if err != nil || entry.ID != l.ID || !VerifyEntrySignature(l.keystore, entry) {
+			continue
+		}
+		stored[entry.Hash] = &entry
+		for _, hash := range entry.Next {
+			referenced[hash] = true
+		}
+	}
+
+	batch := l.headsStorage.NewBatch()
+	for hash := range l.heads {
+		if referenced[hash] {
+			batch.Delete(hash)
+			delete(l.heads, hash)
+		}
+	}
+	for hash, entry := range stored {
+		if _, isHead := l.heads[hash]; isHead || referenced[hash] {
+			continue
+		}
+		batch.Put(hash, entry.Bytes)
+		l.heads[hash] = entry
+		l.mergeClock(entry.Clock)
+	}
+	batch.Delete(pendingKey)
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to recover heads: %w", err)
+	}
+	return nil
+}
+
+// beginWrite marks in the heads storage that entries are about to be written, until the heads
+// are updated. The caller must hold the lock.
+func (l *Log) beginWrite() error {
+	if err := l.headsStorage.Put(pendingKey, []byte(l.ID)); err != nil {
+		return fmt.Errorf("failed to mark pending write: %w", err)
+	}
+	return nil
+}
+
+// Clock returns the current Lamport clock of the log.
+func (l *Log) Clock() Clock {
+	l.Mu.RLock()
+	defer l.Mu.RUnlock()
+
+	return l.clock
+}
+
+// mergeClock advances the clock to the time of the given clock if it is ahead. The caller must hold the lock.
+func (l *Log) mergeClock(clock Clock) {
+	if clock.Time > l.clock.Time {
+		l.clock = NewClock(l.clock.ID, clock.Time)
+	}
+}
+
+// Heads returns the current heads of the log, latest first.
+func (l *Log) Heads() []*EncodedEntry {
+	l.Mu.RLock()
+	defer l.Mu.RUnlock()
+
+	return l.sortedHeads()
+}
+
+// sortedHeads returns the heads sorted by clock, latest first. The caller must hold the lock.
+func (l *Log) sortedHeads() []*EncodedEntry {
+	heads := make([]*EncodedEntry, 0, len(l.heads))
+	for _, head := range l.heads {
+		heads = append(heads, head)
+	}
+
+	sort.Slice(heads, func(i, j int) bool {
+		return CompareClocks(heads[i].Clock, heads[j].Clock) > 0
+	})
+
+	return heads
+}
+
+// updateHeads adds the entries as heads and removes the current heads that any of the
+// joined entries reference, including the joined entries that don't become heads themselves.
+// The heads storage is updated with a single batch, so that a crash never leaves it without
+// the heads being replaced. The batch also ends the pending write started with beginWrite.
+// The caller must hold the lock.
+func (l *Log) updateHeads(entries []*EncodedEntry, joined []*EncodedEntry) error {
+	batch := l.headsStorage.NewBatch()
+	batch.Delete(pendingKey)
+	var replaced []string
+	seen := make(map[string]bool)
+	for _, entry := range joined {
+		for _, hash := range entry.Next {
+			if _, isHead := l.heads[hash]; isHead && !seen[hash] {
+				seen[hash] = true
+				batch.Delete(hash)
+				replaced = append(replaced, hash)
+			}
+		}
+	}
+	added := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		batch.Put(entry.Hash, entry.Bytes)
+		added = append(added, entry.Hash)
+	}
+
+	// The new heads are pinned before they replace the old ones, so the history stays pinned
+	if err := l.pinHeads(added); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to update heads: %w", err)
+	}
+
+	for _, hash := range replaced {
+		delete(l.heads, hash)
+	}
+	for _, entry := range entries {
+		l.heads[entry.Hash] = entry
+	}
+	l.unpinHeads(replaced)
+
+	return nil
+}
+
+// pinHeads pins the heads in the entry storage if it keeps blocks through pins on the heads.
+func (l *Log) pinHeads(hashes []string) error {
+	pinner, ok := l.Entries.(storage.HeadPinner)
+	if !ok || len(hashes) == 0 {
+		return nil
+	}
+
+	if err := pinner.PinHeads(context.Background(), hashes...); err != nil {
+		return fmt.Errorf("failed to pin heads: %w", err)
+	}
+	return nil
+}
+
+// unpinHeads unpins heads that have been replaced. Their history stays pinned through the
+// heads that replaced them, so a failure only keeps their blocks around and is not returned.
+func (l *Log) unpinHeads(hashes []string) {
+	pinner, ok := l.Entries.(storage.HeadPinner)
+	if !ok || len(hashes) == 0 {
+		return
+	}
+
+	if err := pinner.UnpinHeads(context.Background(), hashes...); err != nil {
+		log.Printf("Failed to unpin replaced heads: %v", err)
+	}
+}
+
+// holdBlocks keeps the entry storage from removing the entries that are written until release
+// is called, if it keeps blocks through pins on the heads. The entries are held until the
+// heads that link to them are pinned.
+func (l *Log) holdBlocks() (release func()) {
+	pinner, ok := l.Entries.(storage.HeadPinner)
+	if !ok {
+		return func() {}
+	}
+	return pinner.HoldBlocks()
 }
 
 // Append adds a new entry to the log
@@ -67,29 +385,199 @@ func (l *Log) Append(payload string) (*EncodedEntry, error) {
 		return nil, errors.New("payload is required")
 	}
 
-	l.Clock = TickClock(l.Clock)
+	clock := TickClock(l.clock)
 
-	var next []string
-	if l.Head != nil {
-		next = []string{l.Head.Hash}
+	// Point to every current head, latest first, so that concurrent branches are merged
+	heads := l.sortedHeads()
+	next := make([]string, 0, len(heads))
+	for _, head := range heads {
+		next = append(next, head.Hash)
 	}
 
-	entry := NewEntry(l.keystore, l.Identity, l.ID, payload, l.Clock, next, nil)
+	entry := NewEntry(l.keystore, l.Identity, l.ID, payload, clock, next, l.references(heads, l.loader(context.Background())))
+
+	if err := l.checkAccess(&entry); err != nil {
+		return nil, fmt.Errorf("could not append entry: %w", err)
+	}
+	if err := l.checkHistory(&entry, l.loader(context.Background())); err != nil {
+		return nil, fmt.Errorf("could not append entry: %w", err)
+	}
+	l.clock = clock
 
+	defer l.holdBlocks()()
+	if err := l.beginWrite(); err != nil {
+		return nil, err
+	}
 	if err := l.Entries.Put(entry.Hash, entry.Bytes); err != nil {
 		return nil, fmt.Errorf("failed to store entry: %w", err)
 	}
 
-	l.Head = &entry
+	if err := l.updateHeads([]*EncodedEntry{&entry}, []*EncodedEntry{&entry}); err != nil {
+		return nil, err
+	}
+
 	return &entry, nil
 }
 
+// AppendBatch adds an entry for each payload to the log, each pointing to the one before.
+// The entries are written to the entry storage with a single batch, and the heads are only
+// replaced by the last entry once they have all been stored. If any entry cannot be
+// appended, none of them is added to the log. If the log stops after the entries were
+// stored, the last entry becomes the head when it is opened again.
+func (l *Log) AppendBatch(payloads []string) ([]*EncodedEntry, error) {
+	l.Mu.Lock()
+	defer l.Mu.Unlock()
+
+	if len(payloads) == 0 {
+		return nil, errors.New("at least one payload is required")
+	}
+
+	// The entries of the batch are not stored yet, so references to them are resolved here.
+	// The prefetcher loads entries concurrently, hence the lock.
+	staged := make(map[string]*EncodedEntry, len(payloads))
+	var stagedMu sync.RWMutex
+	load := func(hash string) (*EncodedEntry, error) {
+		stagedMu.RLock()
+		entry, ok := staged[hash]
+		stagedMu.RUnlock()
+		if ok {
+			return entry, nil
+		}
+		return l.loadEntry(context.Background(), hash)
+	}
+
+	batch := l.Entries.NewBatch()
+	entries := make([]*EncodedEntry, 0, len(payloads))
+	clock := l.clock
+	heads := l.sortedHeads()
+
+	for _, payload := range payloads {
+		if payload == "" {
+			return nil, errors.New("payload is required")
+		}
+
+		clock = TickClock(clock)
+		next := make([]string, 0, len(heads))
+		for _, head := range heads {
+			next = append(next, head.Hash)
+		}
+
+		entry := NewEntry(l.keystore, l.Identity, l.ID, payload, clock, next, l.references(heads, load))
+		if err := l.checkAccess(&entry); err != nil {
+			return nil, fmt.Errorf("could not append entry: %w", err)
+		}
+		if err := l.checkHistory(&entry, load); err != nil {
+			return nil, fmt.Errorf("could not append entry: %w", err)
+		}
+
+		batch.Put(entry.Hash, entry.Bytes)
+		stagedMu.Lock()
+		staged[entry.Hash] = &entry
+		stagedMu.Unlock()
+		entries = append(entries, &entry)
+		heads = []*EncodedEntry{&entry}
+	}
+
+	defer l.holdBlocks()()
+	if err := l.beginWrite(); err != nil {
+		return nil, err
+	}
+	if err := batch.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to store entries: %w", err)
+	}
+	l.clock = clock
+
+	// The first entry points to every previous head, the last one replaces them all
+	last := entries[len(entries)-1]
+	headsBatch := l.headsStorage.NewBatch()
+	headsBatch.Delete(pendingKey)
+	replaced := make([]string, 0, len(l.heads))
+	for hash := range l.heads {
+		headsBatch.Delete(hash)
+		replaced = append(replaced, hash)
+	}
+	headsBatch.Put(last.Hash, last.Bytes)
+	if err := l.pinHeads([]string{last.Hash}); err != nil {
+		return nil, err
+	}
+	if err := headsBatch.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update heads: %w", err)
+	}
+	l.heads = map[string]*EncodedEntry{last.Hash: last}
+	l.unpinHeads(replaced)
+
+	return entries, nil
+}
+
+// references returns skip-list pointers to the entries at power-of-two distances
+// (2, 4, 8, ...) behind a new entry, up to referencesCount entries back. The heads are
+// at distance 1 and already referenced by Next. Entries are read with load. The caller
+// must hold the lock.
+func (l *Log) references(heads []*EncodedEntry, load func(hash string) (*EncodedEntry, error)) []string {
+	if l.refsCount <= 1 || len(heads) == 0 {
+		return nil
+	}
+
+	isHead := make(map[string]bool, len(heads))
+	for _, head := range heads {
+		isHead[head.Hash] = true
+	}
+
+	var refs []string
+	distance := 0
+	nextRef := 2
+	l.traverseWith(context.Background(), heads, load, func(entry *EncodedEntry) bool {
+		distance++
+		if distance == nextRef {
+			if !isHead[entry.Hash] {
+				refs = append(refs, entry.Hash)
+			}
+			nextRef *= 2
+		}
+		return nextRef > l.refsCount
+	}, false)
+
+	return refs
+}
+
 // Get retrieves an entry by its hash
 func (l *Log) Get(hash string) (*EncodedEntry, error) {
+	return l.GetContext(context.Background(), hash)
+}
+
+// GetContext retrieves an entry by its hash, giving up when ctx is done. The error wraps
+// storage.ErrNotFound if the entry is not in the log.
+func (l *Log) GetContext(ctx context.Context, hash string) (*EncodedEntry, error) {
 	l.Mu.RLock()
 	defer l.Mu.RUnlock()
 
-	data, err := l.Entries.Get(hash)
+	return l.getEntry(ctx, hash)
+}
+
+// getEntry loads an entry and verifies its signature.
+func (l *Log) getEntry(ctx context.Context, hash string) (*EncodedEntry, error) {
+	entry, err := l.loadEntry(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if !VerifyEntrySignature(l.keystore, *entry) {
+		return nil, fmt.Errorf("invalid signature for entry %s", hash)
+	}
+
+	return entry, nil
+}
+
+// loader returns a function that loads entries from the entry storage with ctx.
+func (l *Log) loader(ctx context.Context) func(hash string) (*EncodedEntry, error) {
+	return func(hash string) (*EncodedEntry, error) {
+		return l.loadEntry(ctx, hash)
+	}
+}
+
+// loadEntry reads and decodes an entry from the entry storage.
+func (l *Log) loadEntry(ctx context.Context, hash string) (*EncodedEntry, error) {
+	data, err := storage.WithContext(l.Entries).GetContext(ctx, hash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get entry for hash %s: %w", hash, err)
 	}
@@ -99,20 +587,21 @@ func (l *Log) Get(hash string) (*EncodedEntry, error) {
 		return nil, fmt.Errorf("failed to decode entry for hash %s: %w", hash, err)
 	}
 
-	if !VerifyEntrySignature(l.keystore, entry) {
-		return nil, fmt.Errorf("invalid signature for entry %s", hash)
-	}
-
 	return &entry, nil
 }
 
 // Values retrieves all Entries in the log, sorted using CompareClocks
 func (l *Log) Values() ([]EncodedEntry, error) {
+	return l.ValuesContext(context.Background())
+}
+
+// ValuesContext retrieves all Entries in the log like Values, giving up when ctx is done.
+func (l *Log) ValuesContext(ctx context.Context) ([]EncodedEntry, error) {
 	l.Mu.RLock()
 	defer l.Mu.RUnlock()
 
 	entries := make([]EncodedEntry, 0)
-	ch, err := l.Entries.Iterator()
+	ch, err := storage.WithContext(l.Entries).IteratorContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to iterate over Entries: %w", err)
 	}
@@ -124,6 +613,11 @@ func (l *Log) Values() ([]EncodedEntry, error) {
 			continue
 		}
 
+		// The storage may be shared with other logs
+		if entry.ID != l.ID {
+			continue
+		}
+
 		if !VerifyEntrySignature(l.keystore, entry) {
 			fmt.Printf("Warning: Skipping entry with invalid signature: %s\n", entry.Hash)
 			continue
@@ -131,6 +625,9 @@ func (l *Log) Values() ([]EncodedEntry, error) {
 
 		entries = append(entries, entry)
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over Entries: %w", err)
+	}
 
 	// Sort Entries using CompareClocks
 	sort.Slice(entries, func(i, j int) bool {
@@ -140,29 +637,61 @@ func (l *Log) Values() ([]EncodedEntry, error) {
 	return entries, nil
 }
 
+// Traverse walks the log from the given entry, or from the heads, towards its first
+// entries. The entries linked by Next and Refs are prefetched in parallel.
 func (l *Log) Traverse(startHash string, shouldStop func(*EncodedEntry) bool) ([]*EncodedEntry, error) {
+	return l.TraverseContext(context.Background(), startHash, shouldStop)
+}
+
+// TraverseContext walks the log like Traverse, giving up when ctx is done.
+func (l *Log) TraverseContext(ctx context.Context, startHash string, shouldStop func(*EncodedEntry) bool) ([]*EncodedEntry, error) {
 	l.Mu.RLock()
 	defer l.Mu.RUnlock()
 
-	var traversed []*EncodedEntry
-	visited := make(map[string]bool)
-
-	// Start traversal from the specified entry or the current head
-	var stack []*EncodedEntry
+	// Start traversal from the specified entry or the current heads
+	var start []*EncodedEntry
 	if startHash != "" {
-		startEntry, err := l.Get(startHash)
+		startEntry, err := l.getEntry(ctx, startHash)
 		if err != nil {
 			return nil, fmt.Errorf("failed to start traversal from entry: %w", err)
 		}
-		stack = []*EncodedEntry{startEntry}
-	} else if l.Head != nil {
-		stack = []*EncodedEntry{l.Head}
+		start = []*EncodedEntry{startEntry}
+	} else if len(l.heads) > 0 {
+		start = l.sortedHeads()
 	} else {
 		return nil, errors.New("no starting point for traversal")
 	}
 
+	traversed := l.traverse(ctx, start, shouldStop, true)
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to traverse log: %w", err)
+	}
+	return traversed, nil
+}
+
+// traverse walks the log depth first from the given entries, visiting them in order, until
+// ctx is done. Entries with an invalid signature are skipped when verify is set. The caller
+// must hold the lock.
+func (l *Log) traverse(ctx context.Context, start []*EncodedEntry, shouldStop func(*EncodedEntry) bool, verify bool) []*EncodedEntry {
+	return l.traverseWith(ctx, start, l.loader(ctx), shouldStop, verify)
+}
+
+// traverseWith walks the log like traverse, reading the entries with load.
+func (l *Log) traverseWith(ctx context.Context, start []*EncodedEntry, load func(hash string) (*EncodedEntry, error), shouldStop func(*EncodedEntry) bool, verify bool) []*EncodedEntry {
+	var traversed []*EncodedEntry
+	visited := make(map[string]bool)
+
+	prefetcher := newPrefetcher(load)
+	defer prefetcher.close()
+
+	// Push the starting entries in reverse so the first one is visited first
+	stack := make([]*EncodedEntry, 0, len(start))
+	for i := len(start) - 1; i >= 0; i-- {
+		stack = append(stack, start[i])
+	}
+
 	// Perform the traversal
-	for len(stack) > 0 {
+	for len(stack) > 0 && ctx.Err() == nil {
 		// Pop the last element from the stack
 		entry := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
@@ -173,7 +702,7 @@ func (l *Log) Traverse(startHash string, shouldStop func(*EncodedEntry) bool) ([
 		}
 
 		// Verify the signature before processing
-		if !VerifyEntrySignature(l.keystore, *entry) {
+		if verify && !VerifyEntrySignature(l.keystore, *entry) {
 			fmt.Printf("Warning: Skipping entry with invalid signature: %s\n", entry.Hash)
 			continue
 		}
@@ -189,9 +718,13 @@ func (l *Log) Traverse(startHash string, shouldStop func(*EncodedEntry) bool) ([
 			break
 		}
 
+		// Start loading the entries further back before waiting for the `next` Entries
+		prefetcher.prefetch(entry.Entry.Next...)
+		prefetcher.prefetch(entry.Entry.Refs...)
+
 		// Load and add the `next` Entries to the stack
 		for _, nextHash := range entry.Entry.Next {
-			nextEntry, err := l.Get(nextHash)
+			nextEntry, err := prefetcher.get(nextHash)
 			if err != nil {
 				fmt.Printf("Warning: Failed to load next entry %s: %s\n", nextHash, err)
 				continue
@@ -200,11 +733,184 @@ func (l *Log) Traverse(startHash string, shouldStop func(*EncodedEntry) bool) ([
 		}
 	}
 
-	return traversed, nil
+	return traversed
 }
 
+// JoinEntry adds an entry from another replica to the log together with any of its
+// ancestors that are missing, and updates the heads. Missing entries are retrieved
+// with the log's Fetcher and verified; nothing is stored unless the whole missing
+// history could be fetched within the join timeout and depth limit.
 func (l *Log) JoinEntry(entry *EncodedEntry, processed map[string]bool) error {
-	// Check if the entry belongs to the current log
+	return l.JoinEntryContext(context.Background(), entry, processed)
+}
+
+// JoinEntryContext joins the entry like JoinEntry, giving up fetching the missing history
+// when ctx is done or the join timeout expires, whichever comes first.
+func (l *Log) JoinEntryContext(ctx context.Context, entry *EncodedEntry, processed map[string]bool) error {
+	// Fetch the missing history without holding the lock so that the log stays usable
+	missing, err := l.FetchHistory(ctx, entry, processed)
+	if err != nil {
+		return err
+	}
+	return l.JoinHistory(ctx, missing, processed)
+}
+
+// FetchHistory returns the entry and those of its ancestors that are missing from the log,
+// fetching them with the log's Fetcher. It gives up when ctx is done or the join timeout
+// expires. Nothing is stored until the entries are passed to JoinHistory, so callers that
+// apply joins one at a time can fetch outside of their queue.
+func (l *Log) FetchHistory(ctx context.Context, entry *EncodedEntry, processed map[string]bool) ([]*EncodedEntry, error) {
+	ctx, cancel := l.joinContext(ctx)
+	defer cancel()
+	return l.fetchMissing(ctx, entry, processed)
+}
+
+// JoinHistory verifies the entries returned by FetchHistory against their history and stores
+// them, updating the heads. The joined entries are marked in processed, which may be nil.
+func (l *Log) JoinHistory(ctx context.Context, entries []*EncodedEntry, processed map[string]bool) error {
+	ctx, cancel := l.joinContext(ctx)
+	defer cancel()
+
+	if err := l.verifyHistory(ctx, entries); err != nil {
+		return err
+	}
+
+	l.Mu.Lock()
+	defer l.Mu.Unlock()
+
+	if err := l.commitJoin(entries); err != nil {
+		return err
+	}
+
+	if processed != nil {
+		for _, e := range entries {
+			processed[e.Hash] = true
+		}
+	}
+
+	return nil
+}
+
+// joinContext limits ctx to the join timeout, if there is one.
+func (l *Log) joinContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.joinTimeout > 0 {
+		return context.WithTimeout(ctx, l.joinTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// fetchMissing walks the Next links of the entry and returns the entry and every
+// ancestor that is not yet in the log. While a level of the history is fetched, the
+// entries further back that are linked by Next and the skip-list Refs are prefetched
+// in parallel.
+func (l *Log) fetchMissing(ctx context.Context, entry *EncodedEntry, processed map[string]bool) ([]*EncodedEntry, error) {
+	if err := l.verifyEntry(entry); err != nil {
+		return nil, err
+	}
+
+	if processed[entry.Hash] || l.hasEntry(entry.Hash) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefetcher := newPrefetcher(func(hash string) (*EncodedEntry, error) {
+		return l.fetchEntry(ctx, hash)
+	})
+	defer prefetcher.close()
+
+	visited := map[string]bool{entry.Hash: true}
+	isKnown := func(hash string) bool {
+		return visited[hash] || processed[hash] || l.hasEntry(hash)
+	}
+
+	// Start fetching the entries further back before they are needed
+	prefetchLinks := func(e *EncodedEntry) {
+		links := append(append([]string{}, e.Next...), e.Refs...)
+		for _, hash := range links {
+			if !isKnown(hash) {
+				prefetcher.prefetch(hash)
+			}
+		}
+	}
+
+	missing := []*EncodedEntry{entry}
+	level := []*EncodedEntry{entry}
+	prefetchLinks(entry)
+
+	// The depth of an entry is its shortest distance to the joined entry
+	for depth := 1; len(level) > 0; depth++ {
+		var hashes []string
+		for _, current := range level {
+			for _, hash := range current.Next {
+				if isKnown(hash) {
+					continue
+				}
+				visited[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+
+		if len(hashes) == 0 {
+			break
+		}
+
+		if l.joinDepth > 0 && depth > l.joinDepth {
+			return nil, fmt.Errorf("failed to join entry %s: missing history exceeds the depth limit of %d", entry.Hash, l.joinDepth)
+		}
+
+		fetched := make([]*EncodedEntry, 0, len(hashes))
+		for _, hash := range hashes {
+			ancestor, err := prefetcher.get(hash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to join entry %s: %w", entry.Hash, err)
+			}
+
+			prefetchLinks(ancestor)
+			fetched = append(fetched, ancestor)
+		}
+
+		missing = append(missing, fetched...)
+		level = fetched
+	}
+
+	return missing, nil
+}
+
+// fetchEntry retrieves a missing entry with the fetcher and verifies it.
+func (l *Log) fetchEntry(ctx context.Context, hash string) (*EncodedEntry, error) {
+	data, err := l.fetcher.Fetch(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode entry %s: %w", hash, err)
+	}
+
+	if entry.Hash != hash {
+		return nil, fmt.Errorf("entry hash mismatch: expected %s, got %s", hash, entry.Hash)
+	}
+
+	if err := l.verifyEntry(&entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// VerifyEntry checks that an entry received from another replica belongs to the log, is
+// correctly signed by a valid identity and is allowed by the access controller, without
+// adding it to the log.
+func (l *Log) VerifyEntry(entry *EncodedEntry) error {
+	return l.verifyEntry(entry)
+}
+
+// verifyEntry checks that the entry belongs to the log, is correctly signed by a valid
+// identity and is allowed by the access controller.
+func (l *Log) verifyEntry(entry *EncodedEntry) error {
 	if entry.Entry.ID != l.ID {
 		return fmt.Errorf("entry ID '%s' does not match log ID '%s'", entry.Entry.ID, l.ID)
 	}
@@ -213,54 +919,218 @@ func (l *Log) JoinEntry(entry *EncodedEntry, processed map[string]bool) error {
 		return fmt.Errorf("invalid signature for entry %s", entry.Hash)
 	}
 
-	// Initialize a stack for iterative processing
-	stack := []*EncodedEntry{entry}
+	if l.identities != nil {
+		if err := VerifyEntryIdentity(*entry, l.identities); err != nil {
+			return err
+		}
+	}
 
-	for len(stack) > 0 {
-		// Pop an entry from the stack
-		currentEntry := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
+	return l.checkAccess(entry)
+}
 
-		// Check if the entry is already processed
-		if processed[currentEntry.Hash] {
-			continue
+// checkAccess asks the access controller whether the entry may be added to the log.
+func (l *Log) checkAccess(entry *EncodedEntry) error {
+	if l.access == nil {
+		return nil
+	}
+
+	allowed, err := l.access.CanAppend(entry, l.identities)
+	if err != nil {
+		return fmt.Errorf("failed to check access for entry %s: %w", entry.Hash, err)
+	}
+	if !allowed {
+		return fmt.Errorf("identity %s is not allowed to write to the log", entry.Identity)
+	}
+
+	return nil
+}
+
+// verifyHistory checks the entries to join against their history. An entry's clock must be
+// ahead of the clocks of the entries it points to, so that a writer cannot backdate an entry
+// behind the history it builds on, and a HistoryAccessController must allow the entry at its
+// position. The entries are checked once they have all been fetched, so that their whole
+// history can be read.
+func (l *Log) verifyHistory(ctx context.Context, entries []*EncodedEntry) error {
+	joined := make(map[string]*EncodedEntry, len(entries))
+	for _, entry := range entries {
+		joined[entry.Hash] = entry
+	}
+	load := func(hash string) (*EncodedEntry, error) {
+		if entry, ok := joined[hash]; ok {
+			return entry, nil
 		}
-		processed[currentEntry.Hash] = true
+		return l.loadEntry(ctx, hash)
+	}
 
-		// Add the entry to storage
-		err := l.Entries.Put(currentEntry.Hash, currentEntry.Bytes)
-		if err != nil {
-			return fmt.Errorf("failed to store entry: %w", err)
+	for _, entry := range entries {
+		for _, hash := range entry.Next {
+			parent, err := load(hash)
+			if err != nil {
+				return fmt.Errorf("failed to join entry %s: %w", entry.Hash, err)
+			}
+			if entry.Clock.Time <= parent.Clock.Time {
+				return fmt.Errorf("entry %s has clock time %d, which is not ahead of the time %d of entry %s it points to",
+					entry.Hash, entry.Clock.Time, parent.Clock.Time, parent.Hash)
+			}
 		}
 
-		// Update the log head if the new entry has a more recent clock
-		if l.Head == nil || CompareClocks(currentEntry.Clock, l.Head.Clock) > 0 {
-			l.Head = currentEntry
+		if err := l.checkHistory(entry, load); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// checkHistory asks a HistoryAccessController whether the entry may be added at its position
+// in the log's history. Entries are read with load.
+func (l *Log) checkHistory(entry *EncodedEntry, load func(hash string) (*EncodedEntry, error)) error {
+	access, ok := l.access.(HistoryAccessController)
+	if !ok {
+		return nil
+	}
+
+	allowed, err := access.CanAppendInHistory(entry, l.identities, load)
+	if err != nil {
+		return fmt.Errorf("failed to check access for entry %s: %w", entry.Hash, err)
+	}
+	if !allowed {
+		return fmt.Errorf("identity %s is not allowed to write entry %s at its position in the log", entry.Identity, entry.Hash)
+	}
+
+	return nil
+}
+
+// Has reports whether the entry is stored in the log. Joined entries are stored together
+// with their whole history, so this also means that all of the entry's ancestors are.
+func (l *Log) Has(hash string) bool {
+	l.Mu.RLock()
+	defer l.Mu.RUnlock()
+	return l.hasEntry(hash)
+}
+
+// hasEntry reports whether the entry is already stored in the log. Storages that can fetch
+// entries from peers are only asked whether they hold the entry locally.
+func (l *Log) hasEntry(hash string) bool {
+	if hs, ok := l.Entries.(storage.HasStorage); ok {
+		has, err := hs.Has(context.Background(), hash)
+		return err == nil && has
+	}
+	_, err := l.Entries.Get(hash)
+	return err == nil
+}
+
+// commitJoin stores the joined entries, merges their clocks and updates the heads. Only entries that no
+// other joined entry points to become heads. The entries are stored with a single batch before the
+// heads are updated, so the heads never point to an entry that is missing. If the log stops in
+// between, the entries become heads when it is opened again. The caller must hold the lock.
+func (l *Log) commitJoin(entries []*EncodedEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// Ancestors are written first, as the clock of an entry is ahead of the entries it points
+	// to, so that an entry is never stored without its history even by a storage whose
+	// batches are not atomic
+	ordered := append([]*EncodedEntry(nil), entries...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Clock.Time < ordered[j].Clock.Time
+	})
+
+	referenced := make(map[string]bool)
+	batch := l.Entries.NewBatch()
+	for _, entry := range ordered {
+		for _, hash := range entry.Next {
+			referenced[hash] = true
+		}
+		batch.Put(entry.Hash, entry.Bytes)
+	}
+
+	defer l.holdBlocks()()
+	if err := l.beginWrite(); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to store entries: %w", err)
+	}
+
+	// Keep the clock ahead of every entry in the log so new entries sort after them. The entries
+	// may have been joined by another join since they were fetched, and the log may have moved
+	// on from them, in which case they do not become heads again.
+	var heads []*EncodedEntry
+	for _, entry := range entries {
+		l.mergeClock(entry.Clock)
+		if !referenced[entry.Hash] && !l.inHistory(entry) {
+			heads = append(heads, entry)
+		}
+	}
+
+	// The entries that no other joined entry points to become heads. Every head that a joined
+	// entry points to is replaced, even when the joined entry is further back in the history.
+	return l.updateHeads(heads, entries)
+}
+
+// inHistory reports whether the entry is a head of the log or an ancestor of one. Ancestors are
+// behind an entry in time, so the walk from the heads stops at entries that are not ahead of
+// it. The caller must hold the lock.
+func (l *Log) inHistory(entry *EncodedEntry) bool {
+	if _, isHead := l.heads[entry.Hash]; isHead {
+		return true
+	}
+	// The history of the log is stored, so an entry that is not stored is not in it
+	if !l.hasEntry(entry.Hash) {
+		return false
+	}
+
+	load := l.loader(context.Background())
+	visited := make(map[string]bool)
+	stack := l.sortedHeads()
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, hash := range current.Next {
+			if hash == entry.Hash {
+				return true
+			}
+			if visited[hash] {
+				continue
+			}
+			visited[hash] = true
+
+			next, err := load(hash)
+			if err != nil || next.Clock.Time <= entry.Clock.Time {
+				continue
+			}
+			stack = append(stack, next)
+		}
+	}
+	return false
+}
+
 func (l *Log) Join(otherLog *Log) error {
-	l.Mu.Lock()
-	defer l.Mu.Unlock()
+	return l.JoinContext(context.Background(), otherLog)
+}
 
+// JoinContext joins the entries of the other log like Join, giving up when ctx is done.
+func (l *Log) JoinContext(ctx context.Context, otherLog *Log) error {
 	// Check if the other log has the same ID
 	if otherLog.ID != l.ID {
 		return fmt.Errorf("log ID '%s' does not match other log ID '%s'", l.ID, otherLog.ID)
 	}
 
 	// Get all Entries from the other log
-	otherEntries, err := otherLog.Values()
+	otherEntries, err := otherLog.ValuesContext(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve Entries from other log: %w", err)
 	}
 
-	// Process each entry using the JoinEntry method
+	// Process each entry using the JoinEntry method, ancestors first
 	processed := make(map[string]bool)
 	for _, entry := range otherEntries {
-		if err := l.JoinEntry(&entry, processed); err != nil {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("failed to join log: %w", err)
+		}
+		if err := l.JoinEntryContext(ctx, &entry, processed); err != nil {
 			fmt.Printf("Warning: Skipping invalid or duplicate entry %s: %v\n", entry.Hash, err)
 		}
 	}
@@ -273,11 +1143,26 @@ func (l *Log) Clear() error {
 	l.Mu.Lock()
 	defer l.Mu.Unlock()
 
+	// Unpin the heads so that GC can remove the history, including entries fetched from peers
+	if pinner, ok := l.Entries.(storage.HeadPinner); ok {
+		hashes := make([]string, 0, len(l.heads))
+		for hash := range l.heads {
+			hashes = append(hashes, hash)
+		}
+		if err := pinner.UnpinHeads(context.Background(), hashes...); err != nil {
+			return fmt.Errorf("failed to unpin heads: %w", err)
+		}
+	}
+
 	if err := l.Entries.Clear(); err != nil {
 		return fmt.Errorf("failed to clear Entries: %w", err)
 	}
 
-	l.Head = nil
+	if err := l.headsStorage.Clear(); err != nil {
+		return fmt.Errorf("failed to clear heads: %w", err)
+	}
+
+	l.heads = make(map[string]*EncodedEntry)
 	return nil
 }
 
@@ -286,5 +1171,13 @@ func (l *Log) Close() error {
 	l.Mu.Lock()
 	defer l.Mu.Unlock()
 
-	return l.Entries.Close()
+	// Close the entries even if the heads storage fails to close
+	var errs []error
+	if err := l.headsStorage.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close heads storage: %w", err))
+	}
+	if err := l.Entries.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
@@ -1,8 +1,25 @@
 package oplog
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"orbitdb/go-orbitdb/identities/identitytypes"
+	"orbitdb/go-orbitdb/identities/providers"
 	"orbitdb/go-orbitdb/storage"
 )
 
@@ -28,9 +45,9 @@ func TestNewLog(t *testing.T) {
 		t.Error("Log identity does not match the provided identity")
 	}
 
-	if log.Clock.ID != identity.ID || log.Clock.Time != 0 {
+	if clock := log.Clock(); clock.ID != identity.ID || clock.Time != 0 {
 		t.Errorf("Expected clock to be initialized with ID '%s' and Time 0, got ID '%s' and Time %d",
-			identity.ID, log.Clock.ID, log.Clock.Time)
+			identity.ID, clock.ID, clock.Time)
 	}
 }
 
@@ -244,6 +261,12 @@ func TestLog_JoinEntry(t *testing.T) {
 		t.Errorf("Joined entry payload does not match. Expected '%s', got '%s'",
 			entry.Payload, retrievedEntry.Payload)
 	}
+
+	// The processed entries don't have to be tracked
+	next := NewEntry(ks, identity, logID, "joined without processed", NewClock(identity.ID, 2), []string{entry.Hash}, nil)
+	if err := log.JoinEntry(&next, nil); err != nil {
+		t.Fatalf("Failed to join entry without processed entries: %v", err)
+	}
 }
 
 func TestLog_Join(t *testing.T) {
@@ -338,9 +361,8 @@ func TestLog_Clear(t *testing.T) {
 		t.Errorf("Expected 0 Entries after clear, got %d", len(entries))
 	}
 
-	head := log.Head
-	if head != nil {
-		t.Errorf("Expected head to be nil after clear, but got head with hash %s", head.Hash)
+	if heads := log.Heads(); len(heads) != 0 {
+		t.Errorf("Expected no heads after clear, but got %d", len(heads))
 	}
 }
 
@@ -359,10 +381,1445 @@ func TestLog_Head(t *testing.T) {
 		t.Fatalf("Failed to append entry: %v", err)
 	}
 
-	head := log.Head
+	heads := log.Heads()
+	if len(heads) != 1 {
+		t.Fatalf("Expected 1 head, got %d", len(heads))
+	}
 
-	if head.Hash != entry.Hash {
+	if heads[0].Hash != entry.Hash {
 		t.Errorf("Head hash does not match the last appended entry. Expected %s, got %s",
-			entry.Hash, head.Hash)
+			entry.Hash, heads[0].Hash)
+	}
+}
+
+func TestLog_HeadsAfterConcurrentAppends(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+
+	// Both logs branch from an empty log
+	entry1, err := log1.Append("entry-log1")
+	if err != nil {
+		t.Fatalf("Failed to append to log1: %v", err)
+	}
+	entry2, err := log2.Append("entry-log2")
+	if err != nil {
+		t.Fatalf("Failed to append to log2: %v", err)
+	}
+
+	if err := log1.JoinEntry(entry2, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+
+	heads := log1.Heads()
+	if len(heads) != 2 {
+		t.Fatalf("Expected 2 heads after joining a concurrent entry, got %d", len(heads))
+	}
+
+	// The next entry merges both branches
+	merged, err := log1.Append("merge")
+	if err != nil {
+		t.Fatalf("Failed to append merge entry: %v", err)
+	}
+
+	if len(merged.Next) != 2 {
+		t.Fatalf("Expected merge entry to reference 2 heads, got %d", len(merged.Next))
+	}
+	nextSet := map[string]bool{merged.Next[0]: true, merged.Next[1]: true}
+	if !nextSet[entry1.Hash] || !nextSet[entry2.Hash] {
+		t.Errorf("Expected merge entry to reference %s and %s, got %v", entry1.Hash, entry2.Hash, merged.Next)
+	}
+
+	heads = log1.Heads()
+	if len(heads) != 1 || heads[0].Hash != merged.Hash {
+		t.Errorf("Expected the merge entry to be the only head, got %d heads", len(heads))
+	}
+}
+
+func TestLog_JoinEntryRemovesReferencedHeads(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+
+	first, err := log1.Append("first")
+	if err != nil {
+		t.Fatalf("Failed to append to log1: %v", err)
+	}
+
+	processed := make(map[string]bool)
+	if err := log2.JoinEntry(first, processed); err != nil {
+		t.Fatalf("Failed to join entry into log2: %v", err)
+	}
+	second, err := log2.Append("second")
+	if err != nil {
+		t.Fatalf("Failed to append to log2: %v", err)
+	}
+
+	if err := log1.JoinEntry(second, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry into log1: %v", err)
+	}
+
+	heads := log1.Heads()
+	if len(heads) != 1 || heads[0].Hash != second.Hash {
+		t.Errorf("Expected the joined entry to replace the head it references, got %d heads", len(heads))
+	}
+}
+
+func TestLog_JoinEntryRemovesHeadsReferencedByHistory(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	fetcher, err := NewStorageFetcher(log2.Entries)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithFetcher(fetcher))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	first, err := log1.Append("first")
+	if err != nil {
+		t.Fatalf("Failed to append to log1: %v", err)
+	}
+	if err := log2.JoinEntry(first, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry into log2: %v", err)
+	}
+	head := appendEntries(t, log2, "a", "b")
+
+	// The head of log1 is referenced by "a", which is joined as history of "b"
+	if err := log1.JoinEntry(head, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry into log1: %v", err)
+	}
+
+	heads := log1.Heads()
+	if len(heads) != 1 || heads[0].Hash != head.Hash {
+		t.Errorf("Expected the joined entry to be the only head, got %d heads", len(heads))
+	}
+
+	// The replaced head is not restored from the heads storage either
+	reopened, err := NewLog(logID, identity, log1.Entries, ks, WithHeadsStorage(log1.headsStorage))
+	if err != nil {
+		t.Fatalf("Failed to reopen log1: %v", err)
+	}
+	if heads := reopened.Heads(); len(heads) != 1 || heads[0].Hash != head.Hash {
+		t.Errorf("Expected the persisted heads to only contain the joined entry, got %d heads", len(heads))
+	}
+}
+
+func TestLog_HeadsPersisted(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	entryStorage := storage.NewMemoryStorage()
+	headsStorage := storage.NewMemoryStorage()
+	log, err := NewLog(logID, identity, entryStorage, ks, WithHeadsStorage(headsStorage))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	for _, payload := range []string{"entry1", "entry2", "entry3"} {
+		if _, err := log.Append(payload); err != nil {
+			t.Fatalf("Failed to append entry: %v", err)
+		}
+	}
+	lastHead := log.Heads()[0]
+
+	// Reopen the log on the same storages
+	reopened, err := NewLog(logID, identity, entryStorage, ks, WithHeadsStorage(headsStorage))
+	if err != nil {
+		t.Fatalf("Failed to reopen log: %v", err)
+	}
+
+	heads := reopened.Heads()
+	if len(heads) != 1 || heads[0].Hash != lastHead.Hash {
+		t.Fatalf("Expected heads to survive reopening the log, got %d heads", len(heads))
+	}
+
+	if clock := reopened.Clock(); clock.Time != 3 {
+		t.Errorf("Expected clock to resume at time 3, got %d", clock.Time)
+	}
+
+	entry, err := reopened.Append("entry4")
+	if err != nil {
+		t.Fatalf("Failed to append after reopening: %v", err)
+	}
+	if len(entry.Next) != 1 || entry.Next[0] != lastHead.Hash {
+		t.Errorf("Expected new entry to reference the persisted head, got %v", entry.Next)
+	}
+}
+
+// failingBatchStorage is a storage whose batches fail to commit.
+type failingBatchStorage struct {
+	storage.Storage
+}
+
+func (s failingBatchStorage) NewBatch() storage.Batch {
+	return &failingBatch{Batch: s.Storage.NewBatch()}
+}
+
+type failingBatch struct {
+	storage.Batch
+}
+
+func (b *failingBatch) Commit() error {
+	return errors.New("commit failed")
+}
+
+func TestLog_HeadsUpdatedAtomically(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	headsStorage := &failingBatchStorage{Storage: storage.NewMemoryStorage()}
+	log, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks, WithHeadsStorage(headsStorage))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	if _, err := log.Append("entry1"); err == nil {
+		t.Fatal("Expected the append to fail when the heads can't be stored")
+	}
+
+	// Neither the stored nor the current heads are changed, only the pending write is recorded
+	if heads := log.Heads(); len(heads) != 0 {
+		t.Errorf("Expected no heads, got %d", len(heads))
+	}
+	ch, err := headsStorage.Iterator()
+	if err != nil {
+		t.Fatalf("Failed to iterate over heads: %v", err)
+	}
+	for kv := range ch {
+		if kv[0] != pendingKey {
+			t.Errorf("Expected no stored heads, got %s", kv[0])
+		}
+	}
+}
+
+func TestLog_RecoversInterruptedWrite(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	// The log stops after storing the entries, before the heads are updated
+	entryStorage := storage.NewMemoryStorage()
+	headsStorage := &failingBatchStorage{Storage: storage.NewMemoryStorage()}
+	log, err := NewLog("test-log", identity, entryStorage, ks, WithHeadsStorage(headsStorage))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	if _, err := log.AppendBatch([]string{"entry1", "entry2"}); err == nil {
+		t.Fatal("Expected the append to fail when the heads can't be stored")
+	}
+
+	// The stored entries become the heads when the log is opened again
+	reopened, err := NewLog("test-log", identity, entryStorage, ks, WithHeadsStorage(headsStorage.Storage))
+	if err != nil {
+		t.Fatalf("Failed to reopen log: %v", err)
+	}
+	heads := reopened.Heads()
+	if len(heads) != 1 || heads[0].Payload != "entry2" {
+		t.Fatalf("Expected the last stored entry to be the head, got %d heads", len(heads))
+	}
+	if _, err := headsStorage.Storage.Get(pendingKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected the pending write to be completed, got %v", err)
+	}
+
+	entry, err := reopened.Append("entry3")
+	if err != nil {
+		t.Fatalf("Failed to append after recovering: %v", err)
+	}
+	if entry.Clock.Time != 3 || len(entry.Next) != 1 || entry.Next[0] != heads[0].Hash {
+		t.Errorf("Expected the new entry to follow the recovered head, got time %d and next %v", entry.Clock.Time, entry.Next)
+	}
+}
+
+// blockingFetcher never returns an entry and waits for the context to be done.
+type blockingFetcher struct{}
+
+func (blockingFetcher) Fetch(ctx context.Context, hash string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// appendEntries appends the payloads to the log and returns the last entry.
+func appendEntries(t *testing.T, log *Log, payloads ...string) *EncodedEntry {
+	t.Helper()
+
+	var head *EncodedEntry
+	for _, payload := range payloads {
+		entry, err := log.Append(payload)
+		if err != nil {
+			t.Fatalf("Failed to append entry: %v", err)
+		}
+		head = entry
+	}
+	return head
+}
+
+func TestLog_JoinEntryFetchesMissingAncestors(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	head := appendEntries(t, log2, "entry1", "entry2", "entry3")
+
+	fetcher, err := NewStorageFetcher(log2.Entries)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithFetcher(fetcher))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	processed := make(map[string]bool)
+	if err := log1.JoinEntry(head, processed); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+
+	entries, err := log1.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected the missing history to be joined, got %d entries", len(entries))
+	}
+	for i, payload := range []string{"entry1", "entry2", "entry3"} {
+		if entries[i].Payload != payload {
+			t.Errorf("Expected entry %d to be '%s', got '%s'", i, payload, entries[i].Payload)
+		}
+	}
+
+	heads := log1.Heads()
+	if len(heads) != 1 || heads[0].Hash != head.Hash {
+		t.Errorf("Expected the joined entry to be the only head, got %d heads", len(heads))
+	}
+
+	if len(processed) != 3 {
+		t.Errorf("Expected 3 processed entries, got %d", len(processed))
+	}
+}
+
+func TestLog_JoinHistoryAfterLogMovedOn(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	head := appendEntries(t, log2, "entry1", "entry2")
+
+	fetcher, err := NewStorageFetcher(log2.Entries)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithFetcher(fetcher))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	// The history is fetched, but another join commits the entry first and the log moves on
+	missing, err := log1.FetchHistory(context.Background(), head, nil)
+	if err != nil {
+		t.Fatalf("Failed to fetch history: %v", err)
+	}
+	if len(missing) != 2 {
+		t.Fatalf("Expected 2 missing entries, got %d", len(missing))
+	}
+	if err := log1.JoinEntry(head, nil); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+	latest, err := log1.Append("entry3")
+	if err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+
+	if err := log1.JoinHistory(context.Background(), missing, nil); err != nil {
+		t.Fatalf("Failed to join history: %v", err)
+	}
+
+	heads := log1.Heads()
+	if len(heads) != 1 || heads[0].Hash != latest.Hash {
+		t.Errorf("Expected the entry joined again not to become a head, got %d heads", len(heads))
+	}
+}
+
+func TestLog_JoinEntryMissingAncestorCommitsNothing(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	head := appendEntries(t, log2, "entry1", "entry2")
+
+	// log1 can only look in its own, empty, storage
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	if err := log1.JoinEntry(head, make(map[string]bool)); err == nil {
+		t.Fatal("Expected join to fail when an ancestor cannot be fetched")
+	}
+
+	entries, err := log1.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries to be stored, got %d", len(entries))
+	}
+	if heads := log1.Heads(); len(heads) != 0 {
+		t.Errorf("Expected no heads, got %d", len(heads))
+	}
+}
+
+func TestLog_JoinEntryDepthLimit(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	head := appendEntries(t, log2, "entry1", "entry2", "entry3")
+
+	fetcher, err := NewStorageFetcher(log2.Entries)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithFetcher(fetcher), WithJoinDepth(1))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	if err := log1.JoinEntry(head, make(map[string]bool)); err == nil {
+		t.Fatal("Expected join to fail when the missing history exceeds the depth limit")
+	}
+
+	if heads := log1.Heads(); len(heads) != 0 {
+		t.Errorf("Expected no heads after a failed join, got %d", len(heads))
+	}
+}
+
+func TestLog_JoinEntryTimeout(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	head := appendEntries(t, log2, "entry1", "entry2")
+
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks,
+		WithFetcher(blockingFetcher{}), WithJoinTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	err = log1.JoinEntry(head, make(map[string]bool))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected join to time out, got %v", err)
+	}
+}
+
+func TestLog_JoinEntryRejectsMismatchedHash(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	first := appendEntries(t, log2, "entry1")
+	head := appendEntries(t, log2, "entry2")
+
+	// Serve a different entry under the hash of the missing ancestor
+	other := NewEntry(ks, identity, logID, "forged", NewClock(identity.ID, 1), nil, nil)
+	forged := storage.NewMemoryStorage()
+	if err := forged.Put(first.Hash, other.Bytes); err != nil {
+		t.Fatalf("Failed to store forged entry: %v", err)
+	}
+	fetcher, err := NewStorageFetcher(forged)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithFetcher(fetcher))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	if err := log1.JoinEntry(head, make(map[string]bool)); err == nil {
+		t.Fatal("Expected join to reject an entry whose hash does not match")
+	}
+}
+
+func TestLog_JoinEntryMergesClock(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	appendEntries(t, log, "entry1")
+
+	// An entry from a replica that is far ahead of us
+	remote := NewEntry(ks, identity, logID, "remote", NewClock("remote-ID", 50), nil, nil)
+	if err := log.JoinEntry(&remote, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+
+	if clock := log.Clock(); clock.Time != 50 || clock.ID != identity.ID {
+		t.Fatalf("Expected clock (%s, 50) after join, got (%s, %d)", identity.ID, clock.ID, clock.Time)
+	}
+
+	entry := appendEntries(t, log, "entry2")
+	if entry.Clock.Time != 51 {
+		t.Errorf("Expected the next entry to be written at time 51, got %d", entry.Clock.Time)
+	}
+
+	entries, err := log.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if last := entries[len(entries)-1]; last.Hash != entry.Hash {
+		t.Errorf("Expected the new entry to sort after the joined entry, got '%s' last", last.Payload)
+	}
+}
+
+func TestLog_JoinEntryRejectsBackdatedClock(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	head := appendEntries(t, log, "entry1", "entry2", "entry3")
+
+	// An entry that builds on the head at time 3 but claims to be written at time 2
+	backdated := NewEntry(ks, identity, logID, "backdated", NewClock("remote-ID", 2), []string{head.Hash}, nil)
+	if err := log.JoinEntry(&backdated, make(map[string]bool)); err == nil {
+		t.Fatal("Expected an entry with a clock behind the entry it points to to be rejected")
+	}
+	if log.Has(backdated.Hash) {
+		t.Error("Expected the backdated entry not to be stored")
+	}
+
+	// The same entry written ahead of its history is accepted
+	ahead := NewEntry(ks, identity, logID, "ahead", NewClock("remote-ID", 4), []string{head.Hash}, nil)
+	if err := log.JoinEntry(&ahead, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+}
+
+func TestLog_InterleavedAppendsAndJoinsAreCausallyOrdered(t *testing.T) {
+	ks, identity1 := setupTestKeyStoreAndIdentity(t)
+	identity2, err := providers.NewPublicKeyProvider(ks).CreateIdentity("test-ID-2")
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+
+	logID := "test-log"
+	log1, err := NewLog(logID, identity1, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+	log2, err := NewLog(logID, identity2, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+
+	// log1 races ahead while log2 only appends once
+	appendEntries(t, log1, "a1", "a2", "a3", "a4")
+	appendEntries(t, log2, "b1")
+
+	if err := log2.Join(log1); err != nil {
+		t.Fatalf("Failed to join log1 into log2: %v", err)
+	}
+	b2 := appendEntries(t, log2, "b2")
+
+	if err := log1.Join(log2); err != nil {
+		t.Fatalf("Failed to join log2 into log1: %v", err)
+	}
+	a5 := appendEntries(t, log1, "a5")
+
+	if err := log2.JoinEntry(a5, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join a5 into log2: %v", err)
+	}
+	b3 := appendEntries(t, log2, "b3")
+
+	if b2.Clock.Time <= 4 {
+		t.Errorf("Expected b2 to be written after a4, got time %d", b2.Clock.Time)
+	}
+	if a5.Clock.Time <= b2.Clock.Time {
+		t.Errorf("Expected a5 (time %d) to be written after b2 (time %d)", a5.Clock.Time, b2.Clock.Time)
+	}
+	if b3.Clock.Time <= a5.Clock.Time {
+		t.Errorf("Expected b3 (time %d) to be written after a5 (time %d)", b3.Clock.Time, a5.Clock.Time)
+	}
+
+	entries, err := log2.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(entries) != 8 {
+		t.Fatalf("Expected 8 entries, got %d", len(entries))
+	}
+
+	// Every entry must sort after all of the entries it points to
+	position := make(map[string]int)
+	for i, entry := range entries {
+		position[entry.Hash] = i
+	}
+	for _, entry := range entries {
+		for _, next := range entry.Next {
+			if position[next] >= position[entry.Hash] {
+				t.Errorf("Entry '%s' sorts before its predecessor", entry.Payload)
+			}
+		}
+	}
+
+	if last := entries[len(entries)-1]; last.Hash != b3.Hash {
+		t.Errorf("Expected b3 to be the last entry, got '%s'", last.Payload)
+	}
+}
+
+func TestLog_AppendAddsSkipListReferences(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	log, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	var entries []*EncodedEntry
+	for i := 0; i < 20; i++ {
+		entries = append(entries, appendEntries(t, log, fmt.Sprintf("entry%d", i)))
+	}
+
+	for n, entry := range entries {
+		// References point 2, 4, 8, ... entries back for as far as the log goes
+		var expected []string
+		for distance := 2; n-distance >= 0 && distance <= DefaultReferencesCount; distance *= 2 {
+			expected = append(expected, entries[n-distance].Hash)
+		}
+		sort.Strings(expected)
+
+		if !EqualStringSlices(entry.Refs, expected) {
+			t.Errorf("Entry %d: expected refs %v, got %v", n, expected, entry.Refs)
+		}
+	}
+}
+
+func TestLog_WithReferencesCount(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	limited, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks, WithReferencesCount(4))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	disabled, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks, WithReferencesCount(0))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	var limitedHead, disabledHead *EncodedEntry
+	for i := 0; i < 20; i++ {
+		limitedHead = appendEntries(t, limited, fmt.Sprintf("entry%d", i))
+		disabledHead = appendEntries(t, disabled, fmt.Sprintf("entry%d", i))
+	}
+
+	// Only the entries 2 and 4 back are within reach
+	if len(limitedHead.Refs) != 2 {
+		t.Errorf("Expected 2 references, got %d", len(limitedHead.Refs))
+	}
+	if len(disabledHead.Refs) != 0 {
+		t.Errorf("Expected no references, got %d", len(disabledHead.Refs))
+	}
+}
+
+func TestLog_TraverseLongLog(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	log, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	const count = 100
+	for i := 0; i < count; i++ {
+		appendEntries(t, log, fmt.Sprintf("entry%d", i))
+	}
+
+	traversed, err := log.Traverse("", nil)
+	if err != nil {
+		t.Fatalf("Failed to traverse log: %v", err)
+	}
+
+	if len(traversed) != count {
+		t.Fatalf("Expected to traverse %d entries, got %d", count, len(traversed))
+	}
+	for i, entry := range traversed {
+		if expected := fmt.Sprintf("entry%d", count-1-i); entry.Payload != expected {
+			t.Errorf("Expected entry %d to be '%s', got '%s'", i, expected, entry.Payload)
+		}
+	}
+}
+
+// slowFetcher fetches from a storage with a delay and records how many fetches overlap.
+type slowFetcher struct {
+	storage storage.Storage
+	delay   time.Duration
+
+	mu            sync.Mutex
+	active        int
+	maxConcurrent int
+}
+
+func (f *slowFetcher) Fetch(ctx context.Context, hash string) ([]byte, error) {
+	f.mu.Lock()
+	f.active++
+	if f.active > f.maxConcurrent {
+		f.maxConcurrent = f.active
+	}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return f.storage.Get(hash)
+}
+
+func TestLog_JoinEntryPrefetchesInParallel(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+
+	const count = 40
+	var head *EncodedEntry
+	for i := 0; i < count; i++ {
+		head = appendEntries(t, log2, fmt.Sprintf("entry%d", i))
+	}
+
+	fetcher := &slowFetcher{storage: log2.Entries, delay: 5 * time.Millisecond}
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithFetcher(fetcher))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	if err := log1.JoinEntry(head, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+
+	entries, err := log1.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(entries) != count {
+		t.Fatalf("Expected %d entries after join, got %d", count, len(entries))
+	}
+
+	if fetcher.maxConcurrent < 2 {
+		t.Errorf("Expected entries to be fetched in parallel, got at most %d concurrent fetches", fetcher.maxConcurrent)
+	}
+}
+
+// identityAccessController allows the entries signed by the given identity hashes.
+type identityAccessController struct {
+	allowed map[string]bool
+}
+
+func (ac *identityAccessController) CanAppend(entry *EncodedEntry, identityProvider IdentityProvider) (bool, error) {
+	return ac.allowed[entry.Identity], nil
+}
+
+// staticIdentityProvider knows a fixed set of identities.
+type staticIdentityProvider map[string]*identitytypes.Identity
+
+func (p staticIdentityProvider) GetIdentity(hash string) (*identitytypes.Identity, error) {
+	return p[hash], nil
+}
+
+func (p staticIdentityProvider) VerifyIdentity(identity *identitytypes.Identity) bool {
+	return identity != nil
+}
+
+// invalidIdentityProvider knows a fixed set of identities, none of which are valid.
+type invalidIdentityProvider map[string]*identitytypes.Identity
+
+func (p invalidIdentityProvider) GetIdentity(hash string) (*identitytypes.Identity, error) {
+	return p[hash], nil
+}
+
+func (p invalidIdentityProvider) VerifyIdentity(identity *identitytypes.Identity) bool {
+	return false
+}
+
+func TestNewLogRequiresIdentityProviderWithAccessController(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	ac := &identityAccessController{allowed: map[string]bool{}}
+	if _, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks, WithAccessController(ac)); err == nil {
+		t.Fatal("Expected an error when no identity provider is given")
+	}
+}
+
+func TestLog_AppendDeniedByAccessController(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	ac := &identityAccessController{allowed: map[string]bool{}}
+	log, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks,
+		WithAccessController(ac), WithIdentityProvider(staticIdentityProvider{identity.Hash: identity}))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	if _, err := log.Append("denied"); err == nil {
+		t.Fatal("Expected append to be denied")
+	}
+	if clock := log.Clock(); clock.Time != 0 {
+		t.Errorf("Expected the clock to be unchanged after a denied append, got time %d", clock.Time)
+	}
+	if heads := log.Heads(); len(heads) != 0 {
+		t.Errorf("Expected no heads after a denied append, got %d", len(heads))
+	}
+
+	ac.allowed[identity.Hash] = true
+	entry, err := log.Append("allowed")
+	if err != nil {
+		t.Fatalf("Expected append to be allowed: %v", err)
+	}
+	if entry.Clock.Time != 1 {
+		t.Errorf("Expected the allowed entry to be written at time 1, got %d", entry.Clock.Time)
+	}
+}
+
+func TestLog_JoinEntryDeniedByAccessController(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	writer, err := providers.NewPublicKeyProvider(ks).CreateIdentity("writer")
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+
+	logID := "test-log"
+	remote, err := NewLog(logID, writer, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create remote log: %v", err)
+	}
+	head := appendEntries(t, remote, "entry1", "entry2")
+
+	fetcher, err := NewStorageFetcher(remote.Entries)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+
+	ac := &identityAccessController{allowed: map[string]bool{identity.Hash: true}}
+	provider := staticIdentityProvider{identity.Hash: identity, writer.Hash: writer}
+	log, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks,
+		WithFetcher(fetcher), WithAccessController(ac), WithIdentityProvider(provider))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	if err := log.JoinEntry(head, make(map[string]bool)); err == nil {
+		t.Fatal("Expected entries from a writer outside the access controller to be rejected")
+	}
+	if heads := log.Heads(); len(heads) != 0 {
+		t.Errorf("Expected no heads after a rejected join, got %d", len(heads))
+	}
+
+	ac.allowed[writer.Hash] = true
+	if err := log.JoinEntry(head, make(map[string]bool)); err != nil {
+		t.Fatalf("Expected the join to be allowed: %v", err)
+	}
+	if heads := log.Heads(); len(heads) != 1 || heads[0].Hash != head.Hash {
+		t.Errorf("Expected the joined entry to be the head, got %d heads", len(heads))
+	}
+}
+
+func TestLog_JoinEntryVerifiesIdentity(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	writer, err := providers.NewPublicKeyProvider(ks).CreateIdentity("writer")
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+
+	logID := "test-log"
+	remote, err := NewLog(logID, writer, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create remote log: %v", err)
+	}
+	head := appendEntries(t, remote, "entry1")
+
+	fetcher, err := NewStorageFetcher(remote.Entries)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+
+	provider := staticIdentityProvider{identity.Hash: identity}
+	log, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithFetcher(fetcher), WithIdentityProvider(provider))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	// The writer's identity cannot be resolved
+	if err := log.JoinEntry(head, make(map[string]bool)); err == nil {
+		t.Fatal("Expected an entry from an unknown identity to be rejected")
+	}
+
+	// The identity resolved for the hash has a different key than the one that signed the entry
+	provider[writer.Hash] = identity
+	if err := log.JoinEntry(head, make(map[string]bool)); err == nil {
+		t.Fatal("Expected an entry whose key does not belong to its identity to be rejected")
+	}
+
+	provider[writer.Hash] = writer
+	if err := log.JoinEntry(head, make(map[string]bool)); err != nil {
+		t.Fatalf("Expected the entry to be joined: %v", err)
+	}
+	if heads := log.Heads(); len(heads) != 1 || heads[0].Hash != head.Hash {
+		t.Errorf("Expected the joined entry to be the head, got %d heads", len(heads))
+	}
+}
+
+func TestLog_VerifyEntry(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	writer, err := providers.NewPublicKeyProvider(ks).CreateIdentity("writer")
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+
+	logID := "test-log"
+	remote, err := NewLog(logID, writer, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create remote log: %v", err)
+	}
+	head := appendEntries(t, remote, "entry1")
+
+	ac := &identityAccessController{allowed: map[string]bool{}}
+	provider := staticIdentityProvider{identity.Hash: identity, writer.Hash: writer}
+	log, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks, WithAccessController(ac), WithIdentityProvider(provider))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	if err := log.VerifyEntry(head); err == nil {
+		t.Fatal("Expected an entry from a writer outside the access controller to be rejected")
+	}
+
+	ac.allowed[writer.Hash] = true
+	if err := log.VerifyEntry(head); err != nil {
+		t.Fatalf("Expected the entry to be verified: %v", err)
+	}
+	if heads := log.Heads(); len(heads) != 0 {
+		t.Errorf("Expected verifying an entry not to add it, got %d heads", len(heads))
+	}
+
+	// An entry whose signature does not cover its content
+	tampered := Encode(head.Entry)
+	tampered.Payload = "forged"
+	tampered = Encode(tampered.Entry)
+	if err := log.VerifyEntry(&tampered); err == nil {
+		t.Fatal("Expected an entry with an invalid signature to be rejected")
+	}
+
+	// A correctly signed entry of another log
+	other := NewEntry(ks, writer, "other-log", "entry1", NewClock(writer.ID, 1), nil, nil)
+	if err := log.VerifyEntry(&other); err == nil {
+		t.Fatal("Expected an entry of another log to be rejected")
+	}
+}
+
+func TestLog_Has(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	log, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create new log: %v", err)
+	}
+
+	entry, err := log.Append("entry")
+	if err != nil {
+		t.Fatalf("Failed to append entry: %v", err)
+	}
+
+	if !log.Has(entry.Hash) {
+		t.Errorf("Expected log to have entry %s", entry.Hash)
+	}
+	if log.Has("zdpuUnknown") {
+		t.Error("Expected log not to have an unknown entry")
+	}
+}
+
+// payloadAccessController denies the entries with the given payload.
+type payloadAccessController struct {
+	denied string
+}
+
+func (ac *payloadAccessController) CanAppend(entry *EncodedEntry, identityProvider IdentityProvider) (bool, error) {
+	return entry.Payload != ac.denied, nil
+}
+
+func TestLog_AppendBatch(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	sequential, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	batched, err := NewLog("test-log", identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	payloads := make([]string, 40)
+	for i := range payloads {
+		payloads[i] = fmt.Sprintf("entry%d", i)
+	}
+
+	// Both logs start with the same entry
+	if _, err := sequential.Append("first"); err != nil {
+		t.Fatalf("Failed to append entry: %v", err)
+	}
+	if _, err := batched.Append("first"); err != nil {
+		t.Fatalf("Failed to append entry: %v", err)
+	}
+
+	var expected []*EncodedEntry
+	for _, payload := range payloads {
+		entry, err := sequential.Append(payload)
+		if err != nil {
+			t.Fatalf("Failed to append entry: %v", err)
+		}
+		expected = append(expected, entry)
+	}
+
+	entries, err := batched.AppendBatch(payloads)
+	if err != nil {
+		t.Fatalf("Failed to append batch: %v", err)
+	}
+	if len(entries) != len(payloads) {
+		t.Fatalf("Expected %d entries, got %d", len(payloads), len(entries))
+	}
+
+	// The batch links and references its entries like appending them one by one
+	for i, entry := range entries {
+		if entry.Payload != expected[i].Payload || entry.Clock != expected[i].Clock {
+			t.Errorf("Entry %d: expected payload %s at %v, got %s at %v",
+				i, expected[i].Payload, expected[i].Clock, entry.Payload, entry.Clock)
+		}
+		if len(entry.Refs) != len(expected[i].Refs) {
+			t.Errorf("Entry %d: expected %d references, got %d", i, len(expected[i].Refs), len(entry.Refs))
+		}
+		if i > 0 && (len(entry.Next) != 1 || entry.Next[0] != entries[i-1].Hash) {
+			t.Errorf("Entry %d: expected to point to the previous entry, got %v", i, entry.Next)
+		}
+	}
+
+	heads := batched.Heads()
+	if len(heads) != 1 || heads[0].Hash != entries[len(entries)-1].Hash {
+		t.Errorf("Expected the last entry of the batch to be the only head, got %v", heads)
+	}
+	if clock := batched.Clock(); clock != sequential.Clock() {
+		t.Errorf("Expected the clock to be at %v, got %v", sequential.Clock(), clock)
+	}
+
+	values, err := batched.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(values) != len(payloads)+1 {
+		t.Errorf("Expected %d entries in the log, got %d", len(payloads)+1, len(values))
+	}
+}
+
+func TestLog_AppendBatchIsAtomic(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	entryStorage := storage.NewMemoryStorage()
+	log, err := NewLog("test-log", identity, entryStorage, ks,
+		WithAccessController(&payloadAccessController{denied: "denied"}),
+		WithIdentityProvider(staticIdentityProvider{identity.Hash: identity}))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	head, err := log.Append("first")
+	if err != nil {
+		t.Fatalf("Failed to append entry: %v", err)
+	}
+
+	if _, err := log.AppendBatch([]string{"allowed", "denied", "allowed too"}); err == nil {
+		t.Fatal("Expected the batch to be denied")
+	}
+
+	// Nothing of the batch was stored
+	values, err := log.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(values) != 1 {
+		t.Errorf("Expected only the first entry in the log, got %d entries", len(values))
+	}
+	if heads := log.Heads(); len(heads) != 1 || heads[0].Hash != head.Hash {
+		t.Errorf("Expected the heads to be unchanged, got %v", heads)
+	}
+	if clock := log.Clock(); clock.Time != 1 {
+		t.Errorf("Expected the clock to be unchanged, got time %d", clock.Time)
+	}
+
+	if _, err := log.AppendBatch(nil); err == nil {
+		t.Error("Expected an empty batch to fail")
+	}
+}
+
+func TestLog_Context(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	head := appendEntries(t, log2, "entry1", "entry2")
+
+	if _, err := log2.GetContext(context.Background(), "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for a missing entry, got %v", err)
+	}
+
+	// Reads give up once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := log2.GetContext(ctx, head.Hash); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected GetContext to be canceled, got %v", err)
+	}
+	if _, err := log2.ValuesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected ValuesContext to be canceled, got %v", err)
+	}
+	if _, err := log2.TraverseContext(ctx, "", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected TraverseContext to be canceled, got %v", err)
+	}
+
+	// A join waiting on the fetcher is canceled before its timeout
+	log1, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks,
+		WithFetcher(blockingFetcher{}), WithJoinTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = log1.JoinEntryContext(ctx, head, make(map[string]bool))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected join to be canceled, got %v", err)
+	}
+	if log1.Has(head.Hash) {
+		t.Fatal("Expected nothing to be joined")
+	}
+}
+
+func TestLog_ValuesWithIPFSBlockStorage(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	dagService := merkledag.NewDAGService(blockservice.New(blockstore.NewBlockstore(ds), nil))
+	entryStorage, err := storage.NewIPFSBlockStorage(context.Background(), ds, dagService, true, storage.DefaultTimeout)
+	if err != nil {
+		t.Fatalf("Failed to create IPFS block storage: %v", err)
+	}
+
+	log, err := NewLog("test-log", identity, entryStorage, ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	appendEntries(t, log, "entry1", "entry2", "entry3")
+
+	// The entries of another log in the same blockstore are not part of the values
+	otherStorage, err := storage.NewIPFSBlockStorage(context.Background(), ds, dagService, true, storage.DefaultTimeout,
+		storage.WithNamespace("other-log"))
+	if err != nil {
+		t.Fatalf("Failed to create IPFS block storage: %v", err)
+	}
+	other, err := NewLog("other-log", identity, otherStorage, ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	appendEntries(t, other, "other1")
+
+	values, err := log.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(values) != 3 || values[0].Payload != "entry1" || values[2].Payload != "entry3" {
+		t.Fatalf("Expected the three entries in order, got %d entries", len(values))
+	}
+
+	if err := log.Clear(); err != nil {
+		t.Fatalf("Failed to clear log: %v", err)
+	}
+	if values, _ := log.Values(); len(values) != 0 {
+		t.Errorf("Expected no entries after clearing, got %d", len(values))
+	}
+}
+
+func TestLog_HeadPinning(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	ctx := context.Background()
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	dagService := merkledag.NewDAGService(blockservice.New(bs, nil))
+	newEntryStorage := func(name string) *storage.IPFSBlockStorage {
+		entryStorage, err := storage.NewIPFSBlockStorage(ctx, ds, dagService, true, storage.DefaultTimeout,
+			storage.WithHeadPinning(), storage.WithNamespace(name))
+		if err != nil {
+			t.Fatalf("Failed to create IPFS block storage: %v", err)
+		}
+		return entryStorage
+	}
+
+	// held reports how many of the entries are in the shared blockstore
+	held := func(entries []*EncodedEntry) int {
+		count := 0
+		for _, entry := range entries {
+			c, err := cid.Decode(entry.Hash)
+			if err != nil {
+				t.Fatalf("Failed to decode CID: %v", err)
+			}
+			if has, err := bs.Has(ctx, c); err == nil && has {
+				count++
+			}
+		}
+		return count
+	}
+
+	kept, err := NewLog("kept-log", identity, newEntryStorage("kept-log"), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	keptEntries := []*EncodedEntry{appendEntries(t, kept, "kept1"), appendEntries(t, kept, "kept2")}
+	batch, err := kept.AppendBatch([]string{"kept3", "kept4"})
+	if err != nil {
+		t.Fatalf("Failed to append batch: %v", err)
+	}
+	keptEntries = append(keptEntries, batch...)
+
+	// The dropped log is written, then reopened with a new storage instance
+	headsStorage := storage.NewMemoryStorage()
+	dropped, err := NewLog("dropped-log", identity, newEntryStorage("dropped-log"), ks, WithHeadsStorage(headsStorage))
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	droppedEntries := []*EncodedEntry{appendEntries(t, dropped, "dropped1"), appendEntries(t, dropped, "dropped2")}
+
+	reopenedStorage := newEntryStorage("dropped-log")
+	reopened, err := NewLog("dropped-log", identity, reopenedStorage, ks, WithHeadsStorage(headsStorage))
+	if err != nil {
+		t.Fatalf("Failed to reopen log: %v", err)
+	}
+
+	// The history of both logs is pinned through their heads
+	if err := reopenedStorage.GC(ctx); err != nil {
+		t.Fatalf("Failed to collect garbage: %v", err)
+	}
+	if count := held(keptEntries); count != len(keptEntries) {
+		t.Fatalf("Expected the %d entries of the kept log to be kept, got %d", len(keptEntries), count)
+	}
+	if count := held(droppedEntries); count != len(droppedEntries) {
+		t.Fatalf("Expected the %d entries of the reopened log to be kept, got %d", len(droppedEntries), count)
+	}
+
+	// Clearing the reopened log removes the entries written in its namespace and unpins its heads,
+	// so GC removes the entries fetched from peers
+	if err := reopened.Clear(); err != nil {
+		t.Fatalf("Failed to clear log: %v", err)
+	}
+	if err := reopenedStorage.GC(ctx); err != nil {
+		t.Fatalf("Failed to collect garbage: %v", err)
+	}
+
+	if count := held(droppedEntries); count != 0 {
+		t.Errorf("Expected the entries of the cleared log to be removed, %d are left", count)
+	}
+	if count := held(keptEntries); count != len(keptEntries) {
+		t.Errorf("Expected the %d entries of the kept log to be kept, got %d", len(keptEntries), count)
+	}
+}
+
+// collectingStorage is an IPFS block storage that starts GC as soon as a batch of entries is
+// committed, before the heads that link to them are pinned.
+type collectingStorage struct {
+	*storage.IPFSBlockStorage
+	collected chan error
+}
+
+func (s *collectingStorage) NewBatch() storage.Batch {
+	return &collectingBatch{Batch: s.IPFSBlockStorage.NewBatch(), storage: s}
+}
+
+type collectingBatch struct {
+	storage.Batch
+	storage *collectingStorage
+}
+
+// Commit commits the batch and gives GC time to run before the heads are pinned.
+func (b *collectingBatch) Commit() error {
+	if err := b.Batch.Commit(); err != nil {
+		return err
+	}
+	go func() {
+		b.storage.collected <- b.storage.GC(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+func TestLog_GCDuringJoin(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+	ctx := context.Background()
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage.NewMemoryStorage(), ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	appendEntries(t, log2, "entry1", "entry2")
+	head := appendEntries(t, log2, "entry3")
+
+	fetcher, err := NewStorageFetcher(log2.Entries)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	dagService := merkledag.NewDAGService(blockservice.New(blockstore.NewBlockstore(ds), nil))
+	blockStorage, err := storage.NewIPFSBlockStorage(ctx, ds, dagService, true, storage.DefaultTimeout, storage.WithHeadPinning())
+	if err != nil {
+		t.Fatalf("Failed to create IPFS block storage: %v", err)
+	}
+	entryStorage := &collectingStorage{IPFSBlockStorage: blockStorage, collected: make(chan error, 1)}
+	log1, err := NewLog(logID, identity, entryStorage, ks, WithFetcher(fetcher))
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+
+	if err := log1.JoinEntry(head, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+	select {
+	case err := <-entryStorage.collected:
+		if err != nil {
+			t.Fatalf("Failed to collect garbage: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for GC")
+	}
+
+	// GC waited for the joined entries to be pinned through the new head
+	values, err := log2.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	for _, entry := range values {
+		if has, err := blockStorage.Has(ctx, entry.Hash); err != nil || !has {
+			t.Errorf("Expected entry %s to be kept by GC", entry.Hash)
+		}
+	}
+}
+
+// newExchangeEntryStorage creates a loopback libp2p host and an IPFS block storage that
+// fetches missing blocks from the host's peers.
+func newExchangeEntryStorage(t *testing.T) (host.Host, *storage.IPFSBlockStorage) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create libp2p host: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	ex, err := storage.NewLibp2pExchange(h, blockstore.NewBlockstore(ds))
+	if err != nil {
+		t.Fatalf("Failed to create exchange: %v", err)
+	}
+	t.Cleanup(func() { ex.Close() })
+
+	dagService := merkledag.NewDAGService(blockservice.New(blockstore.NewBlockstore(ds), nil))
+	entryStorage, err := storage.NewIPFSBlockStorage(context.Background(), ds, dagService, true, 5*time.Second, storage.WithExchange(ex))
+	if err != nil {
+		t.Fatalf("Failed to create IPFS block storage: %v", err)
+	}
+	return h, entryStorage
+}
+
+func TestLog_JoinEntryFetchesFromPeers(t *testing.T) {
+	ks, identity := setupTestKeyStoreAndIdentity(t)
+
+	host1, storage1 := newExchangeEntryStorage(t)
+	host2, storage2 := newExchangeEntryStorage(t)
+	if err := host1.Connect(context.Background(), peer.AddrInfo{ID: host2.ID(), Addrs: host2.Addrs()}); err != nil {
+		t.Fatalf("Failed to connect hosts: %v", err)
+	}
+
+	logID := "test-log"
+	log2, err := NewLog(logID, identity, storage2, ks)
+	if err != nil {
+		t.Fatalf("Failed to create log2: %v", err)
+	}
+	head := appendEntries(t, log2, "entry1", "entry2", "entry3")
+
+	// log1 only has the head; the rest of the history is fetched from host2 through its storage
+	log1, err := NewLog(logID, identity, storage1, ks)
+	if err != nil {
+		t.Fatalf("Failed to create log1: %v", err)
+	}
+	if err := log1.JoinEntry(head, make(map[string]bool)); err != nil {
+		t.Fatalf("Failed to join entry: %v", err)
+	}
+
+	values, err := log1.Values()
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(values) != 3 || values[0].Payload != "entry1" || values[2].Payload != "entry3" {
+		t.Fatalf("Expected the three entries in order, got %d entries", len(values))
 	}
 }
@@ -0,0 +1,85 @@
+package oplog
+
+import (
+	"errors"
+	"sync"
+)
+
+// prefetchConcurrency is the number of entries loaded in parallel while traversing or joining.
+const prefetchConcurrency = 16
+
+var errPrefetcherStopped = errors.New("prefetcher stopped")
+
+// prefetcher loads entries in the background so that a traversal does not wait on
+// each storage lookup in turn. Loads that have not started are abandoned once the
+// prefetcher is stopped.
+type prefetcher struct {
+	load    func(hash string) (*EncodedEntry, error)
+	sem     chan struct{}
+	stop    chan struct{}
+	mu      sync.Mutex
+	pending map[string]*prefetch
+}
+
+// prefetch is the result of a background load.
+type prefetch struct {
+	done  chan struct{}
+	entry *EncodedEntry
+	err   error
+}
+
+func newPrefetcher(load func(hash string) (*EncodedEntry, error)) *prefetcher {
+	return &prefetcher{
+		load:    load,
+		sem:     make(chan struct{}, prefetchConcurrency),
+		stop:    make(chan struct{}),
+		pending: make(map[string]*prefetch),
+	}
+}
+
+// prefetch starts loading the entries that have not been requested yet.
+func (p *prefetcher) prefetch(hashes ...string) {
+	for _, hash := range hashes {
+		p.start(hash)
+	}
+}
+
+// get returns the entry, waiting for it to be loaded.
+func (p *prefetcher) get(hash string) (*EncodedEntry, error) {
+	pf := p.start(hash)
+	<-pf.done
+	return pf.entry, pf.err
+}
+
+// close abandons the loads that have not started.
+func (p *prefetcher) close() {
+	close(p.stop)
+}
+
+func (p *prefetcher) start(hash string) *prefetch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pf, exists := p.pending[hash]; exists {
+		return pf
+	}
+
+	pf := &prefetch{done: make(chan struct{})}
+	p.pending[hash] = pf
+
+	go func() {
+		defer close(pf.done)
+
+		select {
+		case p.sem <- struct{}{}:
+			defer func() { <-p.sem }()
+		case <-p.stop:
+			pf.err = errPrefetcherStopped
+			return
+		}
+
+		pf.entry, pf.err = p.load(hash)
+	}()
+
+	return pf
+}
@@ -0,0 +1,72 @@
+package oplog
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetcher_LoadsEachEntryOnce(t *testing.T) {
+	var mu sync.Mutex
+	loads := make(map[string]int)
+
+	p := newPrefetcher(func(hash string) (*EncodedEntry, error) {
+		mu.Lock()
+		loads[hash]++
+		mu.Unlock()
+		return &EncodedEntry{Hash: hash}, nil
+	})
+	defer p.close()
+
+	p.prefetch("a", "b", "a")
+
+	entry, err := p.get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", entry.Hash)
+
+	entry, err = p.get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "b", entry.Hash)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, loads["a"])
+	assert.Equal(t, 1, loads["b"])
+}
+
+func TestPrefetcher_ReturnsLoadError(t *testing.T) {
+	p := newPrefetcher(func(hash string) (*EncodedEntry, error) {
+		return nil, errors.New("not found")
+	})
+	defer p.close()
+
+	_, err := p.get("missing")
+	assert.Error(t, err)
+}
+
+func TestPrefetcher_CloseAbandonsPendingLoads(t *testing.T) {
+	release := make(chan struct{})
+	p := newPrefetcher(nil)
+
+	// Occupy every slot so that the next load has to wait
+	started := make(chan struct{}, prefetchConcurrency)
+	p.load = func(hash string) (*EncodedEntry, error) {
+		started <- struct{}{}
+		<-release
+		return &EncodedEntry{Hash: hash}, nil
+	}
+	for i := 0; i < prefetchConcurrency; i++ {
+		p.prefetch(string(rune('a' + i)))
+	}
+	for i := 0; i < prefetchConcurrency; i++ {
+		<-started
+	}
+	p.close()
+
+	_, err := p.get("waiting")
+	assert.ErrorIs(t, err, errPrefetcherStopped)
+	close(release)
+}
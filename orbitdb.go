@@ -1,58 +1,516 @@
-package main
+package orbitdb
 
 import (
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-datastore"
+	format "github.com/ipfs/go-ipld-format"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"io"
+	"orbitdb/go-orbitdb/accesscontrollers"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/identities"
 	"orbitdb/go-orbitdb/identities/identitytypes"
-	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+	"path/filepath"
+	"sync"
 )
 
-func main() {
-	// Define the same entry data as in JavaScript
-	entry := oplog.Entry{
-		ID:        "test-log-id",
-		Payload:   "hello world",
-		Next:      []string{},
-		Refs:      []string{},
-		Clock:     oplog.Clock{ID: "test-user-id", Time: 0},
-		V:         2,
-		Key:       "test-public-key",
-		Identity:  "test-identity-hash",
-		Signature: "test-signature",
-	}
-
-	// Encode the entry using your Encode function
-	encodedEntry := oplog.Encode(entry)
-	fmt.Println("CID in Go:", encodedEntry.CID)
-	// Output the bytes in hex format for easy comparison
-	fmt.Println("CBOR Encoded Bytes in Go:", hex.EncodeToString(encodedEntry.Bytes))
-
-	// Define the test identity details
-	id := "0x01234567890abcdefghijklmnopqrstuvwxyz"
-	publicKey := "<pubkey>"
-	signatures := map[string]string{
-		"id":        "signature for <id>",
-		"publicKey": "signature for <publicKey + idSignature>",
-	}
-	idType := "orbitdb"
-
-	// Create an Identity struct without a PrivateKey
-	testIdentity := identitytypes.Identity{
-		ID:         id,
-		PublicKey:  publicKey,
-		Signatures: signatures,
-		Type:       idType,
-	}
-
-	// Encode the identity to get the CBOR-encoded bytes and CID hash
-	hash, encodedBytes, err := identitytypes.EncodeIdentity(testIdentity)
-	if err != nil {
-		log.Fatalf("Error encoding identity: %v", err)
-	}
-
-	// Display the encoded values
-	fmt.Printf("Encoded Hash: %s\n", hash)
-	fmt.Printf("Encoded Bytes (CBOR): %x\n", encodedBytes)
-	// expected hash: 'zdpuArx43BnXdDff5rjrGLYrxUomxNroc2uaocTgcWK76UfQT'
+// DefaultDirectory is the directory OrbitDB stores its data in when none is given.
+const DefaultDirectory = "./orbitdb"
+
+// DefaultDatabaseType is the database type used when OpenOptions does not specify one.
+const DefaultDatabaseType = "events"
+
+// Store is implemented by every database type that can be opened with OrbitDB.
+type Store interface {
+	Close() error
+	Drop() error
+}
+
+// Options configures a new OrbitDB instance.
+type Options struct {
+	ID         string                  // ID of the identity to create; defaults to the host's peer ID
+	Identity   *identitytypes.Identity // Identity to use instead of creating one
+	Identities *identities.Identities  // Identities manager holding the keys of Identity
+	Directory  string                  // Directory for the keystore and database storage
+
+	// ManifestStorage is the block storage for database manifests; defaults to LevelDB in Directory
+	ManifestStorage storage.Storage
+
+	// Datastore keeps log entries, manifests and identities as IPFS blocks when set, so that
+	// the blocks missing locally are fetched from peers through Exchange. Without it, they are
+	// kept in LevelDB in Directory and only what was written or replicated locally can be read.
+	// Storages set in the options are used as they are.
+	Datastore datastore.Batching
+
+	// Exchange fetches the blocks missing from Datastore; defaults to a storage.Libp2pExchange
+	// over the host. An exchange given here is not closed when OrbitDB stops.
+	Exchange exchange.Interface
+}
+
+// OpenOptions configures a database opened with OrbitDB.Open. Meta values may be strings,
+// booleans, numbers, []byte, nil, and slices and string-keyed maps of these; Open fails with
+// an error naming the type of any other value.
+type OpenOptions struct {
+	Type    string                 // Database type: "events", "keyvalue" or "documents"
+	Meta    map[string]interface{} // Application specific metadata stored in the manifest
+	Storage storage.Storage        // Storage for log entries, closed with the database; defaults to LevelDB in the OrbitDB directory
+	IndexBy string                 // Field to index documents by (documents only)
+
+	// SyncMode decides whether and when the database replicates; defaults to databases.SyncAutomatic
+	SyncMode databases.SyncMode
+
+	// AccessController creates the access controller of a new database; defaults to an
+	// IPFS access controller that only allows this instance's identity to write
+	AccessController accesscontrollers.Factory
+}
+
+// OrbitDB manages an identity, its keystore and the databases opened by this node.
+type OrbitDB struct {
+	Identity            *identitytypes.Identity
+	Identities          *identities.Identities
+	Directory           string
+	host                host.Host
+	pubsub              *pubsub.PubSub
+	keyStorage          storage.Storage // Keystore storage owned by this instance, nil if provided by the caller
+	manifests           *ManifestStore
+	ownsManifestStorage bool
+	datastore           datastore.Batching // Datastore of the block storages, nil to use LevelDB
+	dagService          format.DAGService  // DAG service the block storages pin with
+	exchange            exchange.Interface // Exchange the block storages fetch missing blocks with
+	ownedExchange       io.Closer          // Exchange created by this instance, closed on Stop
+	databases           map[string]Store   // Open databases by address
+	mu                  sync.Mutex
+}
+
+// NewOrbitDB creates a new OrbitDB instance on top of the given libp2p host and pubsub.
+func NewOrbitDB(host host.Host, ps *pubsub.PubSub, options *Options) (*OrbitDB, error) {
+	if host == nil || ps == nil {
+		return nil, errors.New("host and pubsub instances are required")
+	}
+	if options == nil {
+		options = &Options{}
+	}
+
+	directory := options.Directory
+	if directory == "" {
+		directory = DefaultDirectory
+	}
+
+	odb := &OrbitDB{
+		Identities: options.Identities,
+		Directory:  directory,
+		host:       host,
+		pubsub:     ps,
+		databases:  make(map[string]Store),
+	}
+
+	if options.Datastore != nil {
+		if err := odb.setupBlocks(options); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create an Identities manager with a persistent keystore if none was provided
+	if odb.Identities == nil {
+		if options.Identity != nil {
+			return nil, errors.New("identities are required when an identity is provided")
+		}
+
+		keyStorage, err := storage.NewLevelStorage(filepath.Join(directory, "keystore"))
+		if err != nil {
+			odb.closeExchange()
+			return nil, fmt.Errorf("failed to open keystore storage: %w", err)
+		}
+
+		identityOptions := []identities.Option{identities.WithDirectory(directory)}
+		if odb.datastore != nil {
+			identityStorage, err := odb.newBlockStorage("identities")
+			if err != nil {
+				keyStorage.Close()
+				odb.closeExchange()
+				return nil, err
+			}
+			identityOptions = append(identityOptions, identities.WithIdentityStorage(identityStorage))
+		}
+
+		ids, err := identities.NewIdentities("publickey", keyStorage, identityOptions...)
+		if err != nil {
+			keyStorage.Close()
+			odb.closeExchange()
+			return nil, fmt.Errorf("failed to create identities: %w", err)
+		}
+
+		odb.Identities = ids
+		odb.keyStorage = keyStorage
+	}
+
+	odb.Identity = options.Identity
+	if odb.Identity == nil {
+		id := options.ID
+		if id == "" {
+			id = host.ID().String()
+		}
+
+		identity, err := odb.Identities.CreateIdentity(id)
+		if err != nil {
+			odb.closeIdentities()
+			odb.closeExchange()
+			return nil, fmt.Errorf("failed to create identity: %w", err)
+		}
+		odb.Identity = identity
+	}
+
+	manifestStorage := options.ManifestStorage
+	if manifestStorage == nil {
+		var err error
+		if odb.datastore != nil {
+			manifestStorage, err = odb.newBlockStorage("manifests")
+		} else {
+			manifestStorage, err = newDefaultManifestStorage(directory)
+		}
+		if err != nil {
+			odb.closeIdentities()
+			odb.closeExchange()
+			return nil, err
+		}
+		odb.ownsManifestStorage = true
+	}
+
+	manifests, err := NewManifestStore(manifestStorage)
+	if err != nil {
+		odb.closeIdentities()
+		odb.closeExchange()
+		return nil, err
+	}
+	odb.manifests = manifests
+
+	return odb, nil
+}
+
+// setupBlocks prepares the datastore of the options for the block storages, along with the
+// exchange they fetch missing blocks with.
+func (odb *OrbitDB) setupBlocks(options *Options) error {
+	bs := blockstore.NewBlockstore(options.Datastore)
+
+	ex := options.Exchange
+	if ex == nil {
+		libp2pExchange, err := storage.NewLibp2pExchange(odb.host, bs)
+		if err != nil {
+			return fmt.Errorf("failed to create block exchange: %w", err)
+		}
+		ex = libp2pExchange
+		odb.ownedExchange = libp2pExchange
+	}
+
+	odb.datastore = options.Datastore
+	odb.dagService = merkledag.NewDAGService(blockservice.New(bs, nil))
+	odb.exchange = ex
+	return nil
+}
+
+// newBlockStorage creates a storage for the blocks written in the namespace of the datastore.
+// The blocks are pinned as they are stored, unless the options pin them through their heads.
+func (odb *OrbitDB) newBlockStorage(namespace string, options ...storage.IPFSBlockStorageOption) (*storage.IPFSBlockStorage, error) {
+	options = append([]storage.IPFSBlockStorageOption{storage.WithExchange(odb.exchange), storage.WithNamespace(namespace)}, options...)
+	blockStorage, err := storage.NewIPFSBlockStorage(context.Background(), odb.datastore, odb.dagService, true, storage.DefaultTimeout, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s block storage: %w", namespace, err)
+	}
+	return blockStorage, nil
+}
+
+// newDefaultManifestStorage creates an LRU cached LevelDB storage for manifests.
+func newDefaultManifestStorage(directory string) (storage.Storage, error) {
+	lruStorage, err := storage.NewLRUStorage(1000)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest cache: %w", err)
+	}
+
+	levelStorage, err := storage.NewLevelStorage(filepath.Join(directory, "manifests"))
+	if err != nil {
+		lruStorage.Close()
+		return nil, fmt.Errorf("failed to open manifest storage: %w", err)
+	}
+
+	return storage.NewComposedStorage(lruStorage, levelStorage)
+}
+
+// Open opens the database with the given name or address, creating it if it doesn't exist.
+// When an /orbitdb/<hash> address is given the database type is read from its manifest.
+// Opening an address that is already open returns the existing instance.
+func (odb *OrbitDB) Open(nameOrAddress string, options *OpenOptions) (Store, error) {
+	if nameOrAddress == "" {
+		return nil, errors.New("database name or address is required")
+	}
+	if options == nil {
+		options = &OpenOptions{}
+	}
+
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+
+	if odb.databases == nil {
+		return nil, errors.New("orbitdb instance has been stopped")
+	}
+
+	// Return an open database before its access controller is loaded again
+	if address, err := ParseAddress(nameOrAddress); err == nil {
+		if db, exists := odb.databases[address.String()]; exists {
+			manifest, err := odb.manifests.Get(address.Hash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve database address %s: %w", address, err)
+			}
+			if err := checkManifestType(manifest, options); err != nil {
+				return nil, err
+			}
+			return db, nil
+		}
+	}
+
+	manifest, access, err := odb.resolveManifest(nameOrAddress, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// A name only resolves to its address once the access controller has been created
+	address := Address{Protocol: AddressProtocol, Hash: manifest.Hash}.String()
+	if db, exists := odb.databases[address]; exists {
+		closeAccessController(access)
+		return db, nil
+	}
+
+	dbType := manifest.Type
+
+	entryStorage := options.Storage
+	if entryStorage == nil && odb.datastore != nil {
+		// The history of the log is kept through pins on its heads, so dropping it frees the blocks
+		blockStorage, err := odb.newBlockStorage(address, storage.WithHeadPinning())
+		if err != nil {
+			return nil, err
+		}
+		entryStorage = blockStorage
+	} else if entryStorage == nil {
+		levelStorage, err := storage.NewLevelStorage(filepath.Join(odb.Directory, address, "log"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open entry storage: %w", err)
+		}
+		entryStorage = levelStorage
+	}
+
+	// The entry storage is closed by createDatabase if it fails
+	db, base, err := odb.createDatabase(dbType, address, manifest.Name, entryStorage, access, options)
+	if err != nil {
+		closeAccessController(access)
+		return nil, err
+	}
+
+	for k, v := range manifest.Meta {
+		base.Meta[k] = v
+	}
+
+	// Forget the database once it is closed so it can be opened again
+	base.OnClose(func() {
+		closeAccessController(access)
+
+		odb.mu.Lock()
+		defer odb.mu.Unlock()
+		if odb.databases[address] == db {
+			delete(odb.databases, address)
+		}
+	})
+
+	odb.databases[address] = db
+	return db, nil
+}
+
+// resolveManifest fetches the manifest of an existing address or creates one for a new database name,
+// along with the database's access controller.
+func (odb *OrbitDB) resolveManifest(nameOrAddress string, options *OpenOptions) (*EncodedManifest, accesscontrollers.AccessController, error) {
+	params := accesscontrollers.Params{
+		Identity:         odb.Identity,
+		Storage:          odb.manifests.storage,
+		Name:             nameOrAddress,
+		IdentityProvider: odb.Identities,
+		KeyStore:         odb.Identities.KeyStore(),
+		Host:             odb.host,
+		PubSub:           odb.pubsub,
+		Directory:        odb.Directory,
+		DatabaseOptions:  []databases.Option{databases.WithSyncMode(options.SyncMode)},
+	}
+
+	if IsValidAddress(nameOrAddress) {
+		address, err := ParseAddress(nameOrAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		manifest, err := odb.manifests.Get(address.Hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve database address %s: %w", address, err)
+		}
+
+		if err := checkManifestType(manifest, options); err != nil {
+			return nil, nil, err
+		}
+
+		// Databases created without an access controller accept every writer
+		if manifest.AccessController == "" {
+			return manifest, nil, nil
+		}
+
+		params.Name = manifest.Name
+
+		access, err := accesscontrollers.Load(manifest.AccessController, params)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load access controller of %s: %w", address, err)
+		}
+		return manifest, access, nil
+	}
+
+	dbType := options.Type
+	if dbType == "" {
+		dbType = DefaultDatabaseType
+	}
+
+	factory := options.AccessController
+	if factory == nil {
+		factory = accesscontrollers.IPFSAccessController()
+	}
+
+	access, err := factory(params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create access controller: %w", err)
+	}
+
+	manifest, err := odb.manifests.Create(nameOrAddress, dbType, access.Address(), options.Meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	return manifest, access, nil
+}
+
+// checkManifestType fails if the options ask for another type than the manifest's.
+func checkManifestType(manifest *EncodedManifest, options *OpenOptions) error {
+	if options.Type != "" && options.Type != manifest.Type {
+		address := Address{Protocol: AddressProtocol, Hash: manifest.Hash}
+		return fmt.Errorf("database %s is of type '%s', not '%s'", address, manifest.Type, options.Type)
+	}
+	return nil
+}
+
+// closeAccessController releases the resources of access controllers that keep their own state.
+func closeAccessController(access accesscontrollers.AccessController) {
+	if closer, ok := access.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Printf("Warning: failed to close access controller %s: %v\n", access.Address(), err)
+		}
+	}
+}
+
+// createDatabase constructs a database of the given type along with its base Database. The
+// database takes over the entry storage, which is closed if the database cannot be created.
+func (odb *OrbitDB) createDatabase(dbType, address, name string, entryStorage storage.Storage, access accesscontrollers.AccessController, options *OpenOptions) (Store, *databases.Database, error) {
+	keyStore := odb.Identities.KeyStore()
+
+	dbOptions := []databases.Option{
+		databases.WithIdentityProvider(odb.Identities),
+		databases.WithSyncMode(options.SyncMode),
+	}
+	if access != nil {
+		dbOptions = append(dbOptions, databases.WithAccessController(access))
+	}
+
+	switch dbType {
+	case "events":
+		base, err := databases.NewDatabase(address, name, odb.Identity, entryStorage, keyStore, odb.host, odb.pubsub, dbOptions...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create events database: %w", err)
+		}
+		return databases.NewEvents(base), base, nil
+	case "keyvalue":
+		kv, err := databases.NewKeyValue(address, name, odb.Identity, entryStorage, keyStore, odb.host, odb.pubsub, dbOptions...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create keyvalue database: %w", err)
+		}
+		return kv, kv.Database, nil
+	case "documents":
+		kv, err := databases.NewKeyValue(address, name, odb.Identity, entryStorage, keyStore, odb.host, odb.pubsub, dbOptions...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create documents database: %w", err)
+		}
+		docs, err := databases.NewDocuments(options.IndexBy, kv)
+		if err != nil {
+			kv.Close()
+			return nil, nil, fmt.Errorf("failed to create documents database: %w", err)
+		}
+		return docs, kv.Database, nil
+	default:
+		entryStorage.Close()
+		return nil, nil, fmt.Errorf("unsupported database type '%s'", dbType)
+	}
+}
+
+// Stop closes every open database and releases the resources held by OrbitDB.
+func (odb *OrbitDB) Stop() error {
+	// Stop opening databases before closing the open ones, so that none is left open
+	odb.mu.Lock()
+	open := make([]Store, 0, len(odb.databases))
+	for _, db := range odb.databases {
+		open = append(open, db)
+	}
+	odb.databases = nil
+	ownsManifestStorage := odb.ownsManifestStorage
+	odb.ownsManifestStorage = false
+	odb.mu.Unlock()
+
+	var errs []error
+	for _, db := range open {
+		if err := db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ownsManifestStorage {
+		if err := odb.manifests.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := odb.closeIdentities(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := odb.closeExchange(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeIdentities closes the identities and their keystore storage if they were created by this instance.
+func (odb *OrbitDB) closeIdentities() error {
+	if odb.keyStorage == nil {
+		return nil
+	}
+	err := errors.Join(odb.Identities.Close(), odb.keyStorage.Close())
+	odb.keyStorage = nil
+	return err
+}
+
+// closeExchange closes the block exchange if it was created by this instance.
+func (odb *OrbitDB) closeExchange() error {
+	if odb.ownedExchange == nil {
+		return nil
+	}
+	err := odb.ownedExchange.Close()
+	odb.ownedExchange = nil
+	return err
 }
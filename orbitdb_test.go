@@ -0,0 +1,384 @@
+package orbitdb_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	orbitdb "orbitdb/go-orbitdb"
+	"orbitdb/go-orbitdb/accesscontrollers"
+	"orbitdb/go-orbitdb/databases"
+	"orbitdb/go-orbitdb/storage"
+)
+
+// setupOrbitDB creates an OrbitDB instance backed by a fresh libp2p host and a temporary directory.
+func setupOrbitDB(t *testing.T) *orbitdb.OrbitDB {
+	h, err := libp2p.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+
+	ps, err := pubsub.NewGossipSub(context.Background(), h)
+	require.NoError(t, err)
+
+	odb, err := orbitdb.NewOrbitDB(h, ps, &orbitdb.Options{Directory: t.TempDir()})
+	require.NoError(t, err)
+	return odb
+}
+
+func TestNewOrbitDB(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	require.NotNil(t, odb.Identity)
+	require.NotNil(t, odb.Identities)
+	assert.True(t, odb.Identities.VerifyIdentity(odb.Identity))
+}
+
+func TestNewOrbitDBRequiresHostAndPubSub(t *testing.T) {
+	var h host.Host
+	_, err := orbitdb.NewOrbitDB(h, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestOpenKeyValue(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db, err := odb.Open("my-keyvalue", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+
+	kv, ok := db.(*databases.KeyValue)
+	require.True(t, ok, "Expected a KeyValue database")
+
+	_, err = kv.Put("key1", "value1")
+	require.NoError(t, err)
+
+	value, err := kv.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+}
+
+func TestOpenDefaultsToEvents(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db, err := odb.Open("my-events", nil)
+	require.NoError(t, err)
+
+	_, ok := db.(*databases.Events)
+	assert.True(t, ok, "Expected an Events database")
+}
+
+// countingStorage is a storage that counts how often it is closed.
+type countingStorage struct {
+	storage.Storage
+	closes int
+}
+
+func (s *countingStorage) Close() error {
+	s.closes++
+	return s.Storage.Close()
+}
+
+func TestOpenUnsupportedType(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	// The entry storage is closed once when the database cannot be created
+	entryStorage := &countingStorage{Storage: storage.NewMemoryStorage()}
+	_, err := odb.Open("my-db", &orbitdb.OpenOptions{Type: "unknown", Storage: entryStorage})
+	assert.Error(t, err)
+	assert.Equal(t, 1, entryStorage.closes)
+}
+
+func TestOpenByAddress(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db, err := odb.Open("by-address", &orbitdb.OpenOptions{Type: "keyvalue", Meta: map[string]interface{}{"owner": "test"}})
+	require.NoError(t, err)
+
+	kv := db.(*databases.KeyValue)
+	assert.True(t, orbitdb.IsValidAddress(kv.Address), "Expected an /orbitdb/<hash> address, got %s", kv.Address)
+	assert.Equal(t, "by-address", kv.Name)
+	assert.Equal(t, kv.Address, kv.Log.ID)
+	assert.Equal(t, "test", kv.Meta["owner"])
+
+	_, err = kv.Put("key1", "value1")
+	require.NoError(t, err)
+	address := kv.Address
+	require.NoError(t, kv.Close())
+
+	// The database type is read from the manifest
+	reopened, err := odb.Open(address, nil)
+	require.NoError(t, err)
+
+	kv2, ok := reopened.(*databases.KeyValue)
+	require.True(t, ok, "Expected a KeyValue database from the manifest type")
+	assert.Equal(t, address, kv2.Address)
+	assert.Equal(t, "by-address", kv2.Name)
+
+	value, err := kv2.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+}
+
+func TestOpenByAddressTypeMismatch(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db, err := odb.Open("typed", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+	address := db.(*databases.KeyValue).Address
+
+	_, err = odb.Open(address, &orbitdb.OpenOptions{Type: "events"})
+	assert.Error(t, err)
+}
+
+func TestOpenUnknownAddress(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	_, err := odb.Open("/orbitdb/"+testManifestHash, nil)
+	assert.Error(t, err)
+}
+
+func TestOpenTwiceReturnsSameInstance(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db1, err := odb.Open("shared", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+
+	db2, err := odb.Open("shared", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+
+	assert.Same(t, db1, db2)
+
+	// Opening by address returns the same instance as opening by name
+	db3, err := odb.Open(db1.(*databases.KeyValue).Address, nil)
+	require.NoError(t, err)
+	assert.Same(t, db1, db3)
+}
+
+// closingAccess is an access controller that records whether it has been closed.
+type closingAccess struct {
+	accesscontrollers.AccessController
+	closed bool
+}
+
+func (c *closingAccess) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestOpenTwiceClosesUnusedAccessController(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	var created []*closingAccess
+	factory := func(params accesscontrollers.Params) (accesscontrollers.AccessController, error) {
+		access, err := accesscontrollers.IPFSAccessController()(params)
+		if err != nil {
+			return nil, err
+		}
+		c := &closingAccess{AccessController: access}
+		created = append(created, c)
+		return c, nil
+	}
+
+	db1, err := odb.Open("shared", &orbitdb.OpenOptions{Type: "keyvalue", AccessController: factory})
+	require.NoError(t, err)
+	db2, err := odb.Open("shared", &orbitdb.OpenOptions{Type: "keyvalue", AccessController: factory})
+	require.NoError(t, err)
+	assert.Same(t, db1, db2)
+
+	// The controller created for the second open is not used, so it is closed right away
+	require.Len(t, created, 2)
+	assert.False(t, created[0].closed, "The controller of the open database should stay open")
+	assert.True(t, created[1].closed, "The unused controller should be closed")
+
+	// Opening by address doesn't create a controller at all
+	_, err = odb.Open(db1.(*databases.KeyValue).Address, nil)
+	require.NoError(t, err)
+	assert.Len(t, created, 2)
+}
+
+func TestReopenAfterClose(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db1, err := odb.Open("reopen", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+	_, err = db1.(*databases.KeyValue).Put("key1", "value1")
+	require.NoError(t, err)
+	require.NoError(t, db1.Close())
+
+	db2, err := odb.Open("reopen", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+	assert.NotSame(t, db1, db2)
+
+	value, err := db2.(*databases.KeyValue).Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+}
+
+func TestStopClosesDatabases(t *testing.T) {
+	odb := setupOrbitDB(t)
+
+	db, err := odb.Open("closing", &orbitdb.OpenOptions{Type: "events"})
+	require.NoError(t, err)
+	events := db.(*databases.Events).Subscribe(context.Background(), nil)
+
+	require.NoError(t, odb.Stop())
+
+	assert.Equal(t, databases.CloseEvent{}, <-events)
+	_, ok := <-events
+	assert.False(t, ok, "Subscription should be closed")
+
+	_, err = odb.Open("closing", nil)
+	assert.Error(t, err)
+
+	assert.NoError(t, odb.Stop(), "Expected stopping again to release nothing twice")
+}
+
+func TestOpenCreatesAccessController(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db, err := odb.Open("guarded", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+	kv := db.(*databases.KeyValue)
+
+	access, ok := kv.AccessController.(accesscontrollers.AccessController)
+	require.True(t, ok, "Expected the database to have an access controller")
+	assert.Equal(t, accesscontrollers.IPFSAccessControllerType, access.Type())
+	assert.True(t, strings.HasPrefix(access.Address(), "/ipfs/"), "Unexpected address %s", access.Address())
+
+	// The identity that created the database is allowed to write
+	_, err = kv.Put("key1", "value1")
+	require.NoError(t, err)
+}
+
+func TestOpenWithAccessControllerByAddress(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db, err := odb.Open("open-to-all", &orbitdb.OpenOptions{
+		Type:             "keyvalue",
+		AccessController: accesscontrollers.IPFSAccessController(accesscontrollers.Wildcard),
+	})
+	require.NoError(t, err)
+	kv := db.(*databases.KeyValue)
+	acAddress := kv.AccessController.(accesscontrollers.AccessController).Address()
+	address := kv.Address
+	require.NoError(t, kv.Close())
+
+	// The write list is part of the manifest, so another write list is another database
+	other, err := odb.Open("open-to-all", &orbitdb.OpenOptions{Type: "keyvalue"})
+	require.NoError(t, err)
+	assert.NotEqual(t, address, other.(*databases.KeyValue).Address)
+
+	// Opening by address loads the access controller from the manifest
+	reopened, err := odb.Open(address, nil)
+	require.NoError(t, err)
+	access := reopened.(*databases.KeyValue).AccessController.(accesscontrollers.AccessController)
+	assert.Equal(t, acAddress, access.Address())
+}
+
+func TestOpenWithOrbitDBAccessController(t *testing.T) {
+	odb := setupOrbitDB(t)
+	defer odb.Stop()
+
+	db, err := odb.Open("mutable", &orbitdb.OpenOptions{
+		Type:             "keyvalue",
+		AccessController: accesscontrollers.OrbitDBAccessController(),
+	})
+	require.NoError(t, err)
+	kv := db.(*databases.KeyValue)
+
+	access, ok := kv.AccessController.(*accesscontrollers.OrbitDBController)
+	require.True(t, ok, "Expected an OrbitDB access controller")
+	require.NoError(t, access.Grant(accesscontrollers.CapabilityWrite, "other-writer"))
+
+	_, err = kv.Put("key1", "value1")
+	require.NoError(t, err)
+
+	address := kv.Address
+	require.NoError(t, kv.Close())
+
+	// Capabilities are stored in the controller's own log and loaded again by address
+	reopened, err := odb.Open(address, nil)
+	require.NoError(t, err)
+	access = reopened.(*databases.KeyValue).AccessController.(*accesscontrollers.OrbitDBController)
+	assert.Equal(t, []string{"other-writer"}, access.Capabilities()[accesscontrollers.CapabilityWrite])
+}
+
+// setupBlockOrbitDB creates an OrbitDB instance that keeps its blocks in a memory datastore
+// and fetches missing blocks from the peers of its loopback host.
+func setupBlockOrbitDB(t *testing.T) (host.Host, *orbitdb.OrbitDB) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+
+	ps, err := pubsub.NewGossipSub(context.Background(), h)
+	require.NoError(t, err)
+
+	odb, err := orbitdb.NewOrbitDB(h, ps, &orbitdb.Options{
+		Directory: t.TempDir(),
+		Datastore: dssync.MutexWrap(datastore.NewMapDatastore()),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = odb.Stop()
+	})
+	return h, odb
+}
+
+func TestOpenFetchesMissingBlocksFromPeers(t *testing.T) {
+	host1, odb1 := setupBlockOrbitDB(t)
+	host2, odb2 := setupBlockOrbitDB(t)
+
+	db1, err := odb1.Open("shared-events", nil)
+	require.NoError(t, err)
+	events1 := db1.(*databases.Events)
+	first, err := events1.Add("first")
+	require.NoError(t, err)
+	_, err = events1.Add("second")
+	require.NoError(t, err)
+
+	require.NoError(t, host2.Connect(context.Background(), peer.AddrInfo{ID: host1.ID(), Addrs: host1.Addrs()}))
+
+	// The second instance only knows the address: the manifest and the access controller are
+	// fetched from the first
+	db2, err := odb2.Open(events1.Address, nil)
+	require.NoError(t, err)
+	events2 := db2.(*databases.Events)
+
+	// So is the writer's identity
+	identity, err := odb2.Identities.GetIdentity(odb1.Identity.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, odb1.Identity.ID, identity.ID)
+
+	// And the history of the heads it receives
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, events2.WaitForSync(ctx, host1.ID().String()))
+
+	value, err := events2.Get(first)
+	require.NoError(t, err)
+	assert.Equal(t, "first", value)
+}
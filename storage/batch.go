@@ -0,0 +1,58 @@
+package storage
+
+// Batch collects puts and deletes that are written to a storage together by Commit. The
+// operations are applied in the order they were added. A batch can be reused once it has
+// been committed.
+type Batch interface {
+	// Put adds storing the key-value pair to the batch.
+	Put(key string, value []byte)
+
+	// Delete adds removing the key to the batch.
+	Delete(key string)
+
+	// Len returns the number of operations in the batch.
+	Len() int
+
+	// Commit writes the operations to the storage and empties the batch.
+	Commit() error
+}
+
+// batchOp is a put, or a delete if delete is set.
+type batchOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// opsBatch is a Batch that records the operations and hands them to the storage on Commit.
+type opsBatch struct {
+	ops    []batchOp
+	commit func(ops []batchOp) error
+}
+
+func newOpsBatch(commit func(ops []batchOp) error) *opsBatch {
+	return &opsBatch{commit: commit}
+}
+
+func (b *opsBatch) Put(key string, value []byte) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+func (b *opsBatch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+func (b *opsBatch) Len() int {
+	return len(b.ops)
+}
+
+func (b *opsBatch) Commit() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	if err := b.commit(b.ops); err != nil {
+		return err
+	}
+	b.ops = nil
+	return nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	for name, storage := range rangeStorages(t, "a", "b") {
+		batch := storage.NewBatch()
+		batch.Put("c", []byte("value-c"))
+		batch.Delete("a")
+		batch.Put("d", []byte("stale"))
+		batch.Put("d", []byte("value-d"))
+		require.Equal(t, 4, batch.Len(), name)
+
+		// Nothing is written before the batch is committed
+		_, err := storage.Get("c")
+		require.ErrorIs(t, err, ErrNotFound, name)
+
+		require.NoError(t, batch.Commit(), name)
+		require.Zero(t, batch.Len(), "%s: expected the batch to be emptied", name)
+
+		iter, err := storage.IterateRange(context.Background(), IteratorOptions{})
+		require.NoError(t, err, name)
+		require.Equal(t, []string{"b", "c", "d"}, collectKeys(t, iter), name)
+
+		// The batch can be reused
+		batch.Delete("b")
+		require.NoError(t, batch.Commit(), name)
+		_, err = storage.Get("b")
+		require.ErrorIs(t, err, ErrNotFound, name)
+	}
+}
+
+func TestComposedStorageBatch(t *testing.T) {
+	first := NewMemoryStorage()
+	second, err := NewLevelStorage(t.TempDir())
+	require.NoError(t, err)
+	defer second.Close()
+
+	composedStorage, err := NewComposedStorage(first, second)
+	require.NoError(t, err)
+	require.NoError(t, composedStorage.Put("a", []byte("value-a")))
+
+	batch := composedStorage.NewBatch()
+	batch.Put("b", []byte("value-b"))
+	batch.Delete("a")
+	require.NoError(t, batch.Commit())
+
+	// The batch is committed to each layer
+	for _, layer := range []Storage{first, second} {
+		value, err := layer.Get("b")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value-b"), value)
+		_, err = layer.Get("a")
+		require.ErrorIs(t, err, ErrNotFound)
+	}
+}
+
+// failingCommitStorage is a storage whose batches cannot be committed.
+type failingCommitStorage struct {
+	Storage
+}
+
+func (s failingCommitStorage) NewBatch() Batch {
+	return newOpsBatch(func([]batchOp) error {
+		return errors.New("commit failed")
+	})
+}
+
+func TestComposedStorageBatchRollsBack(t *testing.T) {
+	cache, err := NewLRUStorage(10)
+	require.NoError(t, err)
+	composedStorage, err := NewComposedStorage(cache, failingCommitStorage{Storage: NewMemoryStorage()})
+	require.NoError(t, err)
+	require.NoError(t, cache.Put("a", []byte("value-a")))
+
+	batch := composedStorage.NewBatch()
+	batch.Put("a", []byte("changed"))
+	batch.Put("b", []byte("value-b"))
+	require.ErrorContains(t, batch.Commit(), "commit failed")
+
+	// The layer in front of the failed one is restored
+	value, err := cache.Get("a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-a"), value)
+	_, err = cache.Get("b")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+// fetchingStorage is a storage that records the keys it would fetch from elsewhere because
+// it does not hold them.
+type fetchingStorage struct {
+	Storage
+	fetched []string
+}
+
+func (s *fetchingStorage) Get(key string) ([]byte, error) {
+	value, err := s.Storage.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		s.fetched = append(s.fetched, key)
+	}
+	return value, err
+}
+
+func (s *fetchingStorage) Has(_ context.Context, key string) (bool, error) {
+	_, err := s.Storage.Get(key)
+	return err == nil, nil
+}
+
+func TestComposedStorageBatchRollsBackLocally(t *testing.T) {
+	front := &fetchingStorage{Storage: NewMemoryStorage()}
+	composedStorage, err := NewComposedStorage(front, failingCommitStorage{Storage: NewMemoryStorage()})
+	require.NoError(t, err)
+	require.NoError(t, front.Put("a", []byte("value-a")))
+
+	batch := composedStorage.NewBatch()
+	batch.Put("a", []byte("changed"))
+	batch.Put("b", []byte("value-b"))
+	require.ErrorContains(t, batch.Commit(), "commit failed")
+
+	// Keys the layer does not hold are not fetched to restore them
+	require.Empty(t, front.fetched)
+	value, err := front.Get("a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-a"), value)
+	_, err = front.Storage.Get("b")
+	require.ErrorIs(t, err, ErrNotFound)
+}
@@ -1,10 +1,13 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
 )
 
-// ComposedStorage implements the Storage interface and manages multiple backends.
+// ComposedStorage implements the Storage interface and manages multiple backends. It passes
+// contexts on to the backends that take them, see ContextStorage.
 type ComposedStorage struct {
 	storages []Storage
 }
@@ -19,8 +22,13 @@ func NewComposedStorage(storages ...Storage) (*ComposedStorage, error) {
 
 // Put stores data in all configured storages.
 func (cs *ComposedStorage) Put(key string, value []byte) error {
+	return cs.PutContext(context.Background(), key, value)
+}
+
+// PutContext stores data in all configured storages.
+func (cs *ComposedStorage) PutContext(ctx context.Context, key string, value []byte) error {
 	for _, storage := range cs.storages {
-		if err := storage.Put(key, value); err != nil {
+		if err := WithContext(storage).PutContext(ctx, key, value); err != nil {
 			return err
 		}
 	}
@@ -30,23 +38,37 @@ func (cs *ComposedStorage) Put(key string, value []byte) error {
 // Get retrieves data from the first storage that has the key.
 // If the key is found in a fallback storage, it is propagated to the earlier storages.
 func (cs *ComposedStorage) Get(key string) ([]byte, error) {
+	return cs.GetContext(context.Background(), key)
+}
+
+// GetContext retrieves data from the first storage that has the key, like Get. It gives up
+// when ctx is done.
+func (cs *ComposedStorage) GetContext(ctx context.Context, key string) ([]byte, error) {
 	for i, storage := range cs.storages {
-		value, err := storage.Get(key)
+		value, err := WithContext(storage).GetContext(ctx, key)
 		if err == nil {
 			// Propagate to earlier storages if retrieved from a fallback storage.
 			for j := 0; j < i; j++ {
-				_ = cs.storages[j].Put(key, value) // Ignore errors during propagation.
+				_ = WithContext(cs.storages[j]).PutContext(ctx, key, value) // Ignore errors during propagation.
 			}
 			return value, nil
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 	}
-	return nil, errors.New("key not found")
+	return nil, ErrNotFound
 }
 
 // Delete removes data from all storages.
 func (cs *ComposedStorage) Delete(key string) error {
+	return cs.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext removes data from all storages.
+func (cs *ComposedStorage) DeleteContext(ctx context.Context, key string) error {
 	for _, storage := range cs.storages {
-		if err := storage.Delete(key); err != nil {
+		if err := WithContext(storage).DeleteContext(ctx, key); err != nil {
 			return err
 		}
 	}
@@ -55,21 +77,38 @@ func (cs *ComposedStorage) Delete(key string) error {
 
 // Iterator combines iterators from all storages, ensuring unique keys.
 func (cs *ComposedStorage) Iterator() (<-chan [2]string, error) {
+	return cs.IteratorContext(context.Background())
+}
+
+// IteratorContext combines iterators from all storages, ensuring unique keys. The channel
+// is closed early when ctx is done.
+func (cs *ComposedStorage) IteratorContext(ctx context.Context) (<-chan [2]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	ch := make(chan [2]string)
 	seen := make(map[string]bool)
 
 	go func() {
 		defer close(ch)
 		for _, storage := range cs.storages {
-			iter, err := storage.Iterator()
+			iter, err := WithContext(storage).IteratorContext(ctx)
 			if err != nil {
 				continue // Skip problematic storage during iteration.
 			}
 
 			for kv := range iter {
-				if !seen[kv[0]] {
-					seen[kv[0]] = true
-					ch <- kv
+				if seen[kv[0]] {
+					continue
+				}
+				seen[kv[0]] = true
+
+				select {
+				case ch <- kv:
+				case <-ctx.Done():
+					// The layer's iterator drains itself once ctx is done
+					return
 				}
 			}
 		}
@@ -78,26 +117,123 @@ func (cs *ComposedStorage) Iterator() (<-chan [2]string, error) {
 	return ch, nil
 }
 
+// IterateRange iterates over the key-value pairs in range of all storages, in key order.
+func (cs *ComposedStorage) IterateRange(ctx context.Context, opts IteratorOptions) (RangeIterator, error) {
+	return collectRange(ctx, cs, opts)
+}
+
+// NewBatch returns a batch that is committed to each storage in turn with the storage's
+// own batch, so each storage applies it as atomically as it can. If a storage fails, the
+// storages before it are restored to the values they held before the batch, so that a cache
+// in front of a persistent storage does not keep changes that were not persisted.
+func (cs *ComposedStorage) NewBatch() Batch {
+	return newOpsBatch(func(ops []batchOp) error {
+		var restores []Batch
+		for i, storage := range cs.storages {
+			// The last storage applies the batch on its own, so nothing is left to restore
+			var restore Batch
+			if i < len(cs.storages)-1 {
+				var err error
+				if restore, err = restoreBatch(storage, ops); err != nil {
+					return errors.Join(err, rollback(restores))
+				}
+			}
+
+			batch := storage.NewBatch()
+			for _, op := range ops {
+				if op.delete {
+					batch.Delete(op.key)
+				} else {
+					batch.Put(op.key, op.value)
+				}
+			}
+			if err := batch.Commit(); err != nil {
+				return errors.Join(err, rollback(restores))
+			}
+			restores = append(restores, restore)
+		}
+		return nil
+	})
+}
+
+// restoreBatch returns a batch that restores the keys of the operations to the values they
+// hold in the storage. Storages that fetch missing values from elsewhere are asked whether
+// they hold a key first, so that only the values held locally are read.
+func restoreBatch(storage Storage, ops []batchOp) (Batch, error) {
+	restore := storage.NewBatch()
+	seen := make(map[string]bool)
+	hs, hasLocal := storage.(HasStorage)
+	for _, op := range ops {
+		if seen[op.key] {
+			continue
+		}
+		seen[op.key] = true
+
+		if hasLocal {
+			has, err := hs.Has(context.Background(), op.key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check %s before the batch: %w", op.key, err)
+			}
+			if !has {
+				restore.Delete(op.key)
+				continue
+			}
+		}
+
+		value, err := storage.Get(op.key)
+		switch {
+		case err == nil:
+			restore.Put(op.key, value)
+		case errors.Is(err, ErrNotFound):
+			restore.Delete(op.key)
+		default:
+			return nil, fmt.Errorf("failed to read %s before the batch: %w", op.key, err)
+		}
+	}
+	return restore, nil
+}
+
+// rollback commits the restore batches, the last storage first.
+func rollback(restores []Batch) error {
+	var errs []error
+	for i := len(restores) - 1; i >= 0; i-- {
+		if err := restores[i].Commit(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to roll back batch: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // Merge merges data from another storage into all composed storages.
 func (cs *ComposedStorage) Merge(other Storage) error {
-	iter, err := other.Iterator()
+	return cs.MergeContext(context.Background(), other)
+}
+
+// MergeContext merges data from another storage into all composed storages.
+func (cs *ComposedStorage) MergeContext(ctx context.Context, other Storage) error {
+	iter, err := WithContext(other).IteratorContext(ctx)
 	if err != nil {
 		return err
 	}
 
 	for kv := range iter {
-		if err := cs.Put(kv[0], []byte(kv[1])); err != nil {
+		if err := cs.PutContext(ctx, kv[0], []byte(kv[1])); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return ctx.Err()
 }
 
 // Clear removes all data from all storages.
 func (cs *ComposedStorage) Clear() error {
+	return cs.ClearContext(context.Background())
+}
+
+// ClearContext removes all data from all storages.
+func (cs *ComposedStorage) ClearContext(ctx context.Context) error {
 	for _, storage := range cs.storages {
-		if err := storage.Clear(); err != nil {
+		if err := WithContext(storage).ClearContext(ctx); err != nil {
 			return err
 		}
 	}
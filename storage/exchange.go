@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// BlockProtocol is the stream protocol blocks are requested from peers over.
+const BlockProtocol = protocol.ID("/orbitdb/blocks/1.0.0")
+
+// blockRequestTimeout bounds how long a single peer may take to answer a block request.
+const blockRequestTimeout = 10 * time.Second
+
+// maxBlockSize is the largest block accepted from a peer.
+const maxBlockSize = 4 << 20
+
+// Libp2pExchange exchanges blocks with the peers connected to a libp2p host: blocks missing
+// from the local blockstore are requested from the peers one after the other, and the blocks
+// of the local blockstore are served to the peers requesting them. Pass it to an
+// IPFSBlockStorage with WithExchange.
+//
+// It plays the part of Bitswap, which the boxo version in use cannot run on this libp2p
+// version. Any other exchange.Interface, such as Bitswap, can be used with WithExchange instead.
+type Libp2pExchange struct {
+	host       host.Host
+	blockstore blockstore.Blockstore
+}
+
+// NewLibp2pExchange creates an exchange serving the blocks of the blockstore over the host.
+// The storages using the exchange should share the blockstore's datastore, so that the
+// blocks they store are served and the blocks fetched for them are kept.
+func NewLibp2pExchange(host host.Host, bs blockstore.Blockstore) (*Libp2pExchange, error) {
+	if host == nil || bs == nil {
+		return nil, errors.New("host and blockstore are required")
+	}
+
+	e := &Libp2pExchange{host: host, blockstore: bs}
+	host.SetStreamHandler(BlockProtocol, e.handleStream)
+	return e, nil
+}
+
+// GetBlock requests the block from the connected peers until one of them has it. Returns
+// format.ErrNotFound if none of them does.
+func (e *Libp2pExchange) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	for _, peerID := range e.host.Network().Peers() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		block, err := e.requestBlock(ctx, peerID, c)
+		if err != nil {
+			continue // Try the next peer
+		}
+		if block != nil {
+			return block, nil
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, format.ErrNotFound{Cid: c}
+}
+
+// GetBlocks requests the blocks from the connected peers, yielding the ones that are found.
+func (e *Libp2pExchange) GetBlocks(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error) {
+	ch := make(chan blocks.Block)
+	go func() {
+		defer close(ch)
+		for _, c := range cids {
+			block, err := e.GetBlock(ctx, c)
+			if err != nil {
+				continue
+			}
+
+			select {
+			case ch <- block:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// NotifyNewBlocks does nothing: blocks are served from the blockstore when they are requested.
+func (e *Libp2pExchange) NotifyNewBlocks(ctx context.Context, blocks ...blocks.Block) error {
+	return nil
+}
+
+// Close stops serving blocks to peers.
+func (e *Libp2pExchange) Close() error {
+	e.host.RemoveStreamHandler(BlockProtocol)
+	return nil
+}
+
+// requestBlock asks the peer for the block. It returns a nil block if the peer doesn't have it.
+func (e *Libp2pExchange) requestBlock(ctx context.Context, peerID peer.ID, c cid.Cid) (blocks.Block, error) {
+	ctx, cancel := context.WithTimeout(ctx, blockRequestTimeout)
+	defer cancel()
+
+	stream, err := e.host.NewStream(ctx, peerID, BlockProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block stream: %w", err)
+	}
+	defer stream.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := stream.SetDeadline(deadline); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	if err := writeBlockFrame(stream, c.Bytes()); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to request block: %w", err)
+	}
+	if err := stream.CloseWrite(); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	// A peer without the block closes the stream without answering
+	data, err := readBlockFrame(bufio.NewReader(stream))
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to receive block: %w", err)
+	}
+
+	// Only accept the data if it hashes to the requested CID
+	sum, err := c.Prefix().Sum(data)
+	if err != nil || !sum.Equals(c) {
+		return nil, fmt.Errorf("peer %s sent data that does not match %s", peerID, c)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// handleStream answers a block request from a peer with the block, if it is in the blockstore.
+func (e *Libp2pExchange) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	if err := stream.SetDeadline(time.Now().Add(blockRequestTimeout)); err != nil {
+		stream.Reset()
+		return
+	}
+
+	request, err := readBlockFrame(bufio.NewReader(stream))
+	if err != nil {
+		stream.Reset()
+		return
+	}
+	c, err := cid.Cast(request)
+	if err != nil {
+		stream.Reset()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), blockRequestTimeout)
+	defer cancel()
+	block, err := e.blockstore.Get(ctx, c)
+	if err != nil {
+		// Closing without an answer tells the peer that the block is not here
+		return
+	}
+
+	if err := writeBlockFrame(stream, block.RawData()); err != nil {
+		stream.Reset()
+	}
+}
+
+// writeBlockFrame writes the data prefixed with its length as an unsigned varint.
+func writeBlockFrame(w io.Writer, data []byte) error {
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(data)))
+	if _, err := w.Write(append(prefix[:n], data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readBlockFrame reads a frame written by writeBlockFrame. It returns io.EOF when the stream
+// ends before a frame.
+func readBlockFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxBlockSize {
+		return nil, fmt.Errorf("block of %d bytes exceeds the maximum of %d bytes", size, maxBlockSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
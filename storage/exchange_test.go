@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// exchangePeer is a loopback libp2p host whose IPFSBlockStorage fetches missing blocks from its peers.
+type exchangePeer struct {
+	host    host.Host
+	storage *IPFSBlockStorage
+}
+
+func newExchangePeer(t *testing.T) *exchangePeer {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err, "Failed to create libp2p host")
+	t.Cleanup(func() { h.Close() })
+
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	ex, err := NewLibp2pExchange(h, bs)
+	require.NoError(t, err, "Failed to create exchange")
+	t.Cleanup(func() { ex.Close() })
+
+	storage, err := NewIPFSBlockStorage(context.Background(), ds, createTestDAGService(ds), true, 5*time.Second, WithExchange(ex))
+	require.NoError(t, err, "Failed to create IPFSBlockStorage instance")
+
+	return &exchangePeer{host: h, storage: storage}
+}
+
+func connectExchangePeers(t *testing.T, a, b *exchangePeer) {
+	err := a.host.Connect(context.Background(), peer.AddrInfo{ID: b.host.ID(), Addrs: b.host.Addrs()})
+	require.NoError(t, err, "Failed to connect hosts")
+}
+
+func TestNewLibp2pExchange_Validation(t *testing.T) {
+	_, err := NewLibp2pExchange(nil, blockstore.NewBlockstore(datastore.NewMapDatastore()))
+	require.Error(t, err, "Expected an error without a host")
+}
+
+func TestIPFSBlockStorage_Exchange(t *testing.T) {
+	a := newExchangePeer(t)
+	b := newExchangePeer(t)
+	connectExchangePeers(t, a, b)
+
+	key := putCBORBlock(t, a.storage, "shared block")
+	expected, err := a.storage.Get(key)
+	require.NoError(t, err)
+
+	has, err := b.storage.Has(context.Background(), key)
+	require.NoError(t, err)
+	require.False(t, has, "Expected the block to only be held by the peer")
+
+	// The block is fetched from the peer that holds it...
+	value, err := b.storage.Get(key)
+	require.NoError(t, err, "Failed to fetch block from peer")
+	require.Equal(t, expected, value)
+
+	// ...and kept locally, so it no longer needs the peer, though it wasn't written in the
+	// storage
+	c, err := cid.Decode(key)
+	require.NoError(t, err)
+	has, err = b.storage.blockstore.Has(context.Background(), c)
+	require.NoError(t, err)
+	require.True(t, has, "Expected the fetched block to be stored locally")
+	has, err = b.storage.Has(context.Background(), key)
+	require.NoError(t, err)
+	require.False(t, has, "Expected the fetched block not to count as written")
+
+	require.NoError(t, a.host.Close())
+	value, err = b.storage.Get(key)
+	require.NoError(t, err, "Failed to get the fetched block without the peer")
+	require.Equal(t, expected, value)
+}
+
+func TestIPFSBlockStorage_ExchangeNotFound(t *testing.T) {
+	a := newExchangePeer(t)
+	b := newExchangePeer(t)
+	connectExchangePeers(t, a, b)
+
+	// A block that no peer holds
+	missing := putCBORBlock(t, newTestIPFSBlockStorage(t, sync.MutexWrap(datastore.NewMapDatastore())), "missing block")
+
+	_, err := b.storage.Get(missing)
+	require.True(t, errors.Is(err, ErrNotFound), "Expected ErrNotFound, got %v", err)
+
+	// Without an exchange, blocks held by peers are not found either
+	key := putCBORBlock(t, a.storage, "unreachable block")
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	_, err = newTestIPFSBlockStorage(t, ds).Get(key)
+	require.True(t, errors.Is(err, ErrNotFound), "Expected ErrNotFound, got %v", err)
+}
+
+func TestIPFSBlockStorage_ExchangeCanceled(t *testing.T) {
+	a := newExchangePeer(t)
+	b := newExchangePeer(t)
+	connectExchangePeers(t, a, b)
+
+	key := putCBORBlock(t, a.storage, "shared block")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := b.storage.GetContext(ctx, key)
+	require.ErrorIs(t, err, context.Canceled)
+}
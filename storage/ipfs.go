@@ -7,29 +7,58 @@ import (
 	"fmt"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ipfs/boxo/blockservice"
 	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/boxo/exchange"
 	pinner "github.com/ipfs/boxo/pinning/pinner"
 	"github.com/ipfs/boxo/pinning/pinner/dspinner"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
 	format "github.com/ipfs/go-ipld-format"
 )
 
 // DefaultTimeout is the timeout for IPFS block operations
 const DefaultTimeout = 30 * time.Second
 
-// IPFSBlockStorage is a Storage implementation backed by Boxo's blockservice.
+// DefaultNamespace is the namespace the index of written blocks is kept under in the datastore.
+const DefaultNamespace = "default"
+
+// writtenPrefix is the datastore prefix of the indexes of written blocks.
+const writtenPrefix = "/orbitdb/written"
+
+// refsPrefix is the datastore prefix of the namespaces each written block is recorded in, by
+// multihash. Blocks are addressed by their content, so storages in different namespaces may
+// write the same block.
+const refsPrefix = "/orbitdb/refs"
+
+// IPFSBlockStorage is a Storage implementation backed by Boxo's blockservice. It implements
+// ContextStorage: operations give up when the caller's context is done or after the
+// storage's timeout, whichever comes first.
 type IPFSBlockStorage struct {
 	blockstore blockstore.Blockstore
 	blocksvc   blockservice.BlockService
 	pinner     pinner.Pinner
 	pin        bool
+	pinHeads   bool // Pin the heads passed to PinHeads recursively instead of every block
 	timeout    time.Duration
+	ds         datastore.Batching // Datastore of the blocks and the indexes of every namespace
+	namespace  datastore.Key      // Namespace the storage records the blocks it writes under
+	written    datastore.Batching // Keys of the blocks written in the storage's namespace, by multihash
+	shared     *sharedBlocks      // Pinner and GC guard shared with the storages on the same datastore
+	gc         *gcGuard           // Keeps GC from running while blocks are written and pinned
+	closeOnce  sync.Once
 }
 
 type ipldPrimeNodeWrapper struct {
@@ -79,10 +108,88 @@ func (w *ipldPrimeNodeWrapper) Copy() format.Node {
 	return &ipldPrimeNodeWrapper{node: w.node, cid: w.cid}
 }
 
-// Links returns the links of the node (empty for now)
+// Links returns the links of the node: the IPLD links anywhere in it, and the CIDs listed
+// in the next and refs fields of an oplog entry, which are stored as strings.
 func (w *ipldPrimeNodeWrapper) Links() []*format.Link {
-	// If your IPLD node has links, extract and convert them here
-	return nil
+	var links []*format.Link
+	collectLinks(w.node, "", &links)
+	return links
+}
+
+// collectLinks appends the links found in the node to links, named by their path in the node.
+func collectLinks(node datamodel.Node, path string, links *[]*format.Link) {
+	switch node.Kind() {
+	case datamodel.Kind_Link:
+		link, err := node.AsLink()
+		if err != nil {
+			return
+		}
+		if cl, ok := link.(cidlink.Link); ok {
+			*links = append(*links, &format.Link{Name: path, Cid: cl.Cid})
+		}
+
+	case datamodel.Kind_Map:
+		iter := node.MapIterator()
+		for !iter.Done() {
+			k, v, err := iter.Next()
+			if err != nil {
+				return
+			}
+			key, err := k.AsString()
+			if err != nil {
+				continue
+			}
+
+			// The entry links are CID strings at the top of the entry
+			if path == "" && (key == "next" || key == "refs") && v.Kind() == datamodel.Kind_List {
+				collectEntryLinks(v, key, links)
+				continue
+			}
+			collectLinks(v, joinLinkPath(path, key), links)
+		}
+
+	case datamodel.Kind_List:
+		iter := node.ListIterator()
+		for !iter.Done() {
+			i, v, err := iter.Next()
+			if err != nil {
+				return
+			}
+			collectLinks(v, joinLinkPath(path, strconv.FormatInt(i, 10)), links)
+		}
+	}
+}
+
+// collectEntryLinks appends the CID strings of an entry's next or refs list to links.
+func collectEntryLinks(list datamodel.Node, path string, links *[]*format.Link) {
+	iter := list.ListIterator()
+	for !iter.Done() {
+		i, v, err := iter.Next()
+		if err != nil {
+			return
+		}
+		if v.Kind() == datamodel.Kind_Link {
+			collectLinks(v, joinLinkPath(path, strconv.FormatInt(i, 10)), links)
+			continue
+		}
+
+		str, err := v.AsString()
+		if err != nil {
+			continue
+		}
+		c, err := cid.Decode(str)
+		if err != nil {
+			continue
+		}
+		*links = append(*links, &format.Link{Name: joinLinkPath(path, strconv.FormatInt(i, 10)), Cid: c})
+	}
+}
+
+func joinLinkPath(path, segment string) string {
+	if path == "" {
+		return segment
+	}
+	return path + "/" + segment
 }
 
 // Stat returns statistics about the node
@@ -100,12 +207,58 @@ func wrapIPLDPrimeNode(node datamodel.Node, cid cid.Cid) format.Node {
 	return &ipldPrimeNodeWrapper{node: node, cid: cid}
 }
 
-// NewIPFSBlockStorage creates a new IPFSBlockStorage instance using Boxo.
-func NewIPFSBlockStorage(ctx context.Context, ds datastore.Batching, dserv format.DAGService, pin bool, timeout time.Duration) (*IPFSBlockStorage, error) {
+// IPFSBlockStorageOption configures optional settings of an IPFSBlockStorage.
+type IPFSBlockStorageOption func(*ipfsBlockStorageOptions)
+
+// ipfsBlockStorageOptions holds the optional settings of an IPFSBlockStorage.
+type ipfsBlockStorageOptions struct {
+	exchange  exchange.Interface
+	pinHeads  bool
+	namespace string
+}
+
+// WithExchange fetches the blocks that are not in the local blockstore from peers through
+// the exchange, such as a Libp2pExchange or Bitswap. Without one, only local blocks can be
+// retrieved. The exchange is not closed with the storage.
+func WithExchange(ex exchange.Interface) IPFSBlockStorageOption {
+	return func(o *ipfsBlockStorageOptions) {
+		o.exchange = ex
+	}
+}
+
+// WithHeadPinning pins only the heads passed to PinHeads, recursively, instead of pinning
+// every block as it is stored. An oplog using the storage passes it its heads, so that
+// the blocks of its history stay pinned through its heads and everything else, such as
+// the history of a dropped database, can be removed by GC. It enables pinning.
+func WithHeadPinning() IPFSBlockStorageOption {
+	return func(o *ipfsBlockStorageOptions) {
+		o.pinHeads = true
+	}
+}
+
+// WithNamespace sets the namespace the storage records the blocks it writes under in the
+// datastore. Clear removes the blocks written in the namespace, also by earlier instances,
+// so storages that share a datastore but must be cleared separately need their own namespace.
+func WithNamespace(name string) IPFSBlockStorageOption {
+	return func(o *ipfsBlockStorageOptions) {
+		o.namespace = name
+	}
+}
+
+// NewIPFSBlockStorage creates a new IPFSBlockStorage instance using Boxo. Storages on the same
+// datastore share their pinner and the holds on GC until they are closed: GC through any of
+// them removes the blocks that none of them pins, once none of them holds blocks. The pinner
+// walks pinned DAGs with the DAG service of the first of them.
+func NewIPFSBlockStorage(ctx context.Context, ds datastore.Batching, dserv format.DAGService, pin bool, timeout time.Duration, options ...IPFSBlockStorageOption) (*IPFSBlockStorage, error) {
 	if ds == nil {
 		return nil, errors.New("datastore is required")
 	}
 
+	opts := &ipfsBlockStorageOptions{namespace: DefaultNamespace}
+	for _, option := range options {
+		option(opts)
+	}
+
 	if timeout <= 0 {
 		timeout = DefaultTimeout
 	}
@@ -113,33 +266,50 @@ func NewIPFSBlockStorage(ctx context.Context, ds datastore.Batching, dserv forma
 	// Create a blockstore
 	bs := blockstore.NewBlockstore(ds)
 
-	// Use nil for the exchange.Interface as this is a local implementation
-	blocksvc := blockservice.New(bs, nil)
+	// Without an exchange the blockservice only serves local blocks
+	blocksvc := blockservice.New(bs, opts.exchange)
 
-	// Create the pinner
-	pinner, err := dspinner.New(ctx, ds, dserv)
+	// Share the pinner with the other storages on the datastore
+	shared, err := acquireSharedBlocks(ctx, ds, dserv)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create pinner: %w", err)
+		return nil, err
 	}
 
 	return &IPFSBlockStorage{
 		blockstore: bs,
 		blocksvc:   blocksvc,
-		pinner:     pinner,
-		pin:        pin,
+		pinner:     shared.pinner,
+		pin:        pin || opts.pinHeads,
+		pinHeads:   opts.pinHeads,
 		timeout:    timeout,
+		ds:         ds,
+		namespace:  datastore.NewKey(opts.namespace),
+		written:    namespace.Wrap(ds, datastore.NewKey(writtenPrefix).ChildString(opts.namespace)),
+		shared:     shared,
+		gc:         shared.gc,
 	}, nil
 }
 
 // Put stores data as a block in IPFS.
 func (s *IPFSBlockStorage) Put(key string, value []byte) error {
+	return s.PutContext(context.Background(), key, value)
+}
+
+// PutContext stores data as a block in IPFS.
+func (s *IPFSBlockStorage) PutContext(ctx context.Context, key string, value []byte) error {
 	c, err := cid.Decode(key)
 	if err != nil {
 		return fmt.Errorf("invalid CID: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Keep GC from removing the block before it is pinned
+	defer s.HoldBlocks()()
 
 	// Create a block with the given data and CID
 	block, err := blocks.NewBlockWithCid(value, c)
@@ -152,9 +322,12 @@ func (s *IPFSBlockStorage) Put(key string, value []byte) error {
 	if err != nil {
 		return fmt.Errorf("failed to store block: %w", err)
 	}
+	if err := s.remember(ctx, c, key); err != nil {
+		return err
+	}
 
-	// Optionally pin the block
-	if s.pin {
+	// Optionally pin the block, unless the blocks are pinned through the heads
+	if s.pin && !s.pinHeads {
 		// Convert the block to an IPLD node
 		nb := basicnode.Prototype.Any.NewBuilder()
 		buf := bytes.NewReader(block.RawData())
@@ -192,16 +365,29 @@ func (s *IPFSBlockStorage) Put(key string, value []byte) error {
 
 // Get retrieves data from a block in IPFS.
 func (s *IPFSBlockStorage) Get(key string) ([]byte, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+// GetContext retrieves data from a block in IPFS. Blocks that are not in the local
+// blockstore are fetched through the exchange, if the storage has one. Returns ErrNotFound if
+// the block is not available.
+func (s *IPFSBlockStorage) GetContext(ctx context.Context, key string) ([]byte, error) {
 	c, err := cid.Decode(key)
 	if err != nil {
 		return nil, fmt.Errorf("invalid CID: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Retrieve the block from the blockservice
 	block, err := s.blocksvc.GetBlock(ctx, c)
+	if format.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to retrieve block %s: %w", key, ErrNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve block: %w", err)
 	}
@@ -209,15 +395,58 @@ func (s *IPFSBlockStorage) Get(key string) ([]byte, error) {
 	return block.RawData(), nil
 }
 
-// Delete removes a block from the blockstore.
+// Has reports whether the block was written in the storage's namespace and is in the local
+// blockstore, without fetching it from peers. Like Iterator, it leaves out the blocks that
+// were only fetched from peers or written in other namespaces, even though Get finds them
+// locally.
+func (s *IPFSBlockStorage) Has(ctx context.Context, key string) (bool, error) {
+	c, err := cid.Decode(key)
+	if err != nil {
+		return false, fmt.Errorf("invalid CID: %w", err)
+	}
+
+	written, err := s.written.Has(ctx, dshelp.MultihashToDsKey(c.Hash()))
+	if err != nil || !written {
+		return false, err
+	}
+	has, err := s.blockstore.Has(ctx, c)
+	if err != nil {
+		return false, fmt.Errorf("failed to check block %s: %w", key, err)
+	}
+	return has, nil
+}
+
+// Delete removes a block from the storage's namespace, and from the blockstore unless another
+// namespace wrote it too.
 func (s *IPFSBlockStorage) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext removes a block from the storage's namespace like Delete. The block is only
+// removed from the blockstore and unpinned if no other namespace on the datastore wrote it,
+// as the storages in those namespaces may still reference it.
+func (s *IPFSBlockStorage) DeleteContext(ctx context.Context, key string) error {
 	c, err := cid.Decode(key)
 	if err != nil {
 		return fmt.Errorf("invalid CID: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := s.forget(ctx, c); err != nil {
+		return err
+	}
+	namespaces, err := s.namespacesOf(ctx, c)
+	if err != nil {
+		return err
+	}
+	if len(namespaces) > 0 {
+		return nil
+	}
 
 	// Remove the block from the blockstore
 	err = s.blockstore.DeleteBlock(ctx, c)
@@ -225,9 +454,9 @@ func (s *IPFSBlockStorage) Delete(key string) error {
 		return fmt.Errorf("failed to delete block: %w", err)
 	}
 
-	// Optionally unpin the block
+	// Optionally unpin the block, whether it is pinned directly or as a head
 	if s.pin {
-		err = s.pinner.Unpin(ctx, c, false)
+		err = s.pinner.Unpin(ctx, c, true)
 		if err != nil && !errors.Is(err, pinner.ErrNotPinned) {
 			return fmt.Errorf("failed to unpin block: %w", err)
 		}
@@ -242,23 +471,519 @@ func (s *IPFSBlockStorage) Delete(key string) error {
 	return nil
 }
 
-// Iterator is not supported for IPFSBlockStorage
+// Iterator iterates over the blocks written in the storage's namespace, also by earlier
+// instances.
 func (s *IPFSBlockStorage) Iterator() (<-chan [2]string, error) {
-	return nil, errors.New("iterator not implemented for IPFSBlockStorage")
+	return s.IteratorContext(context.Background())
+}
+
+// IteratorContext iterates over the blocks written in the storage's namespace until ctx is
+// done. The blocks are yielded under the key they were stored with. Blocks that other
+// namespaces wrote to the datastore or that were fetched from peers are left out.
+func (s *IPFSBlockStorage) IteratorContext(ctx context.Context) (<-chan [2]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	results, err := s.written.Query(ctx, query.Query{})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to list written blocks: %w", err)
+	}
+
+	ch := make(chan [2]string)
+	go func() {
+		defer cancel()
+		defer close(ch)
+		defer results.Close()
+
+		for result := range results.Next() {
+			if result.Error != nil {
+				return
+			}
+			key := string(result.Value)
+			c, err := cid.Decode(key)
+			if err != nil {
+				continue
+			}
+			block, err := s.blockstore.Get(ctx, c)
+			if err != nil {
+				continue // The block may have been deleted in the meantime
+			}
+
+			select {
+			case ch <- [2]string{key, string(block.RawData())}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// IterateRange collects the blocks in range with Iterator.
+func (s *IPFSBlockStorage) IterateRange(ctx context.Context, opts IteratorOptions) (RangeIterator, error) {
+	return collectRange(ctx, s, opts)
 }
 
-// Merge merges data from another storage instance
+// NewBatch returns a batch whose blocks are stored and deleted one after the other. Blocks
+// are addressed by their content, so a block stored before a failure is never inconsistent.
+func (s *IPFSBlockStorage) NewBatch() Batch {
+	return newOpsBatch(func(ops []batchOp) error {
+		for _, op := range ops {
+			var err error
+			if op.delete {
+				err = s.Delete(op.key)
+			} else {
+				err = s.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Merge copies the blocks of another storage instance.
 func (s *IPFSBlockStorage) Merge(other Storage) error {
-	return errors.New("merge not implemented for IPFSBlockStorage")
+	return s.MergeContext(context.Background(), other)
+}
+
+// MergeContext copies the blocks of another storage instance until ctx is done. The keys
+// of the other storage must be the CIDs of their values.
+func (s *IPFSBlockStorage) MergeContext(ctx context.Context, other Storage) error {
+	if other == nil {
+		return errors.New("storage to merge is required")
+	}
+
+	iter, err := WithContext(other).IteratorContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to iterate over storage: %w", err)
+	}
+
+	for kv := range iter {
+		if err := s.PutContext(ctx, kv[0], []byte(kv[1])); err != nil {
+			// Let the iterator drain itself
+			for range iter {
+			}
+			return fmt.Errorf("failed to copy block %s: %w", kv[0], err)
+		}
+	}
+
+	return ctx.Err()
 }
 
-// Clear removes all blocks (not implemented as IPFS typically handles this globally)
+// Clear unpins and removes the blocks written in the storage's namespace. Blocks that other
+// storages or the IPFS node wrote to the same blockstore are left alone, also when they were
+// written in the namespace too.
 func (s *IPFSBlockStorage) Clear() error {
-	return errors.New("clear not implemented for IPFSBlockStorage")
+	return s.ClearContext(context.Background())
+}
+
+// ClearContext unpins and removes the blocks written in the storage's namespace, like Clear.
+// The written blocks are recorded in the datastore, so blocks written before the storage was
+// reopened are removed too.
+func (s *IPFSBlockStorage) ClearContext(ctx context.Context) error {
+	results, err := s.written.Query(ctx, query.Query{})
+	if err != nil {
+		return fmt.Errorf("failed to list written blocks: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return fmt.Errorf("failed to list written blocks: %w", err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, string(entry.Value))
+	}
+
+	for _, key := range keys {
+		if err := s.DeleteContext(ctx, key); err != nil {
+			return fmt.Errorf("failed to clear block %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// PinHeads pins the blocks recursively, along with the blocks they link to, if the storage
+// was created with WithHeadPinning. The blocks are fetched through the exchange if they are
+// not stored locally; the blocks they link to are expected to be stored already, as the
+// history of an oplog entry is before the entry becomes a head.
+func (s *IPFSBlockStorage) PinHeads(ctx context.Context, keys ...string) error {
+	if !s.pinHeads || len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	defer s.HoldBlocks()()
+
+	for _, key := range keys {
+		c, err := cid.Decode(key)
+		if err != nil {
+			return fmt.Errorf("invalid CID: %w", err)
+		}
+
+		if _, err := s.blocksvc.GetBlock(ctx, c); err != nil {
+			return fmt.Errorf("failed to retrieve head %s: %w", key, err)
+		}
+		if err := s.pinner.PinWithMode(ctx, c, pinner.Recursive); err != nil {
+			return fmt.Errorf("failed to pin head %s: %w", key, err)
+		}
+	}
+
+	if err := s.pinner.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush pin state: %w", err)
+	}
+	return nil
+}
+
+// UnpinHeads removes the recursive pins of the blocks, if the storage was created with
+// WithHeadPinning. The blocks are only removed by GC, and only if no other pin keeps them.
+func (s *IPFSBlockStorage) UnpinHeads(ctx context.Context, keys ...string) error {
+	if !s.pinHeads || len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	for _, key := range keys {
+		c, err := cid.Decode(key)
+		if err != nil {
+			return fmt.Errorf("invalid CID: %w", err)
+		}
+
+		err = s.pinner.Unpin(ctx, c, true)
+		if err != nil && !errors.Is(err, pinner.ErrNotPinned) {
+			return fmt.Errorf("failed to unpin head %s: %w", key, err)
+		}
+	}
+
+	if err := s.pinner.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush pin state: %w", err)
+	}
+	return nil
+}
+
+// HoldBlocks keeps GC from running until release is called, also through the other storages
+// on the datastore. With WithHeadPinning, blocks are only pinned once a head that links to them
+// is, so an oplog holds the blocks from writing its entries until its heads are pinned. Holds
+// can be nested.
+func (s *IPFSBlockStorage) HoldBlocks() (release func()) {
+	s.gc.hold()
+	return s.gc.release
+}
+
+// GC removes the blocks that are not pinned from the blockstore: blocks are kept if they are
+// pinned directly or are part of the DAG under a recursive pin. GC waits for the blocks held
+// with HoldBlocks on any storage on the datastore to be released. With WithHeadPinning, blocks that are not
+// part of an oplog, such as manifests and identities, are only kept if they are pinned
+// directly, e.g. by storing them through a storage created with pinning but without
+// WithHeadPinning on the same datastore.
+func (s *IPFSBlockStorage) GC(ctx context.Context) error {
+	s.gc.collect()
+	defer s.gc.done()
+
+	keep, err := s.pinnedBlocks(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list blocks: %w", err)
+	}
+
+	// Collect the blocks first rather than deleting while the blockstore lists them
+	var unpinned []cid.Cid
+	for c := range keys {
+		if !keep[string(c.Hash())] {
+			unpinned = append(unpinned, c)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range unpinned {
+		if err := s.blockstore.DeleteBlock(ctx, c); err != nil {
+			return fmt.Errorf("failed to delete block %s: %w", c, err)
+		}
+		if err := s.forgetEverywhere(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pinnedBlocks returns the multihashes of the blocks that are pinned directly or that are
+// reachable from a recursive pin. Blocks of a DAG that are not stored locally are skipped.
+func (s *IPFSBlockStorage) pinnedBlocks(ctx context.Context) (map[string]bool, error) {
+	pinned := make(map[string]bool)
+
+	var stack []cid.Cid
+	for streamed := range s.pinner.RecursiveKeys(ctx) {
+		if streamed.Err != nil {
+			return nil, fmt.Errorf("failed to list recursive pins: %w", streamed.Err)
+		}
+		stack = append(stack, streamed.C)
+	}
+
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if pinned[string(c.Hash())] {
+			continue
+		}
+		pinned[string(c.Hash())] = true
+
+		block, err := s.blockstore.Get(ctx, c)
+		if format.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve block %s: %w", c, err)
+		}
+		for _, link := range blockLinks(block) {
+			stack = append(stack, link.Cid)
+		}
+	}
+
+	for streamed := range s.pinner.DirectKeys(ctx) {
+		if streamed.Err != nil {
+			return nil, fmt.Errorf("failed to list direct pins: %w", streamed.Err)
+		}
+		pinned[string(streamed.C.Hash())] = true
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return pinned, nil
+}
+
+// blockLinks returns the links of a dag-cbor block. Blocks of other codecs have no links.
+func blockLinks(block blocks.Block) []*format.Link {
+	if block.Cid().Type() != cid.DagCBOR {
+		return nil
+	}
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(block.RawData())); err != nil {
+		return nil
+	}
+	return wrapIPLDPrimeNode(nb.Build(), block.Cid()).Links()
+}
+
+// sharedBlocks is the pinner and GC guard of the storages on a datastore. Separate pinners on
+// one datastore would each keep their own view of the pins, and GC through one storage must
+// wait for the blocks held by all of them.
+type sharedBlocks struct {
+	ds         datastore.Batching
+	pinner     pinner.Pinner
+	gc         *gcGuard
+	refs       int
+	registered bool // Whether the storages on the datastore find it in sharedByStore
+}
+
+var (
+	sharedBlocksMu sync.Mutex
+	sharedByStore  = make(map[datastore.Batching]*sharedBlocks)
+)
+
+// acquireSharedBlocks returns the pinner and GC guard of the datastore, creating them for the
+// first storage on it. Datastores that can't be told apart by comparison get their own.
+func acquireSharedBlocks(ctx context.Context, ds datastore.Batching, dserv format.DAGService) (*sharedBlocks, error) {
+	comparable := reflect.TypeOf(ds).Comparable()
+
+	sharedBlocksMu.Lock()
+	defer sharedBlocksMu.Unlock()
+
+	if comparable {
+		if shared, ok := sharedByStore[ds]; ok {
+			shared.refs++
+			return shared, nil
+		}
+	}
+
+	p, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pinner: %w", err)
+	}
+	shared := &sharedBlocks{ds: ds, pinner: p, gc: newGCGuard(), refs: 1, registered: comparable}
+	if comparable {
+		sharedByStore[ds] = shared
+	}
+	return shared, nil
+}
+
+// release forgets the pinner and GC guard once no storage on the datastore is open.
+func (b *sharedBlocks) release() {
+	sharedBlocksMu.Lock()
+	defer sharedBlocksMu.Unlock()
+
+	b.refs--
+	if b.refs == 0 && b.registered {
+		delete(sharedByStore, b.ds)
+	}
+}
+
+// gcGuard lets GC run only while no blocks are held. Unlike a sync.RWMutex, a hold can be
+// taken while the same goroutine already holds one: GC waits for the holds to be released
+// rather than blocking new ones, so it may wait as long as blocks are being written.
+type gcGuard struct {
+	mu         sync.Mutex
+	changed    *sync.Cond
+	holds      int
+	collecting bool
+}
+
+// newGCGuard creates a guard that nothing holds.
+func newGCGuard() *gcGuard {
+	g := &gcGuard{}
+	g.changed = sync.NewCond(&g.mu)
+	return g
+}
+
+// hold waits for a running GC to finish and keeps the next one from starting.
+func (g *gcGuard) hold() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.collecting {
+		g.changed.Wait()
+	}
+	g.holds++
+}
+
+// release releases a hold taken with hold.
+func (g *gcGuard) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.holds--
+	if g.holds == 0 {
+		g.changed.Broadcast()
+	}
+}
+
+// collect waits until nothing is held and no other GC runs, and starts GC.
+func (g *gcGuard) collect() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.holds > 0 || g.collecting {
+		g.changed.Wait()
+	}
+	g.collecting = true
+}
+
+// done ends GC started with collect.
+func (g *gcGuard) done() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.collecting = false
+	g.changed.Broadcast()
+}
+
+// remember records in the datastore that the block was written in the storage's namespace
+// under the key.
+func (s *IPFSBlockStorage) remember(ctx context.Context, c cid.Cid, key string) error {
+	batch, err := s.ds.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to record block %s: %w", key, err)
+	}
+	mhKey := dshelp.MultihashToDsKey(c.Hash())
+	if err := batch.Put(ctx, writtenKey(s.namespace, mhKey), []byte(key)); err != nil {
+		return fmt.Errorf("failed to record block %s: %w", key, err)
+	}
+	if err := batch.Put(ctx, refKey(mhKey, s.namespace), []byte{}); err != nil {
+		return fmt.Errorf("failed to record block %s: %w", key, err)
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to record block %s: %w", key, err)
+	}
+	return nil
+}
+
+// forget removes the block from the blocks written in the storage's namespace.
+func (s *IPFSBlockStorage) forget(ctx context.Context, c cid.Cid) error {
+	return s.forgetIn(ctx, c, s.namespace)
+}
+
+// forgetEverywhere removes the block from the blocks written in every namespace, once it has
+// been removed from the blockstore.
+func (s *IPFSBlockStorage) forgetEverywhere(ctx context.Context, c cid.Cid) error {
+	namespaces, err := s.namespacesOf(ctx, c)
+	if err != nil {
+		return err
+	}
+	for _, ns := range append(namespaces, s.namespace) {
+		if err := s.forgetIn(ctx, c, ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetIn removes the block from the blocks written in the namespace.
+func (s *IPFSBlockStorage) forgetIn(ctx context.Context, c cid.Cid, ns datastore.Key) error {
+	batch, err := s.ds.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to forget block %s: %w", c, err)
+	}
+	mhKey := dshelp.MultihashToDsKey(c.Hash())
+	if err := batch.Delete(ctx, writtenKey(ns, mhKey)); err != nil {
+		return fmt.Errorf("failed to forget block %s: %w", c, err)
+	}
+	if err := batch.Delete(ctx, refKey(mhKey, ns)); err != nil {
+		return fmt.Errorf("failed to forget block %s: %w", c, err)
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to forget block %s: %w", c, err)
+	}
+	return nil
+}
+
+// namespacesOf returns the namespaces other than the storage's own that the block was
+// written in.
+func (s *IPFSBlockStorage) namespacesOf(ctx context.Context, c cid.Cid) ([]datastore.Key, error) {
+	prefix := datastore.NewKey(refsPrefix).Child(dshelp.MultihashToDsKey(c.Hash()))
+	results, err := s.ds.Query(ctx, query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the namespaces of block %s: %w", c, err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the namespaces of block %s: %w", c, err)
+	}
+
+	var namespaces []datastore.Key
+	for _, entry := range entries {
+		ns := datastore.NewKey(strings.TrimPrefix(entry.Key, prefix.String()))
+		if !ns.Equal(s.namespace) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces, nil
+}
+
+// writtenKey is the key the block with the multihash key is recorded under in the index of
+// the blocks written in the namespace.
+func writtenKey(ns, mhKey datastore.Key) datastore.Key {
+	return datastore.NewKey(writtenPrefix).Child(ns).Child(mhKey)
+}
+
+// refKey is the key that records that the block with the multihash key was written in the
+// namespace.
+func refKey(mhKey, ns datastore.Key) datastore.Key {
+	return datastore.NewKey(refsPrefix).Child(mhKey).Child(ns)
 }
 
 // Close releases resources used by the storage
 func (s *IPFSBlockStorage) Close() error {
-	// No resources to release in this implementation
+	// Let go of the pinner shared with the other storages on the datastore
+	s.closeOnce.Do(s.shared.release)
 	return nil
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/ipfs/go-datastore/sync"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	mh "github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
@@ -209,28 +210,152 @@ func TestIPFSBlockStorage_Timeout(t *testing.T) {
 	require.Error(t, err, "expected timeout error during Put")
 }
 
-func TestIPFSBlockStorage_UnsupportedFeatures(t *testing.T) {
-	ctx := context.Background()
+// newTestIPFSBlockStorage creates an IPFSBlockStorage over the datastore.
+func newTestIPFSBlockStorage(t *testing.T, ds datastore.Batching, options ...IPFSBlockStorageOption) *IPFSBlockStorage {
+	storage, err := NewIPFSBlockStorage(context.Background(), ds, createTestDAGService(ds), true, DefaultTimeout, options...)
+	require.NoError(t, err, "Failed to create IPFSBlockStorage instance")
+	return storage
+}
 
-	// Create a mock datastore and DAGService
+// putCBORBlock stores the data as a DagCBOR block and returns its key.
+func putCBORBlock(t *testing.T, storage Storage, data string) string {
+	encodedData := encodeCBORBytes(t, []byte(data))
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256}.Sum(encodedData)
+	require.NoError(t, err, "Failed to generate CID")
+	require.NoError(t, storage.Put(c.String(), encodedData), "Failed to put data into storage")
+	return c.String()
+}
+
+func TestIPFSBlockStorage_Iterator(t *testing.T) {
+	storage := newTestIPFSBlockStorage(t, sync.MutexWrap(datastore.NewMapDatastore()))
+
+	expected := map[string]string{}
+	for _, data := range []string{"first", "second", "third"} {
+		key := putCBORBlock(t, storage, data)
+		value, err := storage.Get(key)
+		require.NoError(t, err)
+		expected[key] = string(value)
+	}
+
+	// The blocks are yielded under the keys they were stored with
+	iter, err := storage.Iterator()
+	require.NoError(t, err, "Failed to iterate over storage")
+	received := map[string]string{}
+	for kv := range iter {
+		received[kv[0]] = kv[1]
+	}
+	require.Equal(t, expected, received)
+
+	// The iteration can be stopped early
+	ctx, cancel := context.WithCancel(context.Background())
+	iter, err = storage.IteratorContext(ctx)
+	require.NoError(t, err)
+	<-iter
+	cancel()
+	for range iter {
+	}
+}
+
+func TestIPFSBlockStorage_Merge(t *testing.T) {
+	storage := newTestIPFSBlockStorage(t, sync.MutexWrap(datastore.NewMapDatastore()))
+
+	other := NewMemoryStorage()
+	key := putCBORBlock(t, other, "merged data")
+
+	require.NoError(t, storage.Merge(other), "Failed to merge storage")
+	value, err := storage.Get(key)
+	require.NoError(t, err, "Expected the block to be copied")
+	expected, _ := other.Get(key)
+	require.Equal(t, expected, value)
+
+	// Keys that are not the CIDs of their values can't be copied
+	invalid := NewMemoryStorage()
+	require.NoError(t, invalid.Put("not-a-cid", []byte("data")))
+	require.Error(t, storage.Merge(invalid))
+	require.Error(t, storage.Merge(nil))
+}
+
+func TestIPFSBlockStorage_Clear(t *testing.T) {
 	ds := sync.MutexWrap(datastore.NewMapDatastore())
-	dagService := createTestDAGService(ds)
+	storage := newTestIPFSBlockStorage(t, ds, WithNamespace("own"))
+	other := newTestIPFSBlockStorage(t, ds, WithNamespace("other"))
 
-	// Create an IPFSBlockStorage instance
-	storage, err := NewIPFSBlockStorage(ctx, ds, dagService, true, DefaultTimeout)
+	ownKeys := []string{putCBORBlock(t, storage, "own 1"), putCBORBlock(t, storage, "own 2")}
+	otherKey := putCBORBlock(t, other, "other")
+
+	require.NoError(t, storage.Clear(), "Failed to clear storage")
+
+	// Only the blocks written by the cleared instance are removed
+	for _, key := range ownKeys {
+		_, err := storage.Get(key)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		c, err := cid.Decode(key)
+		require.NoError(t, err)
+		_, pinned, err := storage.pinner.IsPinned(context.Background(), c)
+		require.NoError(t, err)
+		require.False(t, pinned, "Expected the cleared block to be unpinned")
+	}
+	_, err := storage.Get(otherKey)
+	require.NoError(t, err, "Expected the block of the other instance to remain")
+
+	// Each storage only iterates over the blocks written in its namespace
+	require.Empty(t, iterateKeys(t, storage))
+	require.Equal(t, []string{otherKey}, iterateKeys(t, other))
+}
+
+// iterateKeys returns the keys the storage iterates over.
+func iterateKeys(t *testing.T, storage Storage) []string {
+	iter, err := storage.Iterator()
+	require.NoError(t, err)
+	var keys []string
+	for kv := range iter {
+		keys = append(keys, kv[0])
+	}
+	return keys
+}
+
+func TestIPFSBlockStorage_ClearKeepsBlocksOfOtherNamespaces(t *testing.T) {
+	ctx := context.Background()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	storage := newTestIPFSBlockStorage(t, ds, WithNamespace("own"))
+	other := newTestIPFSBlockStorage(t, ds, WithNamespace("other"))
+
+	// Both namespaces write the same block
+	key := putCBORBlock(t, storage, "shared")
+	require.Equal(t, key, putCBORBlock(t, other, "shared"))
+	c, err := cid.Decode(key)
+	require.NoError(t, err)
+
+	// Clearing one namespace leaves the block and its pin to the other
+	require.NoError(t, storage.Clear())
+	require.Empty(t, iterateKeys(t, storage))
+	require.Equal(t, []string{key}, iterateKeys(t, other))
+	_, pinned, err := other.pinner.IsPinned(ctx, c)
 	require.NoError(t, err)
+	require.True(t, pinned, "Expected the block to stay pinned for the other namespace")
 
-	// Test Iterator
-	_, err = storage.Iterator()
-	require.Error(t, err, "expected error for unsupported iterator")
+	// Once no namespace holds the block, it is removed and unpinned
+	require.NoError(t, other.Delete(key))
+	_, err = other.Get(key)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, pinned, err = other.pinner.IsPinned(ctx, c)
+	require.NoError(t, err)
+	require.False(t, pinned, "Expected the block to be unpinned")
+}
+
+func TestIPFSBlockStorage_ClearAfterReopen(t *testing.T) {
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	storage := newTestIPFSBlockStorage(t, ds)
+	key := putCBORBlock(t, storage, "written before reopening")
+	require.NoError(t, storage.Close())
 
-	// Test Merge
-	err = storage.Merge(nil)
-	require.Error(t, err, "expected error for unsupported merge")
+	// The reopened storage still knows the blocks written in its namespace
+	reopened := newTestIPFSBlockStorage(t, ds)
+	require.NoError(t, reopened.Clear(), "Failed to clear storage")
 
-	// Test Clear
-	err = storage.Clear()
-	require.Error(t, err, "expected error for unsupported clear")
+	_, err := reopened.Get(key)
+	require.ErrorIs(t, err, ErrNotFound, "Expected the block written before reopening to be removed")
 }
 
 func TestIPFSBlockStorage_PutAndGet_ComplexMap(t *testing.T) {
@@ -633,3 +758,287 @@ func TestIPFSBlockStorage_GetNonExistentBlock(t *testing.T) {
 	err = storage.Close()
 	require.NoError(t, err, "Failed to close storage")
 }
+
+func TestIPFSBlockStorage_Context(t *testing.T) {
+	ctx := context.Background()
+
+	// Create a mock datastore and DAGService.
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	dagService := createTestDAGService(ds)
+
+	// Create an IPFSBlockStorage instance.
+	storage, err := NewIPFSBlockStorage(ctx, ds, dagService, false, DefaultTimeout)
+	require.NoError(t, err, "Failed to create IPFSBlockStorage instance")
+
+	data := []byte("context test data")
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum(data)
+	require.NoError(t, err, "Failed to generate CID")
+
+	// A missing block is reported as ErrNotFound.
+	_, err = storage.GetContext(ctx, c.String())
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, storage.PutContext(ctx, c.String(), data))
+	retrievedData, err := storage.GetContext(ctx, c.String())
+	require.NoError(t, err)
+	require.Equal(t, data, retrievedData)
+
+	// A canceled context fails the operations.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = storage.GetContext(canceled, c.String())
+	require.ErrorIs(t, err, context.Canceled)
+	require.Same(t, storage, WithContext(storage))
+}
+
+// putEntryBlock stores a DagCBOR block shaped like an oplog entry, with CID strings in its
+// next field, and returns its key.
+func putEntryBlock(t *testing.T, storage Storage, payload string, next ...string) string {
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(3)
+	require.NoError(t, err)
+	require.NoError(t, ma.AssembleKey().AssignString("payload"))
+	require.NoError(t, ma.AssembleValue().AssignString(payload))
+	require.NoError(t, ma.AssembleKey().AssignString("next"))
+	la, err := ma.AssembleValue().BeginList(int64(len(next)))
+	require.NoError(t, err)
+	for _, hash := range next {
+		require.NoError(t, la.AssembleValue().AssignString(hash))
+	}
+	require.NoError(t, la.Finish())
+	require.NoError(t, ma.AssembleKey().AssignString("refs"))
+	refs, err := ma.AssembleValue().BeginList(0)
+	require.NoError(t, err)
+	require.NoError(t, refs.Finish())
+	require.NoError(t, ma.Finish())
+
+	var buf bytes.Buffer
+	require.NoError(t, dagcbor.Encode(nb.Build(), &buf))
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256}.Sum(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(c.String(), buf.Bytes()), "Failed to put entry into storage")
+	return c.String()
+}
+
+func TestIPLDPrimeNodeWrapper_Links(t *testing.T) {
+	first, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256}.Sum([]byte("first"))
+	require.NoError(t, err)
+	second, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256}.Sum([]byte("second"))
+	require.NoError(t, err)
+	linked, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum([]byte("linked"))
+	require.NoError(t, err)
+
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(4)
+	require.NoError(t, err)
+	require.NoError(t, ma.AssembleKey().AssignString("next"))
+	next, err := ma.AssembleValue().BeginList(2)
+	require.NoError(t, err)
+	require.NoError(t, next.AssembleValue().AssignString(first.String()))
+	require.NoError(t, next.AssembleValue().AssignString("not a CID"))
+	require.NoError(t, next.Finish())
+	require.NoError(t, ma.AssembleKey().AssignString("refs"))
+	refs, err := ma.AssembleValue().BeginList(1)
+	require.NoError(t, err)
+	require.NoError(t, refs.AssembleValue().AssignString(second.String()))
+	require.NoError(t, refs.Finish())
+	require.NoError(t, ma.AssembleKey().AssignString("identity"))
+	require.NoError(t, ma.AssembleValue().AssignString(second.String()))
+	require.NoError(t, ma.AssembleKey().AssignString("data"))
+	data, err := ma.AssembleValue().BeginMap(1)
+	require.NoError(t, err)
+	require.NoError(t, data.AssembleKey().AssignString("link"))
+	require.NoError(t, data.AssembleValue().AssignLink(cidlink.Link{Cid: linked}))
+	require.NoError(t, data.Finish())
+	require.NoError(t, ma.Finish())
+
+	links := wrapIPLDPrimeNode(nb.Build(), first).Links()
+
+	// Only the entry's next and refs strings and actual IPLD links are links
+	received := map[string]cid.Cid{}
+	for _, link := range links {
+		received[link.Name] = link.Cid
+	}
+	require.Equal(t, map[string]cid.Cid{
+		"next/0":    first,
+		"refs/0":    second,
+		"data/link": linked,
+	}, received)
+}
+
+func TestIPFSBlockStorage_HeadPinning(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestIPFSBlockStorage(t, sync.MutexWrap(datastore.NewMapDatastore()), WithHeadPinning())
+
+	first := putEntryBlock(t, storage, "first")
+	second := putEntryBlock(t, storage, "second", first)
+	third := putEntryBlock(t, storage, "third", second)
+	orphan := putEntryBlock(t, storage, "orphan")
+
+	// Blocks are not pinned as they are stored
+	firstCid, err := cid.Decode(first)
+	require.NoError(t, err)
+	_, pinned, err := storage.pinner.IsPinned(ctx, firstCid)
+	require.NoError(t, err)
+	require.False(t, pinned, "Expected blocks not to be pinned until they are part of a pinned head")
+
+	// The history of a pinned head survives GC, anything else is removed
+	require.NoError(t, storage.PinHeads(ctx, third))
+	require.NoError(t, storage.GC(ctx))
+	for _, key := range []string{first, second, third} {
+		has, err := storage.Has(ctx, key)
+		require.NoError(t, err)
+		require.True(t, has, "Expected block %s to be kept by the head's pin", key)
+	}
+	has, err := storage.Has(ctx, orphan)
+	require.NoError(t, err)
+	require.False(t, has, "Expected the unpinned block to be removed")
+
+	// Moving the pin to a new head keeps the history
+	fourth := putEntryBlock(t, storage, "fourth", third)
+	require.NoError(t, storage.PinHeads(ctx, fourth))
+	require.NoError(t, storage.UnpinHeads(ctx, third))
+	require.NoError(t, storage.GC(ctx))
+	for _, key := range []string{first, second, third, fourth} {
+		has, err := storage.Has(ctx, key)
+		require.NoError(t, err)
+		require.True(t, has, "Expected block %s to be kept by the new head's pin", key)
+	}
+
+	// Without pins, the whole history is removed
+	require.NoError(t, storage.UnpinHeads(ctx, fourth))
+	require.NoError(t, storage.GC(ctx))
+	for _, key := range []string{first, second, third, fourth} {
+		_, err := storage.Get(key)
+		require.ErrorIs(t, err, ErrNotFound)
+	}
+}
+
+func TestIPFSBlockStorage_GC(t *testing.T) {
+	ctx := context.Background()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+
+	// Blocks pinned as they are stored are kept, blocks written without pinning are not
+	pinned := newTestIPFSBlockStorage(t, ds)
+	kept := putCBORBlock(t, pinned, "pinned block")
+
+	unpinned, err := NewIPFSBlockStorage(ctx, ds, createTestDAGService(ds), false, DefaultTimeout)
+	require.NoError(t, err)
+	removed := putCBORBlock(t, unpinned, "unpinned block")
+
+	require.NoError(t, pinned.GC(ctx))
+
+	_, err = pinned.Get(kept)
+	require.NoError(t, err, "Expected the pinned block to be kept")
+	_, err = pinned.Get(removed)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Heads are ignored without WithHeadPinning
+	require.NoError(t, pinned.PinHeads(ctx, kept))
+	require.NoError(t, pinned.UnpinHeads(ctx, kept))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, pinned.GC(canceled), context.Canceled)
+}
+
+func TestIPFSBlockStorage_GCKeepsDirectlyPinnedBlocks(t *testing.T) {
+	ctx := context.Background()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+
+	// Blocks that are not part of a log, such as manifests, are pinned as they are stored by a
+	// storage without head pinning on the same datastore
+	entries := newTestIPFSBlockStorage(t, ds, WithHeadPinning(), WithNamespace("entries"))
+	manifests := newTestIPFSBlockStorage(t, ds, WithNamespace("manifests"))
+
+	manifest := putCBORBlock(t, manifests, "manifest")
+	entry := putCBORBlock(t, entries, "unpinned entry")
+
+	require.NoError(t, entries.GC(ctx))
+
+	_, err := entries.Get(manifest)
+	require.NoError(t, err, "Expected the directly pinned block to be kept")
+	_, err = entries.Get(entry)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestIPFSBlockStorage_HoldBlocks(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestIPFSBlockStorage(t, sync.MutexWrap(datastore.NewMapDatastore()), WithHeadPinning())
+
+	// Holds can be nested, and writing blocks while holding them does not block
+	release := storage.HoldBlocks()
+	nested := storage.HoldBlocks()
+	key := putCBORBlock(t, storage, "held block")
+
+	collected := make(chan error, 1)
+	go func() {
+		collected <- storage.GC(ctx)
+	}()
+
+	select {
+	case <-collected:
+		t.Fatal("Expected GC to wait for the blocks to be released")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The block is pinned through its head before it is released
+	require.NoError(t, storage.PinHeads(ctx, key))
+	nested()
+	release()
+
+	select {
+	case err := <-collected:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for GC")
+	}
+
+	has, err := storage.Has(ctx, key)
+	require.NoError(t, err)
+	require.True(t, has, "Expected the block pinned while held to be kept")
+}
+
+func TestIPFSBlockStorage_GCSharedDatastore(t *testing.T) {
+	ctx := context.Background()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+
+	// The storages on a datastore share their pinner and the holds on GC
+	writer := newTestIPFSBlockStorage(t, ds, WithHeadPinning(), WithNamespace("writer"))
+	collector := newTestIPFSBlockStorage(t, ds, WithHeadPinning(), WithNamespace("collector"))
+	require.Same(t, writer.pinner, collector.pinner)
+
+	release := writer.HoldBlocks()
+	key := putEntryBlock(t, writer, "written but not yet pinned")
+
+	collected := make(chan error, 1)
+	go func() {
+		collected <- collector.GC(ctx)
+	}()
+
+	select {
+	case <-collected:
+		t.Fatal("Expected GC to wait for the blocks held by the other storage")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, writer.PinHeads(ctx, key))
+	release()
+
+	select {
+	case err := <-collected:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for GC")
+	}
+
+	has, err := writer.Has(ctx, key)
+	require.NoError(t, err)
+	require.True(t, has, "Expected the block pinned by the other storage to be kept")
+
+	// Once every storage on the datastore is closed, new ones start afresh
+	require.NoError(t, writer.Close())
+	require.NoError(t, collector.Close())
+	reopened := newTestIPFSBlockStorage(t, ds, WithHeadPinning(), WithNamespace("writer"))
+	require.NotSame(t, writer.pinner, reopened.pinner)
+}
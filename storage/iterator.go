@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// IteratorOptions selects the key-value pairs yielded by IterateRange. The zero value
+// yields every pair in ascending key order.
+type IteratorOptions struct {
+	Prefix  string // Only yield keys starting with Prefix
+	Start   string // Only yield keys from Start on, inclusive
+	End     string // Only yield keys before End, exclusive; no upper bound if empty
+	Reverse bool   // Yield the keys in descending order
+	Limit   int    // Stop after Limit pairs; no limit if 0 or less
+}
+
+// RangeIterator yields the key-value pairs selected by IteratorOptions in key order.
+// It must be closed once done with, which may be before it is exhausted.
+type RangeIterator interface {
+	// Next moves to the next pair. It returns false once the pairs are exhausted, the
+	// limit is reached or the context is done.
+	Next() bool
+
+	// Key returns the key of the current pair.
+	Key() string
+
+	// Value returns the value of the current pair.
+	Value() []byte
+
+	// Err returns the error that ended the iteration, if any.
+	Err() error
+
+	// Close releases the iterator.
+	Close() error
+}
+
+// includes reports whether the key is in the range selected by the options.
+func (o IteratorOptions) includes(key string) bool {
+	if !strings.HasPrefix(key, o.Prefix) {
+		return false
+	}
+	if key < o.Start {
+		return false
+	}
+	return o.End == "" || key < o.End
+}
+
+// pair is a key-value pair yielded by a sliceIterator.
+type pair struct {
+	key   string
+	value []byte
+}
+
+// sliceIterator yields pairs that have been collected in memory. Storages without ordered
+// keys collect and sort the pairs in range, so that nothing keeps running in the background
+// once the iterator is closed.
+type sliceIterator struct {
+	ctx     context.Context
+	pairs   []pair
+	current int
+	err     error
+}
+
+// newSliceIterator sorts the pairs that are in range and keeps up to the limit of them.
+func newSliceIterator(ctx context.Context, pairs []pair, opts IteratorOptions) *sliceIterator {
+	selected := pairs[:0]
+	for _, p := range pairs {
+		if opts.includes(p.key) {
+			selected = append(selected, p)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		if opts.Reverse {
+			return selected[i].key > selected[j].key
+		}
+		return selected[i].key < selected[j].key
+	})
+
+	if opts.Limit > 0 && len(selected) > opts.Limit {
+		selected = selected[:opts.Limit]
+	}
+
+	return &sliceIterator{ctx: ctx, pairs: selected, current: -1}
+}
+
+// collectRange collects the pairs of a storage that only has an unordered Iterator.
+func collectRange(ctx context.Context, s Storage, opts IteratorOptions) (RangeIterator, error) {
+	iter, err := WithContext(s).IteratorContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []pair
+	for kv := range iter {
+		if opts.includes(kv[0]) {
+			pairs = append(pairs, pair{key: kv[0], value: []byte(kv[1])})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return newSliceIterator(ctx, pairs, opts), nil
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.err != nil || it.current >= len(it.pairs) {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.current++
+	return it.current < len(it.pairs)
+}
+
+func (it *sliceIterator) Key() string {
+	if it.current < 0 || it.current >= len(it.pairs) {
+		return ""
+	}
+	return it.pairs[it.current].key
+}
+
+func (it *sliceIterator) Value() []byte {
+	if it.current < 0 || it.current >= len(it.pairs) {
+		return nil
+	}
+	return it.pairs[it.current].value
+}
+
+func (it *sliceIterator) Err() error {
+	return it.err
+}
+
+func (it *sliceIterator) Close() error {
+	it.pairs = nil
+	it.current = 0
+	return nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rangeStorages returns a storage of every kind holding the same keys.
+func rangeStorages(t *testing.T, keys ...string) map[string]Storage {
+	lruStorage, err := NewLRUStorage(100)
+	require.NoError(t, err)
+	levelStorage, err := NewLevelStorage(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { levelStorage.Close() })
+	composedStorage, err := NewComposedStorage(NewMemoryStorage(), NewMemoryStorage())
+	require.NoError(t, err)
+
+	storages := map[string]Storage{
+		"memory":   NewMemoryStorage(),
+		"lru":      lruStorage,
+		"level":    levelStorage,
+		"composed": composedStorage,
+	}
+	for _, storage := range storages {
+		for _, key := range keys {
+			require.NoError(t, storage.Put(key, []byte("value-"+key)))
+		}
+	}
+	return storages
+}
+
+// collectKeys reads the keys of the iterator, checking the values along the way.
+func collectKeys(t *testing.T, iter RangeIterator) []string {
+	defer iter.Close()
+
+	var keys []string
+	for iter.Next() {
+		require.Equal(t, []byte("value-"+iter.Key()), iter.Value())
+		keys = append(keys, iter.Key())
+	}
+	require.NoError(t, iter.Err())
+	return keys
+}
+
+func TestIterateRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     IteratorOptions
+		expected []string
+	}{
+		{"all", IteratorOptions{}, []string{"a", "b/1", "b/2", "b/3", "c"}},
+		{"prefix", IteratorOptions{Prefix: "b/"}, []string{"b/1", "b/2", "b/3"}},
+		{"start and end", IteratorOptions{Start: "b/2", End: "c"}, []string{"b/2", "b/3"}},
+		{"prefix and start", IteratorOptions{Prefix: "b/", Start: "b/2"}, []string{"b/2", "b/3"}},
+		{"prefix and end", IteratorOptions{Prefix: "b/", End: "b/2"}, []string{"b/1"}},
+		{"reverse", IteratorOptions{Reverse: true}, []string{"c", "b/3", "b/2", "b/1", "a"}},
+		{"reverse prefix with limit", IteratorOptions{Prefix: "b/", Reverse: true, Limit: 2}, []string{"b/3", "b/2"}},
+		{"limit", IteratorOptions{Limit: 2}, []string{"a", "b/1"}},
+		{"empty", IteratorOptions{Prefix: "d"}, nil},
+	}
+
+	for name, storage := range rangeStorages(t, "c", "b/2", "a", "b/3", "b/1") {
+		for _, tt := range tests {
+			iter, err := storage.IterateRange(context.Background(), tt.opts)
+			require.NoError(t, err, "%s: %s", name, tt.name)
+			require.Equal(t, tt.expected, collectKeys(t, iter), "%s: %s", name, tt.name)
+		}
+	}
+}
+
+func TestIterateRangeClosedEarly(t *testing.T) {
+	for name, storage := range rangeStorages(t, "a", "b", "c") {
+		iter, err := storage.IterateRange(context.Background(), IteratorOptions{})
+		require.NoError(t, err, name)
+
+		require.True(t, iter.Next(), name)
+		require.Equal(t, "a", iter.Key(), name)
+		require.NoError(t, iter.Close(), name)
+
+		// The storage stays usable once an iterator is closed
+		require.NoError(t, storage.Put("d", []byte("value-d")), name)
+	}
+}
+
+func TestIterateRangeCanceled(t *testing.T) {
+	for name, storage := range rangeStorages(t, "a", "b", "c") {
+		ctx, cancel := context.WithCancel(context.Background())
+		iter, err := storage.IterateRange(ctx, IteratorOptions{})
+		require.NoError(t, err, name)
+
+		require.True(t, iter.Next(), name)
+		cancel()
+		require.False(t, iter.Next(), name)
+		require.ErrorIs(t, iter.Err(), context.Canceled, name)
+		require.NoError(t, iter.Close(), name)
+
+		_, err = storage.IterateRange(ctx, IteratorOptions{})
+		require.ErrorIs(t, err, context.Canceled, name)
+	}
+}
@@ -1,8 +1,10 @@
 package storage
 
 import (
-	"errors"
+	"context"
+
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -30,7 +32,7 @@ func (s *LevelStorage) Put(key string, value []byte) error {
 func (s *LevelStorage) Get(key string) ([]byte, error) {
 	value, err := s.db.Get([]byte(key), nil)
 	if err == leveldb.ErrNotFound {
-		return nil, errors.New("key not found")
+		return nil, ErrNotFound
 	}
 	return value, err
 }
@@ -59,6 +61,110 @@ func (s *LevelStorage) Iterator() (<-chan [2]string, error) {
 	return ch, nil
 }
 
+// IterateRange iterates over the key-value pairs in range with a LevelDB iterator, which
+// reads the keys in order from a snapshot of the database.
+func (s *LevelStorage) IterateRange(ctx context.Context, opts IteratorOptions) (RangeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r := &util.Range{}
+	if opts.Prefix != "" {
+		r = util.BytesPrefix([]byte(opts.Prefix))
+	}
+	if opts.Start != "" && opts.Start > string(r.Start) {
+		r.Start = []byte(opts.Start)
+	}
+	if opts.End != "" && (r.Limit == nil || opts.End < string(r.Limit)) {
+		r.Limit = []byte(opts.End)
+	}
+
+	return &levelIterator{
+		ctx:     ctx,
+		iter:    s.db.NewIterator(r, nil),
+		reverse: opts.Reverse,
+		limit:   opts.Limit,
+	}, nil
+}
+
+// levelIterator is a RangeIterator over a LevelDB iterator.
+type levelIterator struct {
+	ctx     context.Context
+	iter    iterator.Iterator
+	reverse bool
+	limit   int
+	count   int
+	done    bool
+	err     error
+}
+
+func (it *levelIterator) Next() bool {
+	if it.done || (it.limit > 0 && it.count >= it.limit) {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.done, it.err = true, err
+		return false
+	}
+
+	var ok bool
+	switch {
+	case it.count == 0 && it.reverse:
+		ok = it.iter.Last()
+	case it.count == 0:
+		ok = it.iter.First()
+	case it.reverse:
+		ok = it.iter.Prev()
+	default:
+		ok = it.iter.Next()
+	}
+	if !ok {
+		it.done, it.err = true, it.iter.Error()
+		return false
+	}
+
+	it.count++
+	return true
+}
+
+func (it *levelIterator) Key() string {
+	return string(it.iter.Key())
+}
+
+// Value returns a copy of the value, LevelDB reuses the buffer when moving on.
+func (it *levelIterator) Value() []byte {
+	value := it.iter.Value()
+	if value == nil {
+		return nil
+	}
+	return append([]byte(nil), value...)
+}
+
+func (it *levelIterator) Err() error {
+	return it.err
+}
+
+func (it *levelIterator) Close() error {
+	it.iter.Release()
+	return nil
+}
+
+// NewBatch returns a batch that is written as a single LevelDB batch, so that either all
+// or none of its operations are persisted, even across a crash.
+func (s *LevelStorage) NewBatch() Batch {
+	return newOpsBatch(func(ops []batchOp) error {
+		batch := new(leveldb.Batch)
+		for _, op := range ops {
+			if op.delete {
+				batch.Delete([]byte(op.key))
+			} else {
+				batch.Put([]byte(op.key), op.value)
+			}
+		}
+		return s.db.Write(batch, nil)
+	})
+}
+
 // Merge merges data from another storage instance.
 func (s *LevelStorage) Merge(other Storage) error {
 	iter, err := other.Iterator()
@@ -1,7 +1,8 @@
 package storage
 
 import (
-	"errors"
+	"context"
+
 	lru "github.com/hashicorp/golang-lru"
 )
 
@@ -30,7 +31,7 @@ func (s *LRUStorage) Get(key string) ([]byte, error) {
 	if value, ok := s.cache.Get(key); ok {
 		return value.([]byte), nil
 	}
-	return nil, errors.New("key not found")
+	return nil, ErrNotFound
 }
 
 // Delete removes a key-value pair from the LRU cache.
@@ -52,6 +53,41 @@ func (s *LRUStorage) Iterator() (<-chan [2]string, error) {
 	return ch, nil
 }
 
+// IterateRange iterates over the key-value pairs in range, in key order. The pairs are
+// collected when it is called without updating how recently they were used.
+func (s *LRUStorage) IterateRange(ctx context.Context, opts IteratorOptions) (RangeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var pairs []pair
+	for _, key := range s.cache.Keys() {
+		if !opts.includes(key.(string)) {
+			continue
+		}
+		// The key may have been evicted in the meantime
+		if value, ok := s.cache.Peek(key); ok {
+			pairs = append(pairs, pair{key: key.(string), value: value.([]byte)})
+		}
+	}
+	return newSliceIterator(ctx, pairs, opts), nil
+}
+
+// NewBatch returns a batch that is applied to the LRU cache one operation after the other.
+// The cache does not survive a restart, so there is no crash to be atomic against.
+func (s *LRUStorage) NewBatch() Batch {
+	return newOpsBatch(func(ops []batchOp) error {
+		for _, op := range ops {
+			if op.delete {
+				s.cache.Remove(op.key)
+			} else {
+				s.cache.Add(op.key, op.value)
+			}
+		}
+		return nil
+	})
+}
+
 // Merge merges data from another storage instance.
 func (s *LRUStorage) Merge(other Storage) error {
 	iter, err := other.Iterator()
@@ -1,7 +1,7 @@
 package storage
 
 import (
-	"errors"
+	"context"
 	"sync"
 )
 
@@ -32,7 +32,7 @@ func (ms *MemoryStorage) Get(hash string) ([]byte, error) {
 	defer ms.mu.RUnlock()
 	value, exists := ms.memory[hash]
 	if !exists {
-		return nil, errors.New("key not found")
+		return nil, ErrNotFound
 	}
 	return value, nil
 }
@@ -61,6 +61,43 @@ func (ms *MemoryStorage) Iterator() (<-chan [2]string, error) {
 	return ch, nil
 }
 
+// IterateRange iterates over the key-value pairs in range, in key order. The pairs are
+// collected when it is called, so later changes are not seen by the iterator.
+func (ms *MemoryStorage) IterateRange(ctx context.Context, opts IteratorOptions) (RangeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var pairs []pair
+	for key, value := range ms.memory {
+		if opts.includes(key) {
+			pairs = append(pairs, pair{key: key, value: value})
+		}
+	}
+	return newSliceIterator(ctx, pairs, opts), nil
+}
+
+// NewBatch returns a batch that is applied to memory at once, readers see either none or
+// all of its operations.
+func (ms *MemoryStorage) NewBatch() Batch {
+	return newOpsBatch(func(ops []batchOp) error {
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+
+		for _, op := range ops {
+			if op.delete {
+				delete(ms.memory, op.key)
+			} else {
+				ms.memory[op.key] = op.value
+			}
+		}
+		return nil
+	})
+}
+
 // Merge merges data from another storage instance into memory
 func (ms *MemoryStorage) Merge(other Storage) error {
 	iter, err := other.Iterator()
@@ -1,11 +1,19 @@
 package storage
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by Get when the key is not in the storage.
+var ErrNotFound = errors.New("key not found")
+
 // Storage is an interface
 type Storage interface {
 	// Put stores a key-value pair in the storage.
 	Put(key string, value []byte) error
 
-	// Get retrieves a value by its key. Returns an error if the key is not found.
+	// Get retrieves a value by its key. Returns ErrNotFound if the key is not found.
 	Get(key string) ([]byte, error)
 
 	// Delete removes a key-value pair from the storage.
@@ -14,6 +22,13 @@ type Storage interface {
 	// Iterator returns a channel that yields key-value pairs.
 	Iterator() (<-chan [2]string, error)
 
+	// IterateRange returns an iterator over the key-value pairs selected by opts, in key
+	// order. The iteration ends early when ctx is done.
+	IterateRange(ctx context.Context, opts IteratorOptions) (RangeIterator, error)
+
+	// NewBatch returns a batch of puts and deletes that are written together on Commit.
+	NewBatch() Batch
+
 	// Merge merges data from another storage instance.
 	Merge(other Storage) error
 
@@ -23,3 +38,134 @@ type Storage interface {
 	// Close closes the storage and releases resources.
 	Close() error
 }
+
+// ContextStorage is a Storage whose operations take a context, so that callers can cancel
+// operations that may take long, such as fetching a block from the network. Use WithContext
+// to get one from any Storage.
+type ContextStorage interface {
+	Storage
+
+	// PutContext stores a key-value pair in the storage.
+	PutContext(ctx context.Context, key string, value []byte) error
+
+	// GetContext retrieves a value by its key. Returns ErrNotFound if the key is not found.
+	GetContext(ctx context.Context, key string) ([]byte, error)
+
+	// DeleteContext removes a key-value pair from the storage.
+	DeleteContext(ctx context.Context, key string) error
+
+	// IteratorContext returns a channel that yields key-value pairs. The channel is
+	// closed early when ctx is done.
+	IteratorContext(ctx context.Context) (<-chan [2]string, error)
+
+	// MergeContext merges data from another storage instance.
+	MergeContext(ctx context.Context, other Storage) error
+
+	// ClearContext removes all key-value pairs from the storage.
+	ClearContext(ctx context.Context) error
+}
+
+// HasStorage is a Storage that can tell whether it holds a key without retrieving the value.
+// Storages that fetch missing values from elsewhere, such as an IPFSBlockStorage with an
+// exchange, implement it so that callers can tell what is held locally.
+type HasStorage interface {
+	Storage
+
+	// Has reports whether the key is held locally. It never fetches the value.
+	Has(ctx context.Context, key string) (bool, error)
+}
+
+// HeadPinner is implemented by storages that keep blocks through pins on the heads of the
+// DAGs they are part of, such as an IPFSBlockStorage created with WithHeadPinning. An oplog
+// using such a storage pins its heads as they change.
+type HeadPinner interface {
+	// PinHeads pins the keys, along with everything they link to.
+	PinHeads(ctx context.Context, keys ...string) error
+
+	// UnpinHeads removes the pins of the keys.
+	UnpinHeads(ctx context.Context, keys ...string) error
+
+	// HoldBlocks keeps the storage from removing unpinned blocks until release is called,
+	// so that entries are not removed between being written and their heads being pinned.
+	HoldBlocks() (release func())
+}
+
+// WithContext returns the storage as a ContextStorage. Storages that don't take a context
+// themselves are adapted: the context is checked before each operation and ends iteration,
+// but an operation that has started runs to completion.
+func WithContext(s Storage) ContextStorage {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs
+	}
+	return &contextAdapter{Storage: s}
+}
+
+// contextAdapter adds the context-taking methods to a Storage.
+type contextAdapter struct {
+	Storage
+}
+
+func (a *contextAdapter) PutContext(ctx context.Context, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Put(key, value)
+}
+
+func (a *contextAdapter) GetContext(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.Get(key)
+}
+
+func (a *contextAdapter) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Delete(key)
+}
+
+func (a *contextAdapter) IteratorContext(ctx context.Context) (<-chan [2]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	iter, err := a.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	return iterateContext(ctx, iter), nil
+}
+
+func (a *contextAdapter) MergeContext(ctx context.Context, other Storage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Merge(other)
+}
+
+func (a *contextAdapter) ClearContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Clear()
+}
+
+// iterateContext forwards the key-value pairs of the iterator until ctx is done. The rest
+// of the iterator is drained so that its producer does not block forever.
+func iterateContext(ctx context.Context, iter <-chan [2]string) <-chan [2]string {
+	ch := make(chan [2]string)
+	go func() {
+		defer close(ch)
+		for kv := range iter {
+			select {
+			case ch <- kv:
+			case <-ctx.Done():
+				for range iter {
+				}
+				return
+			}
+		}
+	}()
+	return ch
+}
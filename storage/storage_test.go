@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrNotFound(t *testing.T) {
+	lruStorage, err := NewLRUStorage(10)
+	require.NoError(t, err)
+	levelStorage, err := NewLevelStorage(t.TempDir())
+	require.NoError(t, err)
+	defer levelStorage.Close()
+	composedStorage, err := NewComposedStorage(NewMemoryStorage(), NewMemoryStorage())
+	require.NoError(t, err)
+
+	storages := map[string]Storage{
+		"memory":   NewMemoryStorage(),
+		"lru":      lruStorage,
+		"level":    levelStorage,
+		"composed": composedStorage,
+	}
+	for name, storage := range storages {
+		_, err := storage.Get("missing")
+		require.True(t, errors.Is(err, ErrNotFound), "%s: expected ErrNotFound, got %v", name, err)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	memoryStorage := NewMemoryStorage()
+	storage := WithContext(memoryStorage)
+
+	require.NoError(t, storage.PutContext(context.Background(), "key1", []byte("value1")))
+	value, err := storage.GetContext(context.Background(), "key1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), value)
+
+	_, err = storage.GetContext(context.Background(), "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Nothing is done once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, storage.PutContext(ctx, "key2", []byte("value2")), context.Canceled)
+	_, err = storage.GetContext(ctx, "key1")
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, storage.DeleteContext(ctx, "key1"), context.Canceled)
+	require.ErrorIs(t, storage.ClearContext(ctx), context.Canceled)
+	_, err = storage.IteratorContext(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = memoryStorage.Get("key2")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = memoryStorage.Get("key1")
+	require.NoError(t, err)
+
+	// Storages that take a context themselves are not adapted
+	composedStorage, err := NewComposedStorage(NewMemoryStorage(), NewMemoryStorage())
+	require.NoError(t, err)
+	require.Same(t, composedStorage, WithContext(composedStorage))
+}
+
+func TestWithContextIteratorCanceled(t *testing.T) {
+	memoryStorage := NewMemoryStorage()
+	for _, key := range []string{"key1", "key2", "key3"} {
+		require.NoError(t, memoryStorage.Put(key, []byte(key)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	iter, err := WithContext(memoryStorage).IteratorContext(ctx)
+	require.NoError(t, err)
+
+	// The iteration ends once the context is canceled
+	<-iter
+	cancel()
+	received := 0
+	for range iter {
+		received++
+	}
+	require.Less(t, received, 2)
+}
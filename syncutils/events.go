@@ -0,0 +1,52 @@
+package syncutils
+
+// eventBufferSize is the number of events buffered for a reader of Sync.Events.
+const eventBufferSize = 100
+
+// Event is a change in the replication state of a Sync, e.g. a peer joining or a head
+// being rejected. Entries are delivered separately on SyncedCh.
+type Event interface {
+	syncEvent()
+}
+
+// PeerJoined is emitted when a peer subscribes to the log's topic.
+type PeerJoined struct {
+	PeerID string
+}
+
+// PeerLeft is emitted when a peer unsubscribes from the log's topic or disconnects.
+type PeerLeft struct {
+	PeerID string
+}
+
+// HeadsReceived is emitted when heads received from a peer have been verified and passed on to SyncedCh.
+type HeadsReceived struct {
+	PeerID string
+	Heads  []string // Hashes of the accepted heads
+}
+
+// EntryRejected is emitted when a message or head received from a peer is not accepted.
+type EntryRejected struct {
+	Rejection
+}
+
+// SyncError is emitted when exchanging heads or reading from the topic fails.
+type SyncError struct {
+	PeerID string // Peer the error occurred with, empty if it is not specific to a peer
+	Err    error
+}
+
+func (PeerJoined) syncEvent()    {}
+func (PeerLeft) syncEvent()      {}
+func (HeadsReceived) syncEvent() {}
+func (EntryRejected) syncEvent() {}
+func (SyncError) syncEvent()     {}
+
+// emit sends the event to the Events channel. Events are dropped when the channel is
+// full, so that a slow reader misses status updates rather than stalling replication.
+func (s *Sync) emit(event Event) {
+	select {
+	case s.Events <- event:
+	default:
+	}
+}
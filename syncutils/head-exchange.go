@@ -0,0 +1,160 @@
+package syncutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// HeadsProtocolPrefix prefixes the address of a log to form the protocol its heads are exchanged over.
+const HeadsProtocolPrefix = "/orbitdb/heads"
+
+// headExchangeTimeout bounds how long a heads exchange with a single peer may take.
+const headExchangeTimeout = 30 * time.Second
+
+// maxMessageSize is the largest heads message accepted from a peer.
+const maxMessageSize = 4 << 20
+
+// HeadsProtocol returns the stream protocol the heads of the log with the given ID are exchanged over,
+// e.g. /orbitdb/heads/orbitdb/zdpu... for the log of /orbitdb/zdpu...
+func HeadsProtocol(logID string) protocol.ID {
+	if !strings.HasPrefix(logID, "/") {
+		logID = "/" + logID
+	}
+	return protocol.ID(HeadsProtocolPrefix + logID)
+}
+
+// exchangeHeads opens a heads stream to the peer, sends our heads and receives the peer's.
+func (s *Sync) exchangeHeads(peerID string) error {
+	stream, err := s.transport.NewStream(s.ctx, peerID, s.protocol)
+	if err != nil {
+		return fmt.Errorf("failed to open heads stream: %w", err)
+	}
+	defer stream.Close()
+
+	if err := stream.SetDeadline(time.Now().Add(headExchangeTimeout)); err != nil {
+		stream.Reset()
+		return fmt.Errorf("failed to set heads stream deadline: %w", err)
+	}
+
+	if err := s.writeHeads(stream); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	return s.readHeads(peerID, stream)
+}
+
+// handleStream passes a heads stream opened by a peer on to handleHeadsStream while the Sync
+// is running, so that stopping it waits for the exchange to end.
+func (s *Sync) handleStream(peerID string, stream Stream) {
+	s.state.RLock()
+	if !s.running {
+		s.state.RUnlock()
+		stream.Reset()
+		return
+	}
+	s.wg.Add(1)
+	s.state.RUnlock()
+
+	defer s.wg.Done()
+	s.handleHeadsStream(peerID, stream)
+}
+
+// handleHeadsStream answers a heads exchange opened by a peer: it receives the peer's heads
+// and replies with ours.
+func (s *Sync) handleHeadsStream(peerID string, stream Stream) {
+	defer stream.Close()
+
+	if err := stream.SetDeadline(time.Now().Add(headExchangeTimeout)); err != nil {
+		stream.Reset()
+		return
+	}
+
+	if err := s.readHeads(peerID, stream); err != nil {
+		s.emit(SyncError{PeerID: peerID, Err: fmt.Errorf("failed to receive heads: %w", err)})
+		stream.Reset()
+		return
+	}
+
+	if err := s.writeHeads(stream); err != nil {
+		s.emit(SyncError{PeerID: peerID, Err: err})
+		stream.Reset()
+	}
+}
+
+// writeHeads sends the heads of the log as a single length-prefixed heads message and
+// closes the writing side of the stream.
+func (s *Sync) writeHeads(stream Stream) error {
+	heads := s.log.Heads()
+	data, err := encodeHeadsMessage(heads, s.headIdentities(heads))
+	if err != nil {
+		return fmt.Errorf("failed to encode heads: %w", err)
+	}
+
+	w := bufio.NewWriter(stream)
+	if err := writeFrame(w, data); err != nil {
+		return fmt.Errorf("failed to send heads: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to send heads: %w", err)
+	}
+	return stream.CloseWrite()
+}
+
+// readHeads reads the heads message sent by the peer and passes the heads on to receiveHeads.
+// A peer without heads may close its writing side without sending a message.
+func (s *Sync) readHeads(peerID string, stream Stream) error {
+	data, err := readFrame(bufio.NewReader(stream))
+	if errors.Is(err, io.EOF) {
+		s.advertise(peerID, nil, nil, true)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read heads: %w", err)
+	}
+
+	heads, identities, err := decodeHeadsMessage(data)
+	if err != nil {
+		err = fmt.Errorf("failed to decode heads: %w", err)
+		s.reject(Rejection{PeerID: peerID, Err: err})
+		return err
+	}
+	s.storeIdentities(peerID, identities)
+	s.receiveHeads(peerID, heads, true)
+	return nil
+}
+
+// writeFrame writes the data prefixed with its length as an unsigned varint.
+func writeFrame(w io.Writer, data []byte) error {
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(data)))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame. It returns io.EOF when the stream ends before a frame.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the maximum of %d bytes", size, maxMessageSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
@@ -0,0 +1,85 @@
+package syncutils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/syncutils"
+)
+
+// setupSyncPeer creates a loopback libp2p host with pubsub and a Sync for the log.
+func setupSyncPeer(t *testing.T, log *oplog.Log, options ...syncutils.Option) (host.Host, *syncutils.Sync) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err, "Failed to create libp2p host")
+	t.Cleanup(func() { h.Close() })
+
+	// Flood publishing delivers heads added before the first heartbeat has built the mesh
+	ps, err := pubsub.NewGossipSub(context.Background(), h, pubsub.WithFloodPublish(true))
+	require.NoError(t, err, "Failed to create GossipSub instance")
+
+	sync := syncutils.NewSync(syncutils.NewLibp2pTransport(h, ps), log, options...)
+	require.NoError(t, sync.Start())
+	t.Cleanup(sync.Stop)
+
+	return h, sync
+}
+
+// receiveHeads collects the hashes of the heads received on the channel until the count is reached.
+func receiveHeads(t *testing.T, sync *syncutils.Sync, count int) map[string]syncutils.SyncedEntry {
+	t.Helper()
+
+	received := make(map[string]syncutils.SyncedEntry)
+	for len(received) < count {
+		synced, ok := nextEntry(t, sync.SyncedCh, 5*time.Second)
+		require.True(t, ok, "Timeout waiting for heads, received %d of %d", len(received), count)
+		received[synced.Entry.Hash] = synced
+	}
+	return received
+}
+
+func TestHeadsProtocol(t *testing.T) {
+	assert.Equal(t, "/orbitdb/heads/orbitdb/zdpuAbc", string(syncutils.HeadsProtocol("/orbitdb/zdpuAbc")))
+	assert.Equal(t, "/orbitdb/heads/shared-log", string(syncutils.HeadsProtocol("shared-log")))
+}
+
+func TestSyncExchangesHeadsOnJoin(t *testing.T) {
+	logSelf := createMockLog(t, "shared-log", "self-identity")
+	logPeer := createMockLog(t, "shared-log", "peer-identity")
+
+	// Both replicas have entries before they meet
+	selfHead, err := logSelf.Append("self-entry")
+	require.NoError(t, err)
+	_, err = logPeer.Append("peer-entry1")
+	require.NoError(t, err)
+	peerHead, err := logPeer.Append("peer-entry2")
+	require.NoError(t, err)
+
+	hostSelf, syncSelf := setupSyncPeer(t, logSelf)
+	hostPeer, syncPeer := setupSyncPeer(t, logPeer)
+
+	require.NoError(t, hostSelf.Connect(context.Background(), peer.AddrInfo{ID: hostPeer.ID(), Addrs: hostPeer.Addrs()}))
+
+	// Each side receives the other's full heads set directly, without anything being published
+	receivedBySelf := receiveHeads(t, syncSelf, 1)
+	require.Contains(t, receivedBySelf, peerHead.Hash)
+	assert.Equal(t, hostPeer.ID().String(), receivedBySelf[peerHead.Hash].PeerID)
+
+	receivedByPeer := receiveHeads(t, syncPeer, 1)
+	require.Contains(t, receivedByPeer, selfHead.Hash)
+	assert.Equal(t, hostSelf.ID().String(), receivedByPeer[selfHead.Hash].PeerID)
+
+	received := waitForEvent[syncutils.HeadsReceived](t, syncSelf, time.Second)
+	assert.Equal(t, syncutils.HeadsReceived{PeerID: hostPeer.ID().String(), Heads: []string{peerHead.Hash}}, received)
+
+	// The received heads are the signed entries exactly as they were appended
+	assert.Equal(t, peerHead.Bytes, receivedBySelf[peerHead.Hash].Entry.Bytes)
+	assert.Equal(t, "peer-entry2", receivedBySelf[peerHead.Hash].Entry.Payload)
+}
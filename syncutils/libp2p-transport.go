@@ -0,0 +1,136 @@
+package syncutils
+
+import (
+	"context"
+	"fmt"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Libp2pTransport is a Transport over a libp2p host, publishing to its PubSub topics and
+// opening libp2p streams.
+type Libp2pTransport struct {
+	host   host.Host
+	pubsub *pubsub.PubSub
+}
+
+// NewLibp2pTransport creates a Transport over the libp2p host and PubSub instance.
+func NewLibp2pTransport(host host.Host, pubsub *pubsub.PubSub) *Libp2pTransport {
+	return &Libp2pTransport{host: host, pubsub: pubsub}
+}
+
+// ID returns the ID of the libp2p host.
+func (t *Libp2pTransport) ID() string {
+	return t.host.ID().String()
+}
+
+// Join joins the PubSub topic.
+func (t *Libp2pTransport) Join(topic string) (Topic, error) {
+	joined, err := t.pubsub.Join(topic)
+	if err != nil {
+		return nil, err
+	}
+	return &libp2pTopic{topic: joined}, nil
+}
+
+// SetStreamHandler handles the libp2p streams opened with the protocol.
+func (t *Libp2pTransport) SetStreamHandler(protocol protocol.ID, handler StreamHandler) {
+	t.host.SetStreamHandler(protocol, func(stream network.Stream) {
+		handler(stream.Conn().RemotePeer().String(), stream)
+	})
+}
+
+// RemoveStreamHandler stops handling the libp2p streams opened with the protocol.
+func (t *Libp2pTransport) RemoveStreamHandler(protocol protocol.ID) {
+	t.host.RemoveStreamHandler(protocol)
+}
+
+// NewStream opens a libp2p stream with the protocol to the peer.
+func (t *Libp2pTransport) NewStream(ctx context.Context, peerID string, protocol protocol.ID) (Stream, error) {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID %q: %w", peerID, err)
+	}
+	return t.host.NewStream(ctx, id, protocol)
+}
+
+// libp2pTopic is a joined PubSub topic.
+type libp2pTopic struct {
+	topic *pubsub.Topic
+}
+
+func (t *libp2pTopic) Publish(ctx context.Context, data []byte) error {
+	return t.topic.Publish(ctx, data)
+}
+
+func (t *libp2pTopic) Subscribe() (Subscription, error) {
+	sub, err := t.topic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	return &libp2pSubscription{sub: sub}, nil
+}
+
+func (t *libp2pTopic) PeerEvents() (PeerEvents, error) {
+	handler, err := t.topic.EventHandler()
+	if err != nil {
+		return nil, err
+	}
+	return &libp2pPeerEvents{handler: handler}, nil
+}
+
+func (t *libp2pTopic) ListPeers() []string {
+	peers := t.topic.ListPeers()
+	ids := make([]string, len(peers))
+	for i, id := range peers {
+		ids[i] = id.String()
+	}
+	return ids
+}
+
+func (t *libp2pTopic) Close() error {
+	return t.topic.Close()
+}
+
+// libp2pSubscription is a subscription to a PubSub topic.
+type libp2pSubscription struct {
+	sub *pubsub.Subscription
+}
+
+func (s *libp2pSubscription) Next(ctx context.Context) (*Message, error) {
+	msg, err := s.sub.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{From: msg.GetFrom().String(), Data: msg.Data}, nil
+}
+
+func (s *libp2pSubscription) Cancel() {
+	s.sub.Cancel()
+}
+
+// libp2pPeerEvents follows the peers joining and leaving a PubSub topic.
+type libp2pPeerEvents struct {
+	handler *pubsub.TopicEventHandler
+}
+
+func (e *libp2pPeerEvents) Next(ctx context.Context) (PeerEvent, error) {
+	event, err := e.handler.NextPeerEvent(ctx)
+	if err != nil {
+		return PeerEvent{}, err
+	}
+
+	eventType := PeerJoin
+	if event.Type == pubsub.PeerLeave {
+		eventType = PeerLeave
+	}
+	return PeerEvent{Type: eventType, PeerID: event.Peer.String()}, nil
+}
+
+func (e *libp2pPeerEvents) Cancel() {
+	e.handler.Cancel()
+}
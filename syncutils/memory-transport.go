@@ -0,0 +1,632 @@
+package syncutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// errStreamReset is returned by the reads and writes of a memory stream that has been reset.
+var errStreamReset = errors.New("stream reset")
+
+// MemoryNetwork connects MemoryTransports within a single process, so that replicas can be
+// synchronized without libp2p networking. It can simulate latency, message loss and network
+// partitions. Messages published to a topic are subject to the latency and may be lost,
+// while streams are opened after the latency and are reliable, like a libp2p connection.
+type MemoryNetwork struct {
+	mu       sync.Mutex
+	peers    map[string]*MemoryTransport
+	groups   map[string]int // Partition group of each peer, peers that are not listed are in group 0
+	latency  time.Duration
+	lossRate float64
+	rand     *rand.Rand
+}
+
+// MemoryNetworkOption configures optional settings of a MemoryNetwork.
+type MemoryNetworkOption func(*MemoryNetwork)
+
+// WithLatency delays the delivery of every message and the opening of every stream.
+func WithLatency(latency time.Duration) MemoryNetworkOption {
+	return func(n *MemoryNetwork) {
+		n.latency = latency
+	}
+}
+
+// WithMessageLoss drops each message published to a topic for each receiving peer with
+// the given probability, between 0 and 1.
+func WithMessageLoss(rate float64) MemoryNetworkOption {
+	return func(n *MemoryNetwork) {
+		n.lossRate = rate
+	}
+}
+
+// WithSeed seeds the random numbers that decide which messages are lost, so that a test
+// loses the same messages on every run. The default seed is 1.
+func WithSeed(seed int64) MemoryNetworkOption {
+	return func(n *MemoryNetwork) {
+		n.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// NewMemoryNetwork creates an in-memory network without any peers.
+func NewMemoryNetwork(options ...MemoryNetworkOption) *MemoryNetwork {
+	n := &MemoryNetwork{
+		peers:  make(map[string]*MemoryTransport),
+		groups: make(map[string]int),
+		rand:   rand.New(rand.NewSource(1)),
+	}
+	for _, option := range options {
+		option(n)
+	}
+	return n
+}
+
+// NewTransport adds a peer with the given ID to the network and returns its transport.
+func (n *MemoryNetwork) NewTransport(id string) (*MemoryTransport, error) {
+	if id == "" {
+		return nil, errors.New("peer ID is required")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.peers[id]; ok {
+		return nil, fmt.Errorf("peer %s already exists", id)
+	}
+
+	t := &MemoryTransport{
+		network:  n,
+		id:       id,
+		topics:   make(map[string]*memoryTopic),
+		handlers: make(map[protocol.ID]StreamHandler),
+	}
+	n.peers[id] = t
+	return t, nil
+}
+
+// Partition splits the network into the groups of peer IDs. Peers in different groups cannot
+// reach each other: they leave each other's topics and cannot open streams to each other.
+// Peers that are not listed form one more group. A new partition replaces the previous one.
+func (n *MemoryNetwork) Partition(groups ...[]string) {
+	n.update(func() {
+		n.groups = make(map[string]int)
+		for i, group := range groups {
+			for _, id := range group {
+				n.groups[id] = i + 1
+			}
+		}
+	})
+}
+
+// Heal reconnects all peers, which join each other's topics again.
+func (n *MemoryNetwork) Heal() {
+	n.update(func() {
+		n.groups = make(map[string]int)
+	})
+}
+
+// reachableLocked reports whether the peers can reach each other. The caller must hold the lock.
+func (n *MemoryNetwork) reachableLocked(a, b string) bool {
+	return n.groups[a] == n.groups[b]
+}
+
+// visibility is a peer that another peer sees subscribed to a topic.
+type visibility struct {
+	topic    string
+	observer string
+	peer     string
+}
+
+// topicEvent is a peer that an observer sees joining or leaving a topic.
+type topicEvent struct {
+	visibility
+	eventType PeerEventType
+}
+
+// visibleLocked lists, for every peer that joined a topic, the reachable peers subscribed to
+// it. The caller must hold the lock.
+func (n *MemoryNetwork) visibleLocked() map[visibility]bool {
+	visible := make(map[visibility]bool)
+	for _, observer := range n.peers {
+		for name := range observer.topics {
+			for _, other := range n.peers {
+				if other == observer || !n.reachableLocked(observer.id, other.id) {
+					continue
+				}
+				if topic, ok := other.topics[name]; ok && len(topic.subs) > 0 {
+					visible[visibility{topic: name, observer: observer.id, peer: other.id}] = true
+				}
+			}
+		}
+	}
+	return visible
+}
+
+// update applies a change to the network and reports the peers that joined or left a topic
+// because of it to the peer events of every observer.
+func (n *MemoryNetwork) update(change func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	before := n.visibleLocked()
+	change()
+	after := n.visibleLocked()
+
+	var events []topicEvent
+	for v := range before {
+		if !after[v] {
+			events = append(events, topicEvent{v, PeerLeave})
+		}
+	}
+	for v := range after {
+		if !before[v] {
+			events = append(events, topicEvent{v, PeerJoin})
+		}
+	}
+
+	// Report the changes in the same order on every run
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.topic != b.topic {
+			return a.topic < b.topic
+		}
+		if a.observer != b.observer {
+			return a.observer < b.observer
+		}
+		if a.eventType != b.eventType {
+			return a.eventType > b.eventType // Leaves first
+		}
+		return a.peer < b.peer
+	})
+
+	now := time.Now()
+	for _, event := range events {
+		observer, ok := n.peers[event.observer]
+		if !ok {
+			continue
+		}
+		if topic, ok := observer.topics[event.topic]; ok {
+			for handler := range topic.events {
+				handler.queue.push(PeerEvent{Type: event.eventType, PeerID: event.peer}, now)
+			}
+		}
+	}
+}
+
+// MemoryTransport is the Transport of a peer on a MemoryNetwork.
+type MemoryTransport struct {
+	network  *MemoryNetwork
+	id       string
+	topics   map[string]*memoryTopic // Guarded by the network's lock
+	handlers map[protocol.ID]StreamHandler
+}
+
+// ID returns the ID the peer was added to the network with.
+func (t *MemoryTransport) ID() string {
+	return t.id
+}
+
+// Join joins the topic. A peer can only join a topic once until it closes it.
+func (t *MemoryTransport) Join(name string) (Topic, error) {
+	n := t.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.peers[t.id] != t {
+		return nil, ErrTransportClosed
+	}
+	if _, ok := t.topics[name]; ok {
+		return nil, fmt.Errorf("topic %s already exists", name)
+	}
+
+	topic := &memoryTopic{
+		transport: t,
+		name:      name,
+		subs:      make(map[*memorySubscription]struct{}),
+		events:    make(map[*memoryPeerEvents]struct{}),
+	}
+	t.topics[name] = topic
+	return topic, nil
+}
+
+// SetStreamHandler handles the streams peers open with the protocol.
+func (t *MemoryTransport) SetStreamHandler(protocol protocol.ID, handler StreamHandler) {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.handlers[protocol] = handler
+}
+
+// RemoveStreamHandler stops handling the streams opened with the protocol.
+func (t *MemoryTransport) RemoveStreamHandler(protocol protocol.ID) {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	delete(t.handlers, protocol)
+}
+
+// NewStream opens a stream with the protocol to a reachable peer handling it.
+func (t *MemoryTransport) NewStream(ctx context.Context, peerID string, protocol protocol.ID) (Stream, error) {
+	n := t.network
+	n.mu.Lock()
+	remote, ok := n.peers[peerID]
+	if !ok || n.peers[t.id] != t {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("peer %s is not on the network", peerID)
+	}
+	if !n.reachableLocked(t.id, peerID) {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("peer %s is unreachable", peerID)
+	}
+	handler, ok := remote.handlers[protocol]
+	latency := n.latency
+	n.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("peer %s does not support protocol %s", peerID, protocol)
+	}
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	local, accepted := newMemoryStreamPair()
+	go handler(t.id, accepted)
+	return local, nil
+}
+
+// Close removes the peer from the network. The other peers see it leave its topics.
+func (t *MemoryTransport) Close() error {
+	var closed []*memoryTopic
+	t.network.update(func() {
+		if t.network.peers[t.id] != t {
+			return
+		}
+		delete(t.network.peers, t.id)
+		for _, topic := range t.topics {
+			closed = append(closed, topic)
+		}
+		t.topics = make(map[string]*memoryTopic)
+	})
+
+	for _, topic := range closed {
+		topic.closeQueues()
+	}
+	return nil
+}
+
+// memoryTopic is a topic joined by a MemoryTransport. Its subscriptions and peer events are
+// guarded by the network's lock.
+type memoryTopic struct {
+	transport *MemoryTransport
+	name      string
+	subs      map[*memorySubscription]struct{}
+	events    map[*memoryPeerEvents]struct{}
+}
+
+// Publish delivers the data to the subscriptions of the reachable peers subscribed to the
+// topic, including our own, after the network's latency. Each peer may lose the message.
+func (t *memoryTopic) Publish(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	n := t.transport.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if t.transport.topics[t.name] != t {
+		return ErrTransportClosed
+	}
+
+	now := time.Now()
+	for _, peer := range n.peers {
+		topic, ok := peer.topics[t.name]
+		if !ok || len(topic.subs) == 0 {
+			continue
+		}
+
+		at := now
+		if peer != t.transport {
+			if !n.reachableLocked(t.transport.id, peer.id) || n.rand.Float64() < n.lossRate {
+				continue
+			}
+			at = now.Add(n.latency)
+		}
+
+		for sub := range topic.subs {
+			msg := &Message{From: t.transport.id, Data: append([]byte(nil), data...)}
+			sub.queue.push(msg, at)
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the messages published to the topic. The other peers see us join the
+// topic with the first subscription.
+func (t *memoryTopic) Subscribe() (Subscription, error) {
+	sub := &memorySubscription{topic: t, queue: newMemoryQueue[*Message]()}
+
+	var err error
+	t.transport.network.update(func() {
+		if t.transport.topics[t.name] != t {
+			err = ErrTransportClosed
+			return
+		}
+		t.subs[sub] = struct{}{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// PeerEvents returns the reachable peers joining and leaving the topic, starting with the
+// peers subscribed to it now.
+func (t *memoryTopic) PeerEvents() (PeerEvents, error) {
+	n := t.transport.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if t.transport.topics[t.name] != t {
+		return nil, ErrTransportClosed
+	}
+
+	events := &memoryPeerEvents{topic: t, queue: newMemoryQueue[PeerEvent]()}
+	now := time.Now()
+	for _, id := range t.listPeersLocked() {
+		events.queue.push(PeerEvent{Type: PeerJoin, PeerID: id}, now)
+	}
+	t.events[events] = struct{}{}
+	return events, nil
+}
+
+// ListPeers lists the reachable peers subscribed to the topic, sorted by ID.
+func (t *memoryTopic) ListPeers() []string {
+	n := t.transport.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return t.listPeersLocked()
+}
+
+func (t *memoryTopic) listPeersLocked() []string {
+	n := t.transport.network
+	var peers []string
+	for v := range n.visibleLocked() {
+		if v.topic == t.name && v.observer == t.transport.id {
+			peers = append(peers, v.peer)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Close leaves the topic, so that it can be joined again.
+func (t *memoryTopic) Close() error {
+	n := t.transport.network
+	n.update(func() {
+		if t.transport.topics[t.name] == t {
+			delete(t.transport.topics, t.name)
+		}
+	})
+	t.closeQueues()
+	return nil
+}
+
+// closeQueues ends the subscriptions and peer events of a topic that was left.
+func (t *memoryTopic) closeQueues() {
+	n := t.transport.network
+	n.mu.Lock()
+	subs, events := t.subs, t.events
+	t.subs = make(map[*memorySubscription]struct{})
+	t.events = make(map[*memoryPeerEvents]struct{})
+	n.mu.Unlock()
+
+	for sub := range subs {
+		sub.queue.close()
+	}
+	for handler := range events {
+		handler.queue.close()
+	}
+}
+
+// memorySubscription receives the messages published to a memory topic.
+type memorySubscription struct {
+	topic *memoryTopic
+	queue *memoryQueue[*Message]
+}
+
+func (s *memorySubscription) Next(ctx context.Context) (*Message, error) {
+	return s.queue.next(ctx)
+}
+
+func (s *memorySubscription) Cancel() {
+	s.topic.transport.network.update(func() {
+		delete(s.topic.subs, s)
+	})
+	s.queue.close()
+}
+
+// memoryPeerEvents receives the peers joining and leaving a memory topic.
+type memoryPeerEvents struct {
+	topic *memoryTopic
+	queue *memoryQueue[PeerEvent]
+}
+
+func (e *memoryPeerEvents) Next(ctx context.Context) (PeerEvent, error) {
+	return e.queue.next(ctx)
+}
+
+func (e *memoryPeerEvents) Cancel() {
+	n := e.topic.transport.network
+	n.mu.Lock()
+	delete(e.topic.events, e)
+	n.mu.Unlock()
+	e.queue.close()
+}
+
+// memoryQueue is an unbounded queue of items that become available at a given time, in the
+// order they were pushed.
+type memoryQueue[T any] struct {
+	mu     sync.Mutex
+	items  []queuedItem[T]
+	pushed chan struct{} // Closed when an item is pushed or the queue is closed
+	closed bool
+}
+
+// queuedItem is an item that becomes available at the given time.
+type queuedItem[T any] struct {
+	item T
+	at   time.Time
+}
+
+func newMemoryQueue[T any]() *memoryQueue[T] {
+	return &memoryQueue[T]{pushed: make(chan struct{})}
+}
+
+// push adds an item that becomes available at the given time.
+func (q *memoryQueue[T]) push(item T, at time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.items = append(q.items, queuedItem[T]{item: item, at: at})
+	close(q.pushed)
+	q.pushed = make(chan struct{})
+}
+
+// next waits until the first item is available and removes it from the queue.
+func (q *memoryQueue[T]) next(ctx context.Context) (T, error) {
+	var zero T
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return zero, ErrTransportClosed
+		}
+
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if len(q.items) > 0 {
+			delay := time.Until(q.items[0].at)
+			if delay <= 0 {
+				item := q.items[0].item
+				q.items = q.items[1:]
+				q.mu.Unlock()
+				return item, nil
+			}
+			timer = time.NewTimer(delay)
+			wait = timer.C
+		}
+		pushed := q.pushed
+		q.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-pushed:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return zero, err
+		}
+	}
+}
+
+// close ends the queue, discarding the items that were not taken.
+func (q *memoryQueue[T]) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.items = nil
+		close(q.pushed)
+	}
+}
+
+// memoryStream is one end of a pair of connected in-memory streams.
+type memoryStream struct {
+	r        *io.PipeReader
+	w        *io.PipeWriter
+	mu       sync.Mutex
+	deadline *time.Timer
+}
+
+// newMemoryStreamPair creates two streams that read what the other writes.
+func newMemoryStreamPair() (*memoryStream, *memoryStream) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	return &memoryStream{r: r1, w: w2}, &memoryStream{r: r2, w: w1}
+}
+
+func (s *memoryStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *memoryStream) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// CloseWrite closes the writing side, the other end reads io.EOF.
+func (s *memoryStream) CloseWrite() error {
+	return s.w.Close()
+}
+
+// Close closes both sides of the stream.
+func (s *memoryStream) Close() error {
+	s.stopDeadline()
+	s.w.Close()
+	return s.r.Close()
+}
+
+// Reset aborts the stream, failing the reads and writes of both ends.
+func (s *memoryStream) Reset() error {
+	s.stopDeadline()
+	s.abort(errStreamReset)
+	return nil
+}
+
+// SetDeadline aborts the stream at the given time. A zero time removes the deadline.
+func (s *memoryStream) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deadline != nil {
+		s.deadline.Stop()
+		s.deadline = nil
+	}
+	if !t.IsZero() {
+		s.deadline = time.AfterFunc(time.Until(t), func() {
+			s.abort(os.ErrDeadlineExceeded)
+		})
+	}
+	return nil
+}
+
+func (s *memoryStream) stopDeadline() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deadline != nil {
+		s.deadline.Stop()
+		s.deadline = nil
+	}
+}
+
+func (s *memoryStream) abort(err error) {
+	s.w.CloseWithError(err)
+	s.r.CloseWithError(err)
+}
@@ -0,0 +1,245 @@
+package syncutils_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/syncutils"
+)
+
+// newMemoryTransport adds a peer to the network that is removed when the test ends.
+func newMemoryTransport(t *testing.T, network *syncutils.MemoryNetwork, id string) *syncutils.MemoryTransport {
+	transport, err := network.NewTransport(id)
+	require.NoError(t, err)
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+// subscribe joins and subscribes to the topic.
+func subscribe(t *testing.T, transport syncutils.Transport, name string) (syncutils.Topic, syncutils.Subscription) {
+	topic, err := transport.Join(name)
+	require.NoError(t, err)
+	sub, err := topic.Subscribe()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sub.Cancel()
+		topic.Close()
+	})
+	return topic, sub
+}
+
+// nextMessage returns the next message, or nil if none is received within the timeout.
+func nextMessage(t *testing.T, sub syncutils.Subscription, timeout time.Duration) *syncutils.Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	msg, err := sub.Next(ctx)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// nextPeerEvent returns the next peer event, failing the test if none is received within a second.
+func nextPeerEvent(t *testing.T, events syncutils.PeerEvents) syncutils.PeerEvent {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	event, err := events.Next(ctx)
+	require.NoError(t, err, "Timeout waiting for peer event")
+	return event
+}
+
+// setupMemorySyncPeer creates a Sync for the log on a new peer of the network.
+func setupMemorySyncPeer(t *testing.T, network *syncutils.MemoryNetwork, id string, log *oplog.Log) *syncutils.Sync {
+	sync := syncutils.NewSync(newMemoryTransport(t, network, id), log)
+	require.NoError(t, sync.Start())
+	t.Cleanup(sync.Stop)
+	return sync
+}
+
+func TestMemoryNetworkPublish(t *testing.T) {
+	network := syncutils.NewMemoryNetwork()
+	a := newMemoryTransport(t, network, "a")
+	b := newMemoryTransport(t, network, "b")
+
+	topicA, subA := subscribe(t, a, "topic")
+	_, subB := subscribe(t, b, "topic")
+
+	require.NoError(t, topicA.Publish(context.Background(), []byte("hello")))
+
+	// Like PubSub, the message is also delivered to the publisher
+	assert.Equal(t, &syncutils.Message{From: "a", Data: []byte("hello")}, nextMessage(t, subB, time.Second))
+	assert.Equal(t, &syncutils.Message{From: "a", Data: []byte("hello")}, nextMessage(t, subA, time.Second))
+	assert.Equal(t, []string{"b"}, topicA.ListPeers())
+
+	_, err := a.Join("topic")
+	assert.Error(t, err, "Expected joining a topic twice to fail")
+	_, err = network.NewTransport("a")
+	assert.Error(t, err, "Expected a duplicate peer ID to fail")
+}
+
+func TestMemoryNetworkLatency(t *testing.T) {
+	network := syncutils.NewMemoryNetwork(syncutils.WithLatency(200 * time.Millisecond))
+	a := newMemoryTransport(t, network, "a")
+	b := newMemoryTransport(t, network, "b")
+
+	topicA, _ := subscribe(t, a, "topic")
+	_, subB := subscribe(t, b, "topic")
+
+	start := time.Now()
+	for _, data := range []string{"first", "second"} {
+		require.NoError(t, topicA.Publish(context.Background(), []byte(data)))
+	}
+
+	// The messages arrive after the latency, in the order they were published
+	assert.Nil(t, nextMessage(t, subB, 100*time.Millisecond))
+	first := nextMessage(t, subB, time.Second)
+	require.NotNil(t, first)
+	assert.Equal(t, "first", string(first.Data))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.Equal(t, "second", string(nextMessage(t, subB, time.Second).Data))
+}
+
+func TestMemoryNetworkMessageLoss(t *testing.T) {
+	network := syncutils.NewMemoryNetwork(syncutils.WithMessageLoss(0.5), syncutils.WithSeed(42))
+	a := newMemoryTransport(t, network, "a")
+	b := newMemoryTransport(t, network, "b")
+
+	topicA, _ := subscribe(t, a, "topic")
+	_, subB := subscribe(t, b, "topic")
+
+	const published = 100
+	for i := 0; i < published; i++ {
+		require.NoError(t, topicA.Publish(context.Background(), []byte{byte(i)}))
+	}
+
+	received := 0
+	for nextMessage(t, subB, 50*time.Millisecond) != nil {
+		received++
+	}
+	assert.Greater(t, received, 0, "Expected some messages to be delivered")
+	assert.Less(t, received, published, "Expected some messages to be lost")
+}
+
+func TestMemoryNetworkPartition(t *testing.T) {
+	network := syncutils.NewMemoryNetwork()
+	a := newMemoryTransport(t, network, "a")
+	b := newMemoryTransport(t, network, "b")
+
+	topicA, _ := subscribe(t, a, "topic")
+	_, subB := subscribe(t, b, "topic")
+
+	events, err := topicA.PeerEvents()
+	require.NoError(t, err)
+	defer events.Cancel()
+
+	// The peers already subscribed are reported first
+	assert.Equal(t, syncutils.PeerEvent{Type: syncutils.PeerJoin, PeerID: "b"}, nextPeerEvent(t, events))
+
+	network.Partition([]string{"a"}, []string{"b"})
+	assert.Equal(t, syncutils.PeerEvent{Type: syncutils.PeerLeave, PeerID: "b"}, nextPeerEvent(t, events))
+	assert.Empty(t, topicA.ListPeers())
+
+	// Neither messages nor streams cross the partition
+	b.SetStreamHandler("/test", func(peerID string, stream syncutils.Stream) { stream.Close() })
+	require.NoError(t, topicA.Publish(context.Background(), []byte("lost")))
+	assert.Nil(t, nextMessage(t, subB, 100*time.Millisecond))
+	_, err = a.NewStream(context.Background(), "b", "/test")
+	assert.Error(t, err)
+
+	network.Heal()
+	assert.Equal(t, syncutils.PeerEvent{Type: syncutils.PeerJoin, PeerID: "b"}, nextPeerEvent(t, events))
+
+	// Closing the transport leaves the network
+	require.NoError(t, b.Close())
+	assert.Equal(t, syncutils.PeerEvent{Type: syncutils.PeerLeave, PeerID: "b"}, nextPeerEvent(t, events))
+}
+
+func TestMemoryNetworkStream(t *testing.T) {
+	network := syncutils.NewMemoryNetwork()
+	a := newMemoryTransport(t, network, "a")
+	b := newMemoryTransport(t, network, "b")
+
+	received := make(chan string, 1)
+	b.SetStreamHandler("/echo", func(peerID string, stream syncutils.Stream) {
+		defer stream.Close()
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			stream.Reset()
+			return
+		}
+		received <- peerID
+		stream.Write(data)
+	})
+
+	stream, err := a.NewStream(context.Background(), "b", "/echo")
+	require.NoError(t, err)
+	defer stream.Close()
+
+	_, err = stream.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseWrite())
+
+	reply, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+	assert.Equal(t, "a", <-received)
+
+	_, err = a.NewStream(context.Background(), "b", "/unknown")
+	assert.Error(t, err, "Expected a protocol without handler to fail")
+
+	// A stream past its deadline fails
+	b.SetStreamHandler("/silent", func(peerID string, stream syncutils.Stream) {})
+	stream, err = a.NewStream(context.Background(), "b", "/silent")
+	require.NoError(t, err)
+	require.NoError(t, stream.SetDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = stream.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestSyncOverMemoryNetwork(t *testing.T) {
+	network := syncutils.NewMemoryNetwork(syncutils.WithLatency(10 * time.Millisecond))
+
+	logSelf := createMockLog(t, "shared-log", "self-identity")
+	logPeer := createMockLog(t, "shared-log", "peer-identity")
+
+	peerHead, err := logPeer.Append("peer-entry")
+	require.NoError(t, err)
+
+	syncSelf := setupMemorySyncPeer(t, network, "self", logSelf)
+	syncPeer := setupMemorySyncPeer(t, network, "peer", logPeer)
+
+	// The heads are exchanged when the peers join the topic
+	synced, ok := nextEntry(t, syncSelf.SyncedCh, time.Second)
+	require.True(t, ok, "Timeout waiting for the exchanged head")
+	assert.Equal(t, peerHead.Hash, synced.Entry.Hash)
+	assert.Equal(t, "peer", synced.PeerID)
+
+	// New heads are published on the topic
+	selfHead, err := logSelf.Append("self-entry")
+	require.NoError(t, err)
+	require.NoError(t, syncSelf.Add(selfHead))
+
+	synced, ok = nextEntry(t, syncPeer.SyncedCh, time.Second)
+	require.True(t, ok, "Timeout waiting for the published head")
+	assert.Equal(t, selfHead.Hash, synced.Entry.Hash)
+	assert.Equal(t, "self", synced.PeerID)
+
+	// A partitioned peer leaves, and exchanges heads again when it comes back
+	network.Partition([]string{"self"}, []string{"peer"})
+	assert.Equal(t, syncutils.PeerLeft{PeerID: "peer"}, waitForEvent[syncutils.PeerLeft](t, syncSelf, time.Second))
+
+	network.Heal()
+	assert.Equal(t, syncutils.PeerJoined{PeerID: "peer"}, waitForEvent[syncutils.PeerJoined](t, syncSelf, time.Second))
+	synced, ok = nextEntry(t, syncSelf.SyncedCh, time.Second)
+	require.True(t, ok, "Timeout waiting for the heads exchanged after healing")
+	assert.Equal(t, peerHead.Hash, synced.Entry.Hash)
+}
@@ -0,0 +1,150 @@
+package syncutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"orbitdb/go-orbitdb/oplog"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// MessageVersion is the version of the heads message sent over pubsub and heads streams.
+const MessageVersion = 1
+
+// encodeHeadsMessage encodes the heads as a dag-cbor envelope of the form
+// {v: <version>, heads: [<encoded entry>, ...], identities: [<encoded identity>, ...]}.
+// The heads are sent as the exact bytes the entries were signed and hashed as, along with
+// the encoded identities that signed them. The identities are omitted when there are none.
+func encodeHeadsMessage(heads []*oplog.EncodedEntry, identities [][]byte) ([]byte, error) {
+	size := int64(2)
+	if len(identities) > 0 {
+		size++
+	}
+
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(size)
+	if err != nil {
+		return nil, err
+	}
+	if err := ma.AssembleKey().AssignString("v"); err != nil {
+		return nil, err
+	}
+	if err := ma.AssembleValue().AssignInt(MessageVersion); err != nil {
+		return nil, err
+	}
+
+	encoded := make([][]byte, 0, len(heads))
+	for _, head := range heads {
+		encoded = append(encoded, head.Bytes)
+	}
+	if err := assembleBytesList(ma, "heads", encoded); err != nil {
+		return nil, err
+	}
+	if len(identities) > 0 {
+		if err := assembleBytesList(ma, "identities", identities); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ma.Finish(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(nb.Build(), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// assembleBytesList assigns the values as a list of bytes to the key of the map.
+func assembleBytesList(ma datamodel.MapAssembler, key string, values [][]byte) error {
+	if err := ma.AssembleKey().AssignString(key); err != nil {
+		return err
+	}
+	la, err := ma.AssembleValue().BeginList(int64(len(values)))
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := la.AssembleValue().AssignBytes(value); err != nil {
+			return err
+		}
+	}
+	return la.Finish()
+}
+
+// decodeHeadsMessage decodes a heads message and the entries and encoded identities it
+// carries. The hash of each entry is computed from its bytes rather than taken from the sender.
+func decodeHeadsMessage(data []byte) ([]oplog.EncodedEntry, [][]byte, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, nil, err
+	}
+	node := nb.Build()
+
+	versionNode, err := node.LookupByString("v")
+	if err != nil {
+		return nil, nil, errors.New("invalid or missing 'v' field")
+	}
+	version, err := versionNode.AsInt()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid 'v' field: %w", err)
+	}
+	if version != MessageVersion {
+		return nil, nil, fmt.Errorf("unsupported message version %d", version)
+	}
+
+	headsNode, err := node.LookupByString("heads")
+	if err != nil {
+		return nil, nil, errors.New("invalid or missing 'heads' field")
+	}
+	encoded, err := bytesList(headsNode, "heads")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	heads := make([]oplog.EncodedEntry, 0, len(encoded))
+	for _, data := range encoded {
+		head, err := oplog.Decode(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode head: %w", err)
+		}
+		heads = append(heads, head)
+	}
+
+	// Identities are optional, peers may only send the heads
+	var identities [][]byte
+	if identitiesNode, err := node.LookupByString("identities"); err == nil {
+		if identities, err = bytesList(identitiesNode, "identities"); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return heads, identities, nil
+}
+
+// bytesList reads the list of bytes held by the field.
+func bytesList(node datamodel.Node, field string) ([][]byte, error) {
+	it := node.ListIterator()
+	if it == nil {
+		return nil, fmt.Errorf("invalid '%s' field: not a list", field)
+	}
+
+	values := make([][]byte, 0, node.Length())
+	for !it.Done() {
+		_, valueNode, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := valueNode.AsBytes()
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in '%s' field: %w", field, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
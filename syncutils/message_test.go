@@ -0,0 +1,106 @@
+package syncutils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/identities/providers"
+	"orbitdb/go-orbitdb/keystore"
+	"orbitdb/go-orbitdb/oplog"
+	"orbitdb/go-orbitdb/storage"
+)
+
+// appendEntries appends the payloads to a new log and returns the resulting entries.
+func appendEntries(t *testing.T, payloads ...string) []*oplog.EncodedEntry {
+	ks := keystore.NewKeyStore(storage.NewMemoryStorage())
+	identity, err := providers.NewPublicKeyProvider(ks).CreateIdentity("message-identity")
+	require.NoError(t, err)
+
+	log, err := oplog.NewLog("message-log", identity, storage.NewMemoryStorage(), ks)
+	require.NoError(t, err)
+
+	var entries []*oplog.EncodedEntry
+	for _, payload := range payloads {
+		entry, err := log.Append(payload)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// encodeRaw encodes a heads message with the given version and head bytes.
+func encodeRaw(t *testing.T, version int64, heads ...[]byte) []byte {
+	node, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "v", qp.Int(version))
+		qp.MapEntry(ma, "heads", qp.List(int64(len(heads)), func(la datamodel.ListAssembler) {
+			for _, head := range heads {
+				qp.ListEntry(la, qp.Bytes(head))
+			}
+		}))
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, dagcbor.Encode(node, &buf))
+	return buf.Bytes()
+}
+
+func TestHeadsMessageRoundTrip(t *testing.T) {
+	entries := appendEntries(t, "one", "two")
+
+	data, err := encodeHeadsMessage(entries, nil)
+	require.NoError(t, err)
+
+	heads, identities, err := decodeHeadsMessage(data)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+	require.Len(t, heads, 2)
+	for i, head := range heads {
+		assert.Equal(t, entries[i].Hash, head.Hash)
+		assert.Equal(t, entries[i].Bytes, head.Bytes)
+		assert.Equal(t, entries[i].Payload, head.Payload)
+	}
+}
+
+func TestHeadsMessageIdentities(t *testing.T) {
+	entries := appendEntries(t, "one")
+	identities := [][]byte{[]byte("first identity"), []byte("second identity")}
+
+	data, err := encodeHeadsMessage(entries, identities)
+	require.NoError(t, err)
+
+	heads, decoded, err := decodeHeadsMessage(data)
+	require.NoError(t, err)
+	require.Len(t, heads, 1)
+	assert.Equal(t, identities, decoded)
+}
+
+func TestHeadsMessageEmpty(t *testing.T) {
+	data, err := encodeHeadsMessage(nil, nil)
+	require.NoError(t, err)
+
+	heads, _, err := decodeHeadsMessage(data)
+	require.NoError(t, err)
+	assert.Empty(t, heads)
+}
+
+func TestHeadsMessageUnsupportedVersion(t *testing.T) {
+	entries := appendEntries(t, "one")
+
+	_, _, err := decodeHeadsMessage(encodeRaw(t, MessageVersion+1, entries[0].Bytes))
+	assert.ErrorContains(t, err, "unsupported message version")
+}
+
+func TestHeadsMessageInvalidHead(t *testing.T) {
+	_, _, err := decodeHeadsMessage(encodeRaw(t, MessageVersion, []byte("not an entry")))
+	assert.Error(t, err)
+
+	_, _, err = decodeHeadsMessage([]byte(`{"PeerID":"x","Entry":{}}`))
+	assert.Error(t, err, "Expected the old JSON message format to be rejected")
+}
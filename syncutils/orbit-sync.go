@@ -2,32 +2,74 @@ package syncutils
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"github.com/libp2p/go-libp2p/core/peer"
 	"log"
+	"orbitdb/go-orbitdb/identities/identitytypes"
 	"orbitdb/go-orbitdb/oplog"
 	"sync"
 	"time"
 
-	pubsub "github.com/libp2p/go-libp2p-pubsub"
-	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
 // Sync handles synchronization for the Log.
 type Sync struct {
-	ctx       context.Context
-	cancel    context.CancelFunc
-	ID        string           // Peer ID
-	pubsub    *pubsub.PubSub   // libp2p PubSub instance
-	log       *oplog.Log       // Actual Log structure
-	SyncedCh  chan SyncedEntry // Channel for synced entries
-	TopicName string           // PubSub topic name
-	topic     *pubsub.Topic    // Subscribed topic
-	sub       *pubsub.Subscription
-	mu        sync.Mutex      // Protects peer access
-	wg        sync.WaitGroup  // WaitGroup for goroutines
-	peerMap   map[string]bool // Tracks connected peers
+	ctx        context.Context
+	cancel     context.CancelFunc
+	ID         string           // Peer ID
+	transport  Transport        // Carries the heads to and from peers
+	log        *oplog.Log       // Actual Log structure
+	SyncedCh   chan SyncedEntry // Channel for synced entries
+	Events     chan Event       // Channel for changes in the replication state
+	TopicName  string           // PubSub topic name
+	protocol   protocol.ID      // Stream protocol for exchanging heads
+	topic      Topic            // Subscribed topic
+	sub        Subscription
+	peerEvents PeerEvents             // Peers joining and leaving the topic
+	mu         sync.Mutex             // Protects peer access
+	state      sync.RWMutex           // Serializes starting and stopping, held for reading while announcing heads
+	running    bool                   // Whether the Sync has been started and not stopped since
+	wg         sync.WaitGroup         // WaitGroup for goroutines
+	peerMap    map[string]bool        // Tracks connected peers
+	advertised map[string]*peerHeads  // Heads advertised by each peer
+	changed    chan struct{}          // Closed when the advertised heads change
+	onReject   func(Rejection)        // Called for every head that is rejected
+	identities oplog.IdentityProvider // Resolves the identities sent along with our heads
+}
+
+// Rejection describes a message or head received from a peer that was not accepted.
+type Rejection struct {
+	PeerID string // Peer the message was received from
+	Hash   string // Hash of the rejected entry, empty if the message could not be decoded
+	Err    error  // Why the message or entry was rejected
+}
+
+// Option configures optional settings of a Sync.
+type Option func(*Sync)
+
+// WithRejectHandler sets a function that is called for every message or head received from
+// a peer that is rejected, e.g. to count or report invalid and unauthorized entries. The
+// handler is called from the goroutines receiving heads and must not block.
+func WithRejectHandler(handler func(Rejection)) Option {
+	return func(s *Sync) {
+		s.onReject = handler
+	}
+}
+
+// IdentityStore is implemented by identity providers that can store the identities received
+// from peers, such as identities.Identities. AddIdentity only stores valid identities.
+type IdentityStore interface {
+	AddIdentity(data []byte) (*identitytypes.Identity, error)
+}
+
+// WithIdentityProvider sets the provider the identities that signed our heads are resolved
+// with, so that they can be sent along with the heads to peers that don't know them. If the
+// provider is also an IdentityStore, the identities sent by peers are stored in it before
+// their heads are verified.
+func WithIdentityProvider(identityProvider oplog.IdentityProvider) Option {
+	return func(s *Sync) {
+		s.identities = identityProvider
+	}
 }
 
 // SyncedEntry represents an entry received from a peer.
@@ -36,108 +78,141 @@ type SyncedEntry struct {
 	Entry  oplog.EncodedEntry
 }
 
-// NewSync initializes a new Sync instance for the Log.
-func NewSync(host host.Host, pubsub *pubsub.PubSub, log *oplog.Log) *Sync {
-	ctx, cancel := context.WithCancel(context.Background())
+// NewSync initializes a new Sync instance for the Log, exchanging heads with peers over the
+// transport, e.g. a Libp2pTransport or a MemoryTransport.
+func NewSync(transport Transport, log *oplog.Log, options ...Option) *Sync {
 	topicName := fmt.Sprintf("orbit-sync/%s", log.ID)
 
-	return &Sync{
-		ctx:       ctx,
-		cancel:    cancel,
-		ID:        host.ID().String(),
-		pubsub:    pubsub,
-		log:       log,
-		SyncedCh:  make(chan SyncedEntry, 10),
-		TopicName: topicName,
-		peerMap:   make(map[string]bool),
+	s := &Sync{
+		ID:         transport.ID(),
+		transport:  transport,
+		log:        log,
+		SyncedCh:   make(chan SyncedEntry, 10),
+		Events:     make(chan Event, eventBufferSize),
+		TopicName:  topicName,
+		protocol:   HeadsProtocol(log.ID),
+		peerMap:    make(map[string]bool),
+		advertised: make(map[string]*peerHeads),
+		changed:    make(chan struct{}),
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
-// Start begins the synchronization process.
+// Start begins the synchronization process. Peers joining the topic exchange their heads
+// with us over a direct stream, while new heads are announced on the topic. A stopped Sync
+// can be started again; starting a running Sync does nothing.
 func (s *Sync) Start() error {
-	var err error
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if s.running {
+		return nil
+	}
 
 	// Join the PubSub topic
-	s.topic, err = s.pubsub.Join(s.TopicName)
+	topic, err := s.transport.Join(s.TopicName)
 	if err != nil {
 		return fmt.Errorf("failed to join topic: %w", err)
 	}
 
 	// Subscribe to the topic
-	s.sub, err = s.topic.Subscribe()
+	sub, err := topic.Subscribe()
 	if err != nil {
+		topic.Close()
 		return fmt.Errorf("failed to subscribe to topic: %w", err)
 	}
 
-	log.Printf("Sync started: subscribed to topic %s", s.TopicName)
+	// Follow peers joining and leaving the topic
+	peerEvents, err := topic.PeerEvents()
+	if err != nil {
+		sub.Cancel()
+		topic.Close()
+		return fmt.Errorf("failed to handle topic events: %w", err)
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.topic, s.sub, s.peerEvents = topic, sub, peerEvents
+	s.running = true
 
-	// Track peer joining
-	go s.trackPeers()
+	s.transport.SetStreamHandler(s.protocol, s.handleStream)
 
-	s.wg.Add(1)
+	log.Printf("Sync started: subscribed to topic %s", s.TopicName)
+
+	s.wg.Add(2)
+	go s.handlePeerEvents()
 	go s.processMessages()
 
 	return nil
 }
 
-// Stop halts the synchronization process.
+// Stop halts the synchronization process. The peers and the heads they advertised are
+// forgotten, heads are exchanged with them again when the Sync is restarted. Stopping a
+// Sync that is not running does nothing.
 func (s *Sync) Stop() {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if !s.running {
+		return
+	}
+	s.running = false
+
+	s.transport.RemoveStreamHandler(s.protocol)
 	s.cancel()
 	s.wg.Wait()
 
+	s.peerEvents.Cancel()
 	s.sub.Cancel()
 	if err := s.topic.Close(); err != nil {
 		log.Printf("Error closing topic: %v", err)
 	}
+	s.topic, s.sub, s.peerEvents = nil, nil, nil
+
+	s.mu.Lock()
+	s.peerMap = make(map[string]bool)
+	s.advertised = make(map[string]*peerHeads)
+	s.notifyChangedLocked()
+	s.mu.Unlock()
 
 	log.Println("Sync stopped.")
 }
 
-// Add creates an entry in the log and broadcasts it to peers.
-func (s *Sync) Add(payload string) error {
-	s.log.Mu.Lock()
-	defer s.log.Mu.Unlock()
-
-	entry := oplog.EncodedEntry{
-		Entry: oplog.Entry{
-			ID:       s.log.ID,
-			Payload:  payload,
-			Clock:    s.log.Clock,
-			V:        1,
-			Identity: s.log.Identity.ID,
-		},
-		Bytes: []byte(payload),                // Placeholder for actual encoding
-		Hash:  fmt.Sprintf("%x", s.log.Clock), // Example hash generation
-	}
+// Running reports whether the Sync has been started and not stopped since.
+func (s *Sync) Running() bool {
+	s.state.RLock()
+	defer s.state.RUnlock()
+	return s.running
+}
 
-	// Add to the log
-	if err := s.log.Entries.Put(entry.Hash, entry.Bytes); err != nil {
-		return fmt.Errorf("failed to store entry in log: %w", err)
-	}
+// Add announces an entry appended to the log as a new head to the peers on the topic.
+// Nothing is announced while the Sync is not running; peers receive the entry with the
+// heads exchanged once it is started.
+func (s *Sync) Add(entry *oplog.EncodedEntry) error {
+	s.state.RLock()
+	defer s.state.RUnlock()
 
-	// Broadcast to peers
-	entryData := struct {
-		PeerID string
-		Entry  oplog.EncodedEntry
-	}{
-		PeerID: s.ID,
-		Entry:  entry,
+	if !s.running {
+		return nil
 	}
 
-	data, err := json.Marshal(entryData)
+	heads := []*oplog.EncodedEntry{entry}
+	data, err := encodeHeadsMessage(heads, s.headIdentities(heads))
 	if err != nil {
-		return fmt.Errorf("failed to marshal entry: %w", err)
+		return fmt.Errorf("failed to encode entry: %w", err)
 	}
 
 	if err := s.topic.Publish(s.ctx, data); err != nil {
 		return fmt.Errorf("failed to publish entry: %w", err)
 	}
 
-	log.Printf("Broadcasted entry: %s from peer: %s\n", payload, s.ID)
+	log.Printf("Broadcasted entry: %s from peer: %s\n", entry.Hash, s.ID)
 	return nil
 }
 
-// processMessages listens for incoming messages from PubSub and processes single head entries.
+// processMessages listens for heads messages from PubSub and processes the heads they carry.
 func (s *Sync) processMessages() {
 	defer s.wg.Done()
 
@@ -147,182 +222,207 @@ func (s *Sync) processMessages() {
 			if s.ctx.Err() != nil {
 				return // Context canceled
 			}
-			log.Printf("Error reading message: %v\n", err)
+			s.emit(SyncError{Err: fmt.Errorf("failed to read message: %w", err)})
 			continue
 		}
 
-		log.Printf("Received message from %s, expected self: %s\n", msg.ReceivedFrom, s.ID)
-
 		// Ignore messages from self
-		if msg.ReceivedFrom.String() == s.ID {
-			log.Println("Ignoring message from self")
+		peerID := msg.From
+		if peerID == s.ID {
 			continue
 		}
 
-		var payload struct {
-			PeerID string
-			Entry  oplog.EncodedEntry
-		}
-		if err := json.Unmarshal(msg.Data, &payload); err != nil {
-			log.Printf("Failed to unmarshal message: %v\n", err)
+		heads, identities, err := decodeHeadsMessage(msg.Data)
+		if err != nil {
+			s.reject(Rejection{PeerID: peerID, Err: fmt.Errorf("failed to decode message: %w", err)})
 			continue
 		}
 
-		log.Printf("Processing head entry: %s from peer: %s\n", payload.Entry.Payload, payload.PeerID)
+		s.storeIdentities(peerID, identities)
 
-		// Process the received head (log entry)
-		s.receiveHead(payload.PeerID, payload.Entry)
+		s.receiveHeads(peerID, heads, false)
 	}
 }
 
-// trackPeers listens for changes in peer list and tracks peers joining or leaving the topic.
-func (s *Sync) trackPeers() {
+// handlePeerEvents follows peers joining and leaving the topic. Heads are exchanged with
+// every peer that joins.
+func (s *Sync) handlePeerEvents() {
+	defer s.wg.Done()
+
 	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		default:
-			// Discover peers
-			peers := s.DiscoverPeers()
-			for _, p := range peers {
-				if _, exists := s.peerMap[p.String()]; !exists {
-					s.peerMap[p.String()] = true
-					s.PeerJoin(p.String()) // New peer has joined
-
-					// Send the head to the new peer
-					if err := s.sendHead(p.String()); err != nil {
-						log.Printf("Error sending head to new peer %s: %v", p.String(), err)
-					}
-				}
+		event, err := s.peerEvents.Next(s.ctx)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return // Context canceled
 			}
+			s.emit(SyncError{Err: fmt.Errorf("failed to read peer event: %w", err)})
+			continue
+		}
 
-			// Check for peers leaving (you might want to add a more robust method)
-			for peerID := range s.peerMap {
-				found := false
-				for _, p := range peers {
-					if peerID == p.String() {
-						found = true
-						break
-					}
-				}
+		peerID := event.PeerID
+		switch event.Type {
+		case PeerJoin:
+			s.mu.Lock()
+			known := s.peerMap[peerID]
+			s.peerMap[peerID] = true
+			if !known {
+				s.advertiseLocked(peerID, nil, nil, false)
+			}
+			s.mu.Unlock()
+			if known {
+				continue
+			}
+
+			s.PeerJoin(peerID)
 
-				if !found {
-					delete(s.peerMap, peerID)
-					s.PeerLeave(peerID) // Peer has left
+			// Exchange heads with the new peer
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				if err := s.exchangeHeads(peerID); err != nil && s.ctx.Err() == nil {
+					s.emit(SyncError{PeerID: peerID, Err: fmt.Errorf("failed to exchange heads: %w", err)})
 				}
+			}()
+
+		case PeerLeave:
+			s.mu.Lock()
+			known := s.peerMap[peerID]
+			delete(s.peerMap, peerID)
+			s.mu.Unlock()
+			if known {
+				s.forget(peerID)
+				s.PeerLeave(peerID)
 			}
-
-			// Sleep for a short period before re-checking the peers
-			<-time.After(2 * time.Second)
 		}
 	}
 }
 
-// PeerJoin method to handle new peer joins
+// PeerJoin reports a peer that joined the topic.
 func (s *Sync) PeerJoin(peerID string) {
-	// Log the event with additional context
 	log.Printf("Peer joined: %s at %s", peerID, time.Now().Format(time.RFC3339))
+	s.emit(PeerJoined{PeerID: peerID})
+}
 
-	// Optionally send a "join" message to the `SyncedCh` channel
-	joinEntry := oplog.EncodedEntry{
-		Entry: oplog.Entry{
-			ID:       fmt.Sprintf("%s-join", peerID),
-			Payload:  fmt.Sprintf("Peer %s has joined the network", peerID),
-			Clock:    s.log.Clock,
-			V:        1,
-			Identity: s.log.Identity.ID,
-		},
-		Bytes: []byte(fmt.Sprintf("Peer %s has joined the network", peerID)),
-		Hash:  fmt.Sprintf("%x", time.Now().UnixNano()),
-	}
+// PeerLeave reports a peer that left the topic.
+func (s *Sync) PeerLeave(peerID string) {
+	log.Printf("Peer left: %s at %s", peerID, time.Now().Format(time.RFC3339))
+	s.emit(PeerLeft{PeerID: peerID})
+}
+
+// DiscoverPeers lists peers connected to the topic, none while the Sync is not running.
+func (s *Sync) DiscoverPeers() []string {
+	s.state.RLock()
+	defer s.state.RUnlock()
 
-	// Send the join event as an entry to the synced channel
-	s.SyncedCh <- SyncedEntry{
-		PeerID: peerID,
-		Entry:  joinEntry,
+	if !s.running {
+		return nil
 	}
+	return s.topic.ListPeers()
 }
 
-// PeerLeave method to handle peer disconnections
-func (s *Sync) PeerLeave(peerID string) {
-	// Log the event with additional context
-	log.Printf("Peer left: %s at %s", peerID, time.Now().Format(time.RFC3339))
+// receiveHeads processes the heads received from a peer in a single message. Exchanged is
+// set when the message is the peer's full heads set sent over a heads stream. Every head is
+// recorded as advertised by the peer before the accepted ones are delivered, so a peer whose
+// heads were rejected is not taken for synced.
+func (s *Sync) receiveHeads(peerID string, heads []oplog.EncodedEntry, exchanged bool) {
+	rejected := make(map[string]bool)
+	var accepted []oplog.EncodedEntry
+	for _, head := range heads {
+		if err := s.verifyHead(peerID, &head); err != nil {
+			rejected[head.Hash] = true
+			continue
+		}
+		accepted = append(accepted, head)
+	}
 
-	// Optionally send a "leave" message to the `SyncedCh` channel
-	leaveEntry := oplog.EncodedEntry{
-		Entry: oplog.Entry{
-			ID:       fmt.Sprintf("%s-leave", peerID),
-			Payload:  fmt.Sprintf("Peer %s has left the network", peerID),
-			Clock:    s.log.Clock,
-			V:        1,
-			Identity: s.log.Identity.ID,
-		},
-		Bytes: []byte(fmt.Sprintf("Peer %s has left the network", peerID)),
-		Hash:  fmt.Sprintf("%x", time.Now().UnixNano()),
+	if len(heads) > 0 || exchanged {
+		s.advertise(peerID, heads, rejected, exchanged)
+	}
+	if len(accepted) == 0 {
+		return
 	}
 
-	// Send the leave event as an entry to the synced channel
-	s.SyncedCh <- SyncedEntry{
-		PeerID: peerID,
-		Entry:  leaveEntry,
+	hashes := make([]string, 0, len(accepted))
+	for _, head := range accepted {
+		s.receiveHead(peerID, head)
+		hashes = append(hashes, head.Hash)
 	}
+	s.emit(HeadsReceived{PeerID: peerID, Heads: hashes})
 }
 
-// DiscoverPeers lists peers connected to the topic.
-func (s *Sync) DiscoverPeers() []peer.ID {
-	return s.topic.ListPeers()
+// verifyHead checks a head received from a peer. The hash of the entry has been computed
+// from its bytes when it was decoded, so a peer cannot store it under another key. Entries
+// that do not belong to the log, are not correctly signed or that the access controller does
+// not allow are rejected.
+func (s *Sync) verifyHead(peerID string, entry *oplog.EncodedEntry) error {
+	if err := s.log.VerifyEntry(entry); err != nil {
+		s.reject(Rejection{PeerID: peerID, Hash: entry.Hash, Err: err})
+		return err
+	}
+	return nil
 }
 
-// sendHead sends the current head (latest log entry) to a specific peer.
-func (s *Sync) sendHead(peerID string) error {
-	s.log.Mu.Lock()
-	defer s.log.Mu.Unlock()
+// receiveHead passes a verified head (log entry) from a peer on to the receiver of SyncedCh,
+// which joins it into the log.
+func (s *Sync) receiveHead(peerID string, entry oplog.EncodedEntry) {
+	log.Printf("Processed head entry from peer %s: %s", peerID, entry.Hash)
 
-	// Get the latest log entry (head)
-	head := s.log.Head
+	// Notify listeners via the SyncedCh channel
+	s.deliver(SyncedEntry{PeerID: peerID, Entry: entry})
+}
 
-	// Ensure the head entry is the latest and correctly formed
-	if head == nil {
-		return fmt.Errorf("no head entry found")
+// headIdentities returns the encoded identities that signed the heads, leaving out the ones
+// that cannot be resolved.
+func (s *Sync) headIdentities(heads []*oplog.EncodedEntry) [][]byte {
+	if s.identities == nil {
+		return nil
 	}
 
-	entry := oplog.Encode(head.Entry)
+	seen := make(map[string]bool)
+	var identities [][]byte
+	for _, head := range heads {
+		if seen[head.Identity] {
+			continue
+		}
+		seen[head.Identity] = true
 
-	// Broadcast the head (entry) to the peer
-	entryData := struct {
-		PeerID string
-		Entry  oplog.EncodedEntry
-	}{
-		PeerID: s.ID,
-		Entry:  entry,
+		identity, err := s.identities.GetIdentity(head.Identity)
+		if err != nil || identity == nil {
+			continue
+		}
+		identities = append(identities, identity.Bytes)
 	}
+	return identities
+}
 
-	data, err := json.Marshal(entryData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal entry: %w", err)
+// storeIdentities stores the identities sent by a peer, so that the heads they signed can be
+// verified. Invalid identities are not stored, and the heads they signed are rejected.
+func (s *Sync) storeIdentities(peerID string, identities [][]byte) {
+	store, ok := s.identities.(IdentityStore)
+	if !ok {
+		return
 	}
 
-	if err := s.topic.Publish(s.ctx, data); err != nil {
-		return fmt.Errorf("failed to publish entry: %w", err)
+	for _, data := range identities {
+		if _, err := store.AddIdentity(data); err != nil {
+			log.Printf("Ignored identity from peer %s: %v", peerID, err)
+		}
 	}
-
-	log.Printf("Broadcasted head entry to peer %s: %s", peerID, head.Payload)
-	return nil
 }
 
-// receiveHead processes a received head (log entry) from a peer.
-func (s *Sync) receiveHead(peerID string, entry oplog.EncodedEntry) {
-	// Add the entry to the log
-	s.log.Mu.Lock()
-	if err := s.log.Entries.Put(entry.Hash, entry.Bytes); err != nil {
-		log.Printf("Failed to store entry from peer %s: %v", peerID, err)
-		s.log.Mu.Unlock()
-		return
+// reject reports a message or head that was not accepted from a peer.
+func (s *Sync) reject(rejection Rejection) {
+	log.Printf("Rejected head %s from peer %s: %v", rejection.Hash, rejection.PeerID, rejection.Err)
+	if s.onReject != nil {
+		s.onReject(rejection)
 	}
-	s.log.Mu.Unlock()
-
-	log.Printf("Processed head entry from peer %s: %s", peerID, entry.Payload)
+	s.emit(EntryRejected{Rejection: rejection})
+}
 
-	// Notify listeners via the SyncedCh channel
-	s.SyncedCh <- SyncedEntry{PeerID: peerID, Entry: entry}
+// deliver passes a synced entry on to the SyncedCh channel, giving up once the sync is stopped.
+func (s *Sync) deliver(synced SyncedEntry) {
+	select {
+	case s.SyncedCh <- synced:
+	case <-s.ctx.Done():
+	}
 }
@@ -1,13 +1,19 @@
 package syncutils_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"testing"
 	"time"
 
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	libp2p "github.com/libp2p/go-libp2p"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/stretchr/testify/assert"
@@ -39,6 +45,37 @@ func setupTestKeyStoreAndIdentity(t *testing.T, identityID string) (*keystore.Ke
 	return ks, identity
 }
 
+// nextEntry returns the next synced entry, or false if none is received within the timeout.
+func nextEntry(t *testing.T, ch <-chan syncutils.SyncedEntry, timeout time.Duration) (syncutils.SyncedEntry, bool) {
+	t.Helper()
+
+	select {
+	case synced := <-ch:
+		return synced, true
+	case <-time.After(timeout):
+		return syncutils.SyncedEntry{}, false
+	}
+}
+
+// waitForEvent returns the first event of the type T, skipping other events.
+func waitForEvent[T syncutils.Event](t *testing.T, sync *syncutils.Sync, timeout time.Duration) T {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-sync.Events:
+			if typed, ok := event.(T); ok {
+				return typed
+			}
+		case <-deadline:
+			var zero T
+			t.Fatalf("Timeout waiting for %T event", zero)
+			return zero
+		}
+	}
+}
+
 // createMockLog initializes a mock Log instance for testing.
 func createMockLog(t *testing.T, logID string, identityID string) *oplog.Log {
 	ks, identity := setupTestKeyStoreAndIdentity(t, identityID)
@@ -50,8 +87,8 @@ func createMockLog(t *testing.T, logID string, identityID string) *oplog.Log {
 
 	assert.Equal(t, logID, log.ID, "Log ID does not match")
 	assert.Equal(t, identity, log.Identity, "Log identity does not match")
-	assert.Equal(t, identity.ID, log.Clock.ID, "Clock ID does not match")
-	assert.Equal(t, 0, log.Clock.Time, "Clock time should be initialized to 0")
+	assert.Equal(t, identity.ID, log.Clock().ID, "Clock ID does not match")
+	assert.Equal(t, 0, log.Clock().Time, "Clock time should be initialized to 0")
 
 	return log
 }
@@ -74,7 +111,7 @@ func TestSyncStartStop(t *testing.T) {
 	log := createMockLog(t, "test-log", "test-identity")
 	assert.Equal(t, "test-log", log.ID)
 
-	sync := syncutils.NewSync(host1, ps, log)
+	sync := syncutils.NewSync(syncutils.NewLibp2pTransport(host1, ps), log)
 
 	err = sync.Start()
 	assert.NoError(t, err)
@@ -101,13 +138,15 @@ func TestSyncAddAndBroadcast(t *testing.T) {
 	log := createMockLog(t, "test-log", "test-identity")
 	assert.Equal(t, "test-log", log.ID)
 
-	sync := syncutils.NewSync(host1, ps, log)
+	sync := syncutils.NewSync(syncutils.NewLibp2pTransport(host1, ps), log)
 
 	err = sync.Start()
 	assert.NoError(t, err)
 
 	// Add an entry to the log and broadcast
-	err = sync.Add("test-entry")
+	entry, err := log.Append("test-entry")
+	require.NoError(t, err)
+	err = sync.Add(entry)
 	assert.NoError(t, err)
 
 	// No message should be processed because we ignore self-broadcasts
@@ -152,18 +191,19 @@ func TestSyncReceiveFromPeer(t *testing.T) {
 	err = hostPeer.Connect(ctx, peer.AddrInfo{ID: hostSelf.ID()})
 	require.NoError(t, err, "Failed to connect hostPeer to hostSelf")
 
-	// Create GossipSub instances for each host
-	psSelf, err := pubsub.NewGossipSub(ctx, hostSelf)
+	// Create GossipSub instances for each host. Flood publishing delivers heads added before
+	// the first heartbeat has built the mesh.
+	psSelf, err := pubsub.NewGossipSub(ctx, hostSelf, pubsub.WithFloodPublish(true))
 	require.NoError(t, err, "Failed to create GossipSub for self")
-	psPeer, err := pubsub.NewGossipSub(ctx, hostPeer)
+	psPeer, err := pubsub.NewGossipSub(ctx, hostPeer, pubsub.WithFloodPublish(true))
 	require.NoError(t, err, "Failed to create GossipSub for peer")
 
 	// Create logs and Sync instances
 	logSelf := createMockLog(t, "shared-log", "self-identity")
 	logPeer := createMockLog(t, "shared-log", "peer-identity")
 
-	syncSelf := syncutils.NewSync(hostSelf, psSelf, logSelf)
-	syncPeer := syncutils.NewSync(hostPeer, psPeer, logPeer)
+	syncSelf := syncutils.NewSync(syncutils.NewLibp2pTransport(hostSelf, psSelf), logSelf)
+	syncPeer := syncutils.NewSync(syncutils.NewLibp2pTransport(hostPeer, psPeer), logPeer)
 
 	// Start Sync instances
 	err = syncSelf.Start()
@@ -203,17 +243,18 @@ func TestSyncReceiveFromPeer(t *testing.T) {
 PeerDiscoveryComplete:
 
 	// Peer sends an entry
-	err = syncPeer.Add("peer-entry")
+	entry, err := logPeer.Append("peer-entry")
+	require.NoError(t, err, "Failed to append entry to logPeer")
+	err = syncPeer.Add(entry)
 	assert.NoError(t, err, "Failed to add entry to syncPeer")
 
 	// Verify the message is received by syncSelf
-	select {
-	case synced := <-syncSelf.SyncedCh:
-		assert.Equal(t, "peer-entry", synced.Entry.Payload, "Received entry payload mismatch")
-		assert.Equal(t, hostPeer.ID().String(), synced.PeerID, "Received PeerID mismatch")
-	case <-time.After(1 * time.Second): // Allow more time for PubSub propagation
-		t.Fatal("Timeout waiting for synced message from peer")
-	}
+	synced, ok := nextEntry(t, syncSelf.SyncedCh, 1*time.Second) // Allow more time for PubSub propagation
+	require.True(t, ok, "Timeout waiting for synced message from peer")
+	assert.Equal(t, "peer-entry", synced.Entry.Payload, "Received entry payload mismatch")
+	assert.Equal(t, entry.Hash, synced.Entry.Hash, "Received entry hash mismatch")
+	assert.Equal(t, entry.Bytes, synced.Entry.Bytes, "Received entry bytes mismatch")
+	assert.Equal(t, hostPeer.ID().String(), synced.PeerID, "Received PeerID mismatch")
 
 	syncSelf.Stop()
 	syncPeer.Stop()
@@ -260,8 +301,8 @@ func TestSyncPeerJoinLeave(t *testing.T) {
 	logSelf := createMockLog(t, "shared-log", "self-identity")
 	logPeer := createMockLog(t, "shared-log", "peer-identity")
 
-	syncSelf := syncutils.NewSync(hostSelf, psSelf, logSelf)
-	syncPeer := syncutils.NewSync(hostPeer, psPeer, logPeer)
+	syncSelf := syncutils.NewSync(syncutils.NewLibp2pTransport(hostSelf, psSelf), logSelf)
+	syncPeer := syncutils.NewSync(syncutils.NewLibp2pTransport(hostPeer, psPeer), logPeer)
 
 	// Start Sync instances
 	err = syncSelf.Start()
@@ -300,35 +341,31 @@ func TestSyncPeerJoinLeave(t *testing.T) {
 
 PeerDiscoveryComplete:
 
-	// Peer sends a "join" event
-	peerID1 := hostPeer.ID().String()
-	syncSelf.PeerJoin(peerID1)
-
-	// Verify the "join" event is received by syncSelf
+	// The peer joining the topic is reported without polling
+	peerID := hostPeer.ID().String()
 	select {
-	case synced := <-syncSelf.SyncedCh:
-		assert.Contains(t, synced.Entry.Payload, "has joined the network", "Join payload mismatch")
-		assert.Equal(t, peerID1, synced.PeerID, "PeerID mismatch in join event")
+	case event := <-syncSelf.Events:
+		assert.Equal(t, syncutils.PeerJoined{PeerID: peerID}, event, "Expected a join event")
 	case <-time.After(1 * time.Second):
-		t.Fatal("Timeout waiting for PeerJoin message")
+		t.Fatal("Timeout waiting for PeerJoin event")
 	}
 
-	// Peer sends a "leave" event
-	peerID2 := hostPeer.ID().String()
-	syncSelf.PeerLeave(peerID2)
+	// The peer leaving the topic is reported as well
+	syncPeer.Stop()
 
-	// Verify the "leave" event is received by syncSelf
 	select {
-	case synced := <-syncSelf.SyncedCh:
-		assert.Contains(t, synced.Entry.Payload, "has left the network", "Leave payload mismatch")
-		assert.Equal(t, peerID2, synced.PeerID, "PeerID mismatch in leave event")
-	case <-time.After(1 * time.Second):
-		t.Fatal("Timeout waiting for PeerLeave message")
+	case event := <-syncSelf.Events:
+		assert.Equal(t, syncutils.PeerLeft{PeerID: peerID}, event, "Expected a leave event")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for PeerLeave event")
 	}
 
+	// Peer joins and leaves are not delivered as entries
+	_, ok := nextEntry(t, syncSelf.SyncedCh, 100*time.Millisecond)
+	assert.False(t, ok, "Unexpected entry for a peer join or leave")
+
 	// Stop Sync instances
 	syncSelf.Stop()
-	syncPeer.Stop()
 }
 
 func TestSyncSendAndReceiveHead(t *testing.T) {
@@ -362,18 +399,19 @@ func TestSyncSendAndReceiveHead(t *testing.T) {
 	err = hostPeer.Connect(ctx, peer.AddrInfo{ID: hostSelf.ID()})
 	require.NoError(t, err, "Failed to connect hostPeer to hostSelf")
 
-	// Create GossipSub instances for each host
-	psSelf, err := pubsub.NewGossipSub(ctx, hostSelf)
+	// Create GossipSub instances for each host. Flood publishing delivers heads added before
+	// the first heartbeat has built the mesh.
+	psSelf, err := pubsub.NewGossipSub(ctx, hostSelf, pubsub.WithFloodPublish(true))
 	require.NoError(t, err, "Failed to create GossipSub for self")
-	psPeer, err := pubsub.NewGossipSub(ctx, hostPeer)
+	psPeer, err := pubsub.NewGossipSub(ctx, hostPeer, pubsub.WithFloodPublish(true))
 	require.NoError(t, err, "Failed to create GossipSub for peer")
 
 	// Create logs and Sync instances
 	logSelf := createMockLog(t, "shared-log", "self-identity")
 	logPeer := createMockLog(t, "shared-log", "peer-identity")
 
-	syncSelf := syncutils.NewSync(hostSelf, psSelf, logSelf)
-	syncPeer := syncutils.NewSync(hostPeer, psPeer, logPeer)
+	syncSelf := syncutils.NewSync(syncutils.NewLibp2pTransport(hostSelf, psSelf), logSelf)
+	syncPeer := syncutils.NewSync(syncutils.NewLibp2pTransport(hostPeer, psPeer), logPeer)
 
 	// Start Sync instances
 	err = syncSelf.Start()
@@ -413,32 +451,176 @@ func TestSyncSendAndReceiveHead(t *testing.T) {
 PeerDiscoveryComplete:
 
 	// Self sends a head to the peer
-	err = syncSelf.Add("test-head-entry")
+	selfHead, err := logSelf.Append("test-head-entry")
+	require.NoError(t, err, "Failed to append entry to logSelf")
+	err = syncSelf.Add(selfHead)
 	assert.NoError(t, err, "Failed to add entry to syncSelf")
 
 	// Verify the head is received by peer
-	select {
-	case synced := <-syncPeer.SyncedCh:
-		assert.Equal(t, "test-head-entry", synced.Entry.Payload, "Received head entry payload mismatch")
-		assert.Equal(t, hostSelf.ID().String(), synced.PeerID, "Received PeerID mismatch for head entry")
-	case <-time.After(1 * time.Second):
-		t.Fatal("Timeout waiting for head message from self")
-	}
+	synced, ok := nextEntry(t, syncPeer.SyncedCh, 1*time.Second)
+	require.True(t, ok, "Timeout waiting for head message from self")
+	assert.Equal(t, "test-head-entry", synced.Entry.Payload, "Received head entry payload mismatch")
+	assert.Equal(t, selfHead.Hash, synced.Entry.Hash, "Received head entry hash mismatch")
+	assert.Equal(t, hostSelf.ID().String(), synced.PeerID, "Received PeerID mismatch for head entry")
 
 	// Peer sends a head to the self
-	err = syncPeer.Add("peer-head-entry")
+	peerHead, err := logPeer.Append("peer-head-entry")
+	require.NoError(t, err, "Failed to append entry to logPeer")
+	err = syncPeer.Add(peerHead)
 	assert.NoError(t, err, "Failed to add entry to syncPeer")
 
 	// Verify the head is received by self
-	select {
-	case synced := <-syncSelf.SyncedCh:
-		assert.Equal(t, "peer-head-entry", synced.Entry.Payload, "Received head entry payload mismatch")
-		assert.Equal(t, hostPeer.ID().String(), synced.PeerID, "Received PeerID mismatch for head entry")
-	case <-time.After(1 * time.Second):
-		t.Fatal("Timeout waiting for head message from peer")
-	}
+	synced, ok = nextEntry(t, syncSelf.SyncedCh, 1*time.Second)
+	require.True(t, ok, "Timeout waiting for head message from peer")
+	assert.Equal(t, "peer-head-entry", synced.Entry.Payload, "Received head entry payload mismatch")
+	assert.Equal(t, peerHead.Hash, synced.Entry.Hash, "Received head entry hash mismatch")
+	assert.Equal(t, hostPeer.ID().String(), synced.PeerID, "Received PeerID mismatch for head entry")
 
 	// Stop Sync instances
 	syncSelf.Stop()
 	syncPeer.Stop()
 }
+
+// denyAccessController denies every entry.
+type denyAccessController struct{}
+
+func (denyAccessController) CanAppend(entry *oplog.EncodedEntry, identityProvider oplog.IdentityProvider) (bool, error) {
+	return false, nil
+}
+
+// staticIdentityProvider knows a fixed set of identities.
+type staticIdentityProvider map[string]*identitytypes.Identity
+
+func (p staticIdentityProvider) GetIdentity(hash string) (*identitytypes.Identity, error) {
+	return p[hash], nil
+}
+
+func (p staticIdentityProvider) VerifyIdentity(identity *identitytypes.Identity) bool {
+	return identity != nil
+}
+
+// collectRejections returns a reject handler that passes rejections on to the returned channel.
+func collectRejections() (syncutils.Option, <-chan syncutils.Rejection) {
+	rejections := make(chan syncutils.Rejection, 10)
+	return syncutils.WithRejectHandler(func(rejection syncutils.Rejection) {
+		rejections <- rejection
+	}), rejections
+}
+
+func TestSyncRejectsUnauthorizedHeads(t *testing.T) {
+	logPeer := createMockLog(t, "shared-log", "peer-identity")
+	peerHead, err := logPeer.Append("peer-entry")
+	require.NoError(t, err)
+
+	// Self does not allow the peer to write
+	ks, identity := setupTestKeyStoreAndIdentity(t, "self-identity")
+	provider := staticIdentityProvider{identity.Hash: identity, logPeer.Identity.Hash: logPeer.Identity}
+	logSelf, err := oplog.NewLog("shared-log", identity, storage.NewMemoryStorage(), ks,
+		oplog.WithAccessController(denyAccessController{}), oplog.WithIdentityProvider(provider))
+	require.NoError(t, err)
+
+	onReject, rejections := collectRejections()
+	hostSelf, syncSelf := setupSyncPeer(t, logSelf, onReject)
+	hostPeer, _ := setupSyncPeer(t, logPeer)
+
+	require.NoError(t, hostSelf.Connect(context.Background(), peer.AddrInfo{ID: hostPeer.ID(), Addrs: hostPeer.Addrs()}))
+
+	select {
+	case rejection := <-rejections:
+		assert.Equal(t, peerHead.Hash, rejection.Hash)
+		assert.Equal(t, hostPeer.ID().String(), rejection.PeerID)
+		assert.ErrorContains(t, rejection.Err, "not allowed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the head to be rejected")
+	}
+
+	rejected := waitForEvent[syncutils.EntryRejected](t, syncSelf, time.Second)
+	assert.Equal(t, peerHead.Hash, rejected.Hash)
+
+	_, ok := nextEntry(t, syncSelf.SyncedCh, 200*time.Millisecond)
+	assert.False(t, ok, "Expected the rejected head not to be delivered")
+	_, err = logSelf.Entries.Get(peerHead.Hash)
+	assert.Error(t, err, "Expected the rejected head not to be stored")
+}
+
+func TestSyncRejectsForgedHeads(t *testing.T) {
+	logSelf := createMockLog(t, "shared-log", "self-identity")
+	onReject, rejections := collectRejections()
+	hostSelf, syncSelf := setupSyncPeer(t, logSelf, onReject)
+
+	// A correctly signed entry whose payload is changed afterwards
+	logForger := createMockLog(t, "shared-log", "forger-identity")
+	head, err := logForger.Append("entry")
+	require.NoError(t, err)
+	head.Payload = "forged"
+	forged := oplog.Encode(head.Entry)
+
+	message, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "v", qp.Int(syncutils.MessageVersion))
+		qp.MapEntry(ma, "heads", qp.List(1, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.Bytes(forged.Bytes))
+		}))
+	})
+	require.NoError(t, err)
+	var data bytes.Buffer
+	require.NoError(t, dagcbor.Encode(message, &data))
+
+	// Send the forged head over a heads stream
+	hostForger, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer hostForger.Close()
+	require.NoError(t, hostForger.Connect(context.Background(), peer.AddrInfo{ID: hostSelf.ID(), Addrs: hostSelf.Addrs()}))
+
+	stream, err := hostForger.NewStream(context.Background(), hostSelf.ID(), syncutils.HeadsProtocol(logSelf.ID))
+	require.NoError(t, err)
+	_, err = stream.Write(append(binary.AppendUvarint(nil, uint64(data.Len())), data.Bytes()...))
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseWrite())
+	defer stream.Close()
+
+	select {
+	case rejection := <-rejections:
+		assert.Equal(t, forged.Hash, rejection.Hash)
+		assert.Equal(t, hostForger.ID().String(), rejection.PeerID)
+		assert.ErrorContains(t, rejection.Err, "invalid signature")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the head to be rejected")
+	}
+
+	_, ok := nextEntry(t, syncSelf.SyncedCh, 200*time.Millisecond)
+	assert.False(t, ok, "Expected the forged head not to be delivered")
+}
+
+func TestSyncRestart(t *testing.T) {
+	network := syncutils.NewMemoryNetwork()
+	logSelf := createMockLog(t, "shared-log", "self-identity")
+	logPeer := createMockLog(t, "shared-log", "peer-identity")
+
+	transport, err := network.NewTransport("self")
+	require.NoError(t, err)
+	syncSelf := syncutils.NewSync(transport, logSelf)
+	syncPeer := setupMemorySyncPeer(t, network, "peer", logPeer)
+
+	// Heads are not announced before the sync is started
+	entry, err := logSelf.Append("entry")
+	require.NoError(t, err)
+	require.NoError(t, syncSelf.Add(entry))
+	assert.False(t, syncSelf.Running())
+	assert.Empty(t, syncSelf.DiscoverPeers())
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, syncSelf.Start())
+		require.NoError(t, syncSelf.Start(), "Expected starting a running sync to do nothing")
+		assert.True(t, syncSelf.Running())
+
+		// The heads are exchanged every time the sync is started
+		synced, ok := nextEntry(t, syncPeer.SyncedCh, time.Second)
+		require.True(t, ok, "Timeout waiting for the exchanged head")
+		assert.Equal(t, entry.Hash, synced.Entry.Hash)
+
+		syncSelf.Stop()
+		syncSelf.Stop()
+		assert.False(t, syncSelf.Running())
+		assert.Empty(t, syncSelf.PeerHeads())
+	}
+}
@@ -0,0 +1,94 @@
+package syncutils
+
+import (
+	"sort"
+
+	"orbitdb/go-orbitdb/oplog"
+)
+
+// PeerHeads are the heads a peer has advertised for the log.
+type PeerHeads struct {
+	Heads     []string // Hashes of the current heads the peer sent, sorted
+	Rejected  []string // Hashes of the advertised heads that failed verification, sorted
+	Exchanged bool     // Whether the peer's full heads set has been received over a heads stream
+}
+
+// peerHeads tracks the heads advertised by a single peer, and whether each was rejected.
+type peerHeads struct {
+	heads     map[string]bool
+	exchanged bool
+}
+
+// PeerHeads returns the heads advertised by every peer that joined the topic or sent heads,
+// until the peer leaves the topic.
+func (s *Sync) PeerHeads() map[string]PeerHeads {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]PeerHeads, len(s.advertised))
+	for peerID, advertised := range s.advertised {
+		heads := make([]string, 0, len(advertised.heads))
+		var rejected []string
+		for hash, isRejected := range advertised.heads {
+			heads = append(heads, hash)
+			if isRejected {
+				rejected = append(rejected, hash)
+			}
+		}
+		sort.Strings(heads)
+		sort.Strings(rejected)
+		result[peerID] = PeerHeads{Heads: heads, Rejected: rejected, Exchanged: advertised.exchanged}
+	}
+	return result
+}
+
+// Changed returns a channel that is closed the next time the heads advertised by a peer,
+// or the peers on the topic, change.
+func (s *Sync) Changed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+// advertiseLocked records the heads the peer sent, along with the ones that were rejected.
+// When exchanged is set, the heads are the peer's full heads set and replace the heads it
+// advertised before. Otherwise they are added, and the heads they point to are superseded.
+// The caller must hold the lock.
+func (s *Sync) advertiseLocked(peerID string, heads []oplog.EncodedEntry, rejected map[string]bool, exchanged bool) {
+	advertised, ok := s.advertised[peerID]
+	if !ok || exchanged {
+		advertised = &peerHeads{heads: make(map[string]bool)}
+		s.advertised[peerID] = advertised
+	}
+	for _, head := range heads {
+		for _, next := range head.Next {
+			delete(advertised.heads, next)
+		}
+	}
+	for _, head := range heads {
+		advertised.heads[head.Hash] = rejected[head.Hash]
+	}
+	advertised.exchanged = advertised.exchanged || exchanged
+	s.notifyChangedLocked()
+}
+
+// advertise records the heads the peer sent, along with the ones that were rejected.
+func (s *Sync) advertise(peerID string, heads []oplog.EncodedEntry, rejected map[string]bool, exchanged bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advertiseLocked(peerID, heads, rejected, exchanged)
+}
+
+// forget removes the heads advertised by a peer that left.
+func (s *Sync) forget(peerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.advertised, peerID)
+	s.notifyChangedLocked()
+}
+
+// notifyChangedLocked wakes up everyone waiting on Changed. The caller must hold the lock.
+func (s *Sync) notifyChangedLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,94 @@
+package syncutils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"orbitdb/go-orbitdb/syncutils"
+)
+
+// waitForPeerHeads waits until the heads advertised by the peer match the condition.
+func waitForPeerHeads(t *testing.T, sync *syncutils.Sync, peerID string, condition func(syncutils.PeerHeads) bool) syncutils.PeerHeads {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		changed := sync.Changed()
+		if heads, ok := sync.PeerHeads()[peerID]; ok && condition(heads) {
+			return heads
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("Timeout waiting for the heads of peer %s, got %v", peerID, sync.PeerHeads())
+		}
+	}
+}
+
+func exchanged(heads syncutils.PeerHeads) bool {
+	return heads.Exchanged
+}
+
+func TestSyncPeerHeads(t *testing.T) {
+	logSelf := createMockLog(t, "shared-log", "self-identity")
+	logPeer := createMockLog(t, "shared-log", "peer-identity")
+
+	_, err := logPeer.Append("peer-entry1")
+	require.NoError(t, err)
+	peerHead, err := logPeer.Append("peer-entry2")
+	require.NoError(t, err)
+
+	hostSelf, syncSelf := setupSyncPeer(t, logSelf)
+	hostPeer, syncPeer := setupSyncPeer(t, logPeer)
+	assert.Empty(t, syncSelf.PeerHeads())
+
+	require.NoError(t, hostSelf.Connect(context.Background(), peer.AddrInfo{ID: hostPeer.ID(), Addrs: hostPeer.Addrs()}))
+
+	// Self learns the peer's heads set
+	heads := waitForPeerHeads(t, syncSelf, hostPeer.ID().String(), exchanged)
+	assert.Equal(t, syncutils.PeerHeads{Heads: []string{peerHead.Hash}, Exchanged: true}, heads)
+
+	// The peer learns that self has no heads
+	heads = waitForPeerHeads(t, syncPeer, hostSelf.ID().String(), exchanged)
+	assert.Empty(t, heads.Heads)
+
+	// Heads announced later are added to the advertised heads
+	selfHead, err := logSelf.Append("self-entry")
+	require.NoError(t, err)
+	require.NoError(t, syncSelf.Add(selfHead))
+
+	heads = waitForPeerHeads(t, syncPeer, hostSelf.ID().String(), func(heads syncutils.PeerHeads) bool {
+		return len(heads.Heads) == 1
+	})
+	assert.Equal(t, []string{selfHead.Hash}, heads.Heads)
+
+	// Heads that are superseded by a later head are no longer advertised
+	nextHead, err := logSelf.Append("self-entry2")
+	require.NoError(t, err)
+	require.NoError(t, syncSelf.Add(nextHead))
+
+	heads = waitForPeerHeads(t, syncPeer, hostSelf.ID().String(), func(heads syncutils.PeerHeads) bool {
+		return len(heads.Heads) == 1 && heads.Heads[0] == nextHead.Hash
+	})
+	assert.Empty(t, heads.Rejected)
+
+	// A peer that leaves is forgotten
+	syncPeer.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		changed := syncSelf.Changed()
+		if _, ok := syncSelf.PeerHeads()[hostPeer.ID().String()]; !ok {
+			break
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatal("Timeout waiting for the peer to be forgotten")
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package syncutils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ErrTransportClosed is returned when reading from a topic, subscription or peer events
+// that have been closed.
+var ErrTransportClosed = errors.New("transport closed")
+
+// Transport carries the messages of a Sync between peers: it publishes to and subscribes
+// to topics, reports the peers joining and leaving a topic and opens direct streams to them.
+type Transport interface {
+	// ID returns the ID of the local peer.
+	ID() string
+
+	// Join joins the topic, so that messages can be published to it.
+	Join(topic string) (Topic, error)
+
+	// SetStreamHandler handles the streams peers open with the protocol.
+	SetStreamHandler(protocol protocol.ID, handler StreamHandler)
+
+	// RemoveStreamHandler stops handling the streams opened with the protocol.
+	RemoveStreamHandler(protocol protocol.ID)
+
+	// NewStream opens a stream with the protocol to the peer.
+	NewStream(ctx context.Context, peerID string, protocol protocol.ID) (Stream, error)
+}
+
+// Topic is a topic joined through a Transport.
+type Topic interface {
+	// Publish sends the data to the peers subscribed to the topic.
+	Publish(ctx context.Context, data []byte) error
+
+	// Subscribe returns the messages published to the topic.
+	Subscribe() (Subscription, error)
+
+	// PeerEvents returns the peers joining and leaving the topic. The peers subscribed
+	// to the topic when it is called are reported as joined first.
+	PeerEvents() (PeerEvents, error)
+
+	// ListPeers lists the peers subscribed to the topic.
+	ListPeers() []string
+
+	// Close leaves the topic. The subscriptions and peer events must be canceled first.
+	Close() error
+}
+
+// Subscription receives the messages published to a topic.
+type Subscription interface {
+	// Next waits for the next message.
+	Next(ctx context.Context) (*Message, error)
+
+	// Cancel ends the subscription.
+	Cancel()
+}
+
+// Message is a message published to a topic.
+type Message struct {
+	From string // Peer that published the message
+	Data []byte
+}
+
+// PeerEventType tells whether a peer joined or left a topic.
+type PeerEventType int
+
+const (
+	PeerJoin PeerEventType = iota
+	PeerLeave
+)
+
+// PeerEvent reports a peer joining or leaving a topic.
+type PeerEvent struct {
+	Type   PeerEventType
+	PeerID string
+}
+
+// PeerEvents receives the peers joining and leaving a topic.
+type PeerEvents interface {
+	// Next waits for the next peer event.
+	Next(ctx context.Context) (PeerEvent, error)
+
+	// Cancel stops receiving peer events.
+	Cancel()
+}
+
+// Stream is a bidirectional stream to a peer.
+type Stream interface {
+	io.ReadWriteCloser
+
+	// CloseWrite closes the writing side, the peer reads io.EOF once it has read everything.
+	CloseWrite() error
+
+	// Reset aborts the stream in both directions.
+	Reset() error
+
+	// SetDeadline sets the time after which reads and writes fail.
+	SetDeadline(t time.Time) error
+}
+
+// StreamHandler handles a stream opened by the peer.
+type StreamHandler func(peerID string, stream Stream)